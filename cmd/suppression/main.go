@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mclyashko/IPORPIS/internal/bounce"
+	"github.com/mclyashko/IPORPIS/internal/config"
+	"github.com/mclyashko/IPORPIS/internal/suppression"
+)
+
+const usage = `Управление списком исключений рассылки.
+
+Использование:
+  suppression [-store ХРАНИЛИЩЕ] import ПУТЬ...   добавить адреса из CSV-файлов (*.csv) или из возвратов
+                                                 и жалоб: файлов .eml, mbox-файлов и каталогов Maildir
+  suppression [-store ХРАНИЛИЩЕ] export [ФАЙЛ]     выгрузить список в CSV, по умолчанию в стандартный вывод
+  suppression [-store ХРАНИЛИЩЕ] remove АДРЕС...   убрать адреса из списка
+  suppression [-store ХРАНИЛИЩЕ] clear             очистить список целиком
+
+ХРАНИЛИЩЕ — путь к CSV-файлу или строка подключения postgres://; по умолчанию SUPPRESSION_STORE из .env.
+`
+
+func main() {
+	log.SetFlags(0)
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+	}
+	location := flag.String("store", "", "путь к CSV-файлу или строка подключения postgres://")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *location == "" {
+		// Без флага хранилище берется из .env
+		if cfg, err := (&config.DotenvConfigLoader{}).Load(); err == nil {
+			*location = cfg.Email.SuppressionStore
+		}
+	}
+	if *location == "" {
+		log.Fatal("Suppression store is not set: use -store or SUPPRESSION_STORE")
+	}
+
+	ctx := context.Background()
+	store, err := suppression.Open(ctx, *location)
+	if err != nil {
+		log.Fatalf("Cant open suppression store: %v", err)
+	}
+	defer store.Close()
+
+	command, args := flag.Arg(0), flag.Args()[1:]
+	switch command {
+	case "import":
+		err = importEntries(ctx, store, args)
+	case "export":
+		err = exportEntries(ctx, store, args)
+	case "remove":
+		err = removeEntries(ctx, store, args)
+	case "clear":
+		err = store.Clear(ctx)
+		if err == nil {
+			log.Println("Список исключений очищен")
+		}
+	default:
+		store.Close()
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		store.Close()
+		log.Fatal(err)
+	}
+}
+
+// importEntries добавляет адреса из CSV-файлов, возвратов и жалоб. Ошибки разбора отдельных писем
+// выводятся, но не мешают добавить адреса из остальных.
+func importEntries(ctx context.Context, store suppression.Store, paths []string) error {
+	if len(paths) == 0 {
+		return fmt.Errorf("import: no paths given")
+	}
+
+	var entries []suppression.Entry
+	for _, path := range paths {
+		if strings.EqualFold(filepath.Ext(path), ".csv") {
+			found, err := readCSVFile(path)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, found...)
+			continue
+		}
+
+		reports, err := bounce.ParsePath(path)
+		if err != nil {
+			log.Printf("Не все письма удалось разобрать: %v", err)
+		}
+		found := suppression.FromReports(reports)
+		log.Printf("%s: найдено возвратов и жалоб: %d, из них окончательных: %d", path, len(reports), len(found))
+		entries = append(entries, found...)
+	}
+
+	if len(entries) == 0 {
+		log.Println("Адресов для исключения нет")
+		return nil
+	}
+	if err := store.Add(ctx, entries...); err != nil {
+		return err
+	}
+	log.Printf("Добавлено в список исключений: %d", len(entries))
+	return nil
+}
+
+func readCSVFile(path string) ([]suppression.Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries, err := suppression.ReadCSV(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return entries, nil
+}
+
+// exportEntries выгружает список в CSV-файл или в стандартный вывод
+func exportEntries(ctx context.Context, store suppression.Store, args []string) error {
+	entries, err := store.Entries(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		return suppression.WriteCSV(os.Stdout, entries)
+	}
+
+	f, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	if err := suppression.WriteCSV(f, entries); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	log.Printf("Выгружено адресов: %d", len(entries))
+	return nil
+}
+
+func removeEntries(ctx context.Context, store suppression.Store, addresses []string) error {
+	if len(addresses) == 0 {
+		return fmt.Errorf("remove: no addresses given")
+	}
+	if err := store.Remove(ctx, addresses...); err != nil {
+		return err
+	}
+	log.Printf("Убрано из списка исключений: %d", len(addresses))
+	return nil
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"log"
+	"strconv"
 	"time"
 
 	"fyne.io/fyne/v2"
@@ -14,6 +16,9 @@ import (
 	"github.com/mclyashko/IPORPIS/internal/email"
 )
 
+// sendTimeout ограничивает отправку одного письма, чтобы зависший сервер не блокировал окно навсегда
+const sendTimeout = 2 * time.Minute
+
 func getConfig() config.App {
 	configLoader := &config.DotenvConfigLoader{}
 
@@ -42,16 +47,26 @@ func createUI(es email.Sender) {
 	messageEntry.SetPlaceHolder("Введите текст сообщения")
 
 	// Кнопка отправки
-	sendButton := widget.NewButton("Отправить", func() {
+	var sendButton *widget.Button
+	sendButton = widget.NewButton("Отправить", func() {
 		recipient := toEntry.Text
 		subject := subjectEntry.Text
 		message := messageEntry.Text
 
-		if err := es.Send(recipient, subject, message, []string{}); err != nil {
-			log.Printf("Error sending email: %v", err)
-		} else {
-			log.Println("Email sent!")
-		}
+		// Отправляем в фоне, чтобы окно не зависало, пока идет отправка и повторные попытки
+		sendButton.Disable()
+		go func() {
+			defer sendButton.Enable()
+
+			ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+			defer cancel()
+
+			if err := es.SendContext(ctx, recipient, subject, message, []string{}); err != nil {
+				log.Printf("Error sending email: %v", err)
+			} else {
+				log.Println("Email sent!")
+			}
+		}()
 	})
 
 	// Организуем вертикальный контейнер
@@ -78,15 +93,27 @@ func main() {
 	rand.Seed(uint64(time.Now().UnixNano()))
 
 	cfg := getConfig()
-	es, err := email.NewSMTPSender(
-		cfg.Email.Host,
-		cfg.Email.Port,
-		cfg.Email.Username,
-		cfg.Email.Password,
-	)
+	// Локальный транспорт из .env позволяет проверить окно без отправки настоящих писем
+	sender, err := email.NewLocalSenderFromConfig(cfg.Email)
 	if err != nil {
-		log.Fatalf("Cant get SMTP sender: %v", err)
+		log.Fatalf("Cant get local sender: %v", err)
+	}
+
+	maxAttempts := email.DefaultMaxAttempts
+	if cfg.Email.MaxAttempts != "" {
+		maxAttempts, err = strconv.Atoi(cfg.Email.MaxAttempts)
+		if err != nil {
+			log.Fatalf("Invalid SMTP max attempts: %v", err)
+		}
+	}
+
+	if sender == nil {
+		sender, err = email.NewSMTPSenderFromConfig(cfg.Email)
+		if err != nil {
+			log.Fatalf("Cant get SMTP sender: %v", err)
+		}
 	}
+	es := email.NewRetryingSender(sender, email.WithMaxAttempts(maxAttempts))
 
 	createUI(es)
 }
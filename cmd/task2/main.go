@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
+	"sort"
+	"strings"
 	"time"
 
 	"fyne.io/fyne/v2"
@@ -12,14 +17,33 @@ import (
 	"fyne.io/fyne/v2/widget"
 	"golang.org/x/exp/rand"
 
+	"github.com/mclyashko/IPORPIS/internal/config"
 	"github.com/mclyashko/IPORPIS/internal/email"
 )
 
+// sendTimeout ограничивает отправку одного письма, чтобы зависший сервер не блокировал окно навсегда
+const sendTimeout = 2 * time.Minute
+
 // Первый этап: Ввод данных для создания SMTP Sender
-func createSenderUI(a fyne.App, w fyne.Window) {
+func createSenderUI(a fyne.App, w fyne.Window, protectors map[string]protector) {
 	serverEntry := widget.NewSelect([]string{"smtp.rambler.ru"}, nil)
 	serverEntry.SetSelected("smtp.rambler.ru")
 
+	portEntry := widget.NewEntry()
+	portEntry.SetPlaceHolder("Введите порт")
+
+	// Режим защиты соединения; при смене режима подставляем его стандартный порт
+	var securityNames []string
+	for _, security := range email.Securities() {
+		securityNames = append(securityNames, security.String())
+	}
+	securityEntry := widget.NewSelect(securityNames, func(selected string) {
+		if security, err := email.ParseSecurity(selected); err == nil {
+			portEntry.SetText(security.DefaultPort())
+		}
+	})
+	securityEntry.SetSelected(email.SecurityTLS.String())
+
 	fromEntry := widget.NewEntry()
 	fromEntry.SetPlaceHolder("Введите адрес отправителя")
 
@@ -28,28 +52,39 @@ func createSenderUI(a fyne.App, w fyne.Window) {
 
 	continueButton := widget.NewButton("Продолжить", func() {
 		server := serverEntry.Selected
+		port := portEntry.Text
 		emailAddr := fromEntry.Text
 		password := passwordEntry.Text
 
-		if server == "" || emailAddr == "" || password == "" {
+		if server == "" || port == "" || emailAddr == "" || password == "" {
 			dialog.ShowError(fmt.Errorf("ошибка: Все поля должны быть заполнены"), w)
 			return
 		}
 
-		sender, err := email.NewSMTPSender(server, "465", emailAddr, password)
+		security, err := email.ParseSecurity(securityEntry.Selected)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("ошибка: Неизвестный режим защиты соединения"), w)
+			return
+		}
+
+		sender, err := email.NewSMTPSender(server, port, emailAddr, password, email.WithSecurity(security))
 		if err != nil {
 			dialog.ShowError(fmt.Errorf("ошибка: Не удалось создать SMTP-соединение"), w)
 			log.Printf("Error creating SMTP sender: %v", err)
 			return
 		}
 
-		// Переход ко второму этапу
-		createEmailUI(a, w, sender)
+		// Переход ко второму этапу; временные ошибки сервера повторяются автоматически
+		createEmailUI(a, w, email.NewRetryingSender(sender), protectors)
 	})
 
 	content := container.NewVBox(
 		widget.NewLabel("Сервер:"),
 		serverEntry,
+		widget.NewLabel("Защита соединения:"),
+		securityEntry,
+		widget.NewLabel("Порт:"),
+		portEntry,
 		widget.NewLabel("Адрес отправителя:"),
 		fromEntry,
 		widget.NewLabel("Пароль:"),
@@ -63,7 +98,7 @@ func createSenderUI(a fyne.App, w fyne.Window) {
 }
 
 // Второй этап: Ввод данных для отправки письма
-func createEmailUI(_ fyne.App, w fyne.Window, sender email.Sender) {
+func createEmailUI(_ fyne.App, w fyne.Window, sender email.Sender, protectors map[string]protector) {
 	toEntry := widget.NewEntry()
 	toEntry.SetPlaceHolder("Введите адрес получателя")
 
@@ -114,7 +149,44 @@ func createEmailUI(_ fyne.App, w fyne.Window, sender email.Sender) {
 		}, w).Show()
 	})
 
-	sendButton := widget.NewButton("Отправить", func() {
+	// Подпись и шифрование доступны, только если в .env заданы ключи S/MIME или PGP
+	var protectorNames []string
+	for name := range protectors {
+		protectorNames = append(protectorNames, name)
+	}
+	sort.Strings(protectorNames)
+	protectorSelect := widget.NewSelect(protectorNames, nil)
+	signCheck := widget.NewCheck("Подписать", nil)
+	encryptCheck := widget.NewCheck("Зашифровать", nil)
+	if len(protectorNames) > 0 {
+		protectorSelect.SetSelected(protectorNames[0])
+	} else {
+		protectorSelect.Disable()
+		signCheck.Disable()
+		encryptCheck.Disable()
+	}
+
+	// Для договорной переписки сервер может подтвердить доставку уведомлением DSN
+	dsnCheck := widget.NewCheck("Запросить уведомление о доставке", nil)
+	reportButton := widget.NewButton("Открыть уведомление о доставке", func() {
+		dialog.NewFileOpen(func(file fyne.URIReadCloser, err error) {
+			if err != nil || file == nil {
+				return
+			}
+			defer file.Close()
+
+			report, err := email.ParseDeliveryReport(file)
+			if err != nil {
+				dialog.ShowError(fmt.Errorf("ошибка: Файл не является уведомлением о доставке"), w)
+				log.Printf("Error parsing delivery report: %v", err)
+				return
+			}
+			dialog.ShowInformation("Уведомление о доставке", formatDeliveryReport(report), w)
+		}, w).Show()
+	})
+
+	var sendButton *widget.Button
+	sendButton = widget.NewButton("Отправить", func() {
 		recipient := toEntry.Text
 		subject := subjectEntry.Text
 		message := messageEntry.Text
@@ -124,12 +196,57 @@ func createEmailUI(_ fyne.App, w fyne.Window, sender email.Sender) {
 			return
 		}
 
-		if err := sender.Send(recipient, subject, message, attachments); err != nil {
-			dialog.ShowError(fmt.Errorf("ошибка: Не удалось отправить письмо"), w)
-			log.Printf("Error sending email: %v", err)
-		} else {
-			dialog.ShowInformation("Успех", "Письмо успешно отправлено", w)
+		msg := &email.Message{
+			To:      []email.Address{{Address: recipient}},
+			Subject: subject,
+			Parts:   []email.Part{email.TextPart(message)},
+		}
+		for _, path := range attachments {
+			msg.Attachments = append(msg.Attachments, email.Attachment{Path: path})
+		}
+
+		if signCheck.Checked || encryptCheck.Checked {
+			protection, err := protectors[protectorSelect.Selected].Protection(signCheck.Checked, encryptCheck.Checked)
+			if err != nil {
+				dialog.ShowError(fmt.Errorf("ошибка: %s не настроен для выбранной защиты: %v", protectorSelect.Selected, err), w)
+				return
+			}
+			msg.Protection = protection
+		}
+		requestDSN := dsnCheck.Checked
+		if requestDSN {
+			msg.DSN = &email.DSN{
+				Notify: email.NotifySuccess | email.NotifyFailure | email.NotifyDelay,
+				Return: email.ReturnHeaders,
+			}
 		}
+
+		// Отправляем в фоне, чтобы окно не зависало, пока идет отправка и повторные попытки
+		sendButton.Disable()
+		go func() {
+			defer sendButton.Enable()
+
+			ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+			defer cancel()
+
+			result, err := sender.SendMessage(ctx, msg)
+			switch {
+			case errors.Is(err, email.ErrRecipientKeyNotFound):
+				// Без ключа получателя письмо не отправляется вовсе, чтобы не уйти открытым
+				dialog.ShowError(fmt.Errorf("ошибка: Нет ключа шифрования для %s, письмо не отправлено", recipient), w)
+				log.Printf("Error sending email: %v", err)
+			case err != nil:
+				dialog.ShowError(fmt.Errorf("ошибка: Не удалось отправить письмо"), w)
+				log.Printf("Error sending email: %v", err)
+			case requestDSN && !result.DSN:
+				dialog.ShowInformation("Успех", "Письмо успешно отправлено, но сервер не поддерживает уведомления о доставке", w)
+			case requestDSN:
+				// По Message-ID уведомление потом сопоставляется с письмом
+				dialog.ShowInformation("Успех", fmt.Sprintf("Письмо успешно отправлено, уведомление о доставке запрошено\nMessage-ID: %s", result.MessageID), w)
+			default:
+				dialog.ShowInformation("Успех", "Письмо успешно отправлено", w)
+			}
+		}()
 	})
 
 	content := container.NewVBox(
@@ -141,13 +258,78 @@ func createEmailUI(_ fyne.App, w fyne.Window, sender email.Sender) {
 		messageEntry,
 		fileButton,
 		fileList,
+		widget.NewLabel("Защита письма:"),
+		protectorSelect,
+		container.NewHBox(signCheck, encryptCheck),
+		dsnCheck,
 		sendButton,
+		reportButton,
 	)
 
 	w.SetContent(content)
 	w.Resize(fyne.NewSize(600, 400))
 }
 
+// formatDeliveryReport описывает уведомление о доставке: к какому письму оно относится и что стало с каждым получателем
+func formatDeliveryReport(report *email.DeliveryReport) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Message-ID: %s\n", report.MessageID)
+	for _, rcpt := range report.Recipients {
+		status := map[email.DSNAction]string{
+			email.ActionDelivered: "доставлено",
+			email.ActionFailed:    "не доставлено",
+			email.ActionDelayed:   "доставка задерживается",
+			email.ActionRelayed:   "передано дальше без подтверждения",
+			email.ActionExpanded:  "доставлено в список рассылки",
+		}[rcpt.Action]
+		if status == "" {
+			status = string(rcpt.Action)
+		}
+		fmt.Fprintf(&b, "%s: %s (%s)", rcpt.FinalRecipient, status, rcpt.Status)
+		if rcpt.DiagnosticCode != "" {
+			fmt.Fprintf(&b, " — %s", rcpt.DiagnosticCode)
+		}
+		b.WriteString("\n")
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// protector — способ защиты писем, настроенный в .env: S/MIME или PGP/MIME
+type protector interface {
+	Protection(sign, encrypt bool) (email.Protection, error)
+}
+
+// loadProtectors возвращает способы защиты писем, для которых в .env заданы сертификаты или ключи
+func loadProtectors(cfg config.Email) map[string]protector {
+	result := make(map[string]protector)
+
+	if cfg.SMIMECertFile != "" || cfg.SMIMEKeyFile != "" || cfg.SMIMECertsDir != "" {
+		smime, err := email.NewSMIME(email.SMIMEOptions{
+			CertFile: cfg.SMIMECertFile,
+			KeyFile:  cfg.SMIMEKeyFile,
+			CertsDir: cfg.SMIMECertsDir,
+		})
+		if err != nil {
+			log.Fatalf("Invalid S/MIME settings: %v", err)
+		}
+		result["S/MIME"] = smime
+	}
+
+	if cfg.PGPKeyFile != "" || cfg.PGPKeyringFile != "" {
+		pgp, err := email.NewPGP(email.PGPOptions{
+			KeyFile:     cfg.PGPKeyFile,
+			Passphrase:  cfg.PGPPassphrase,
+			KeyringFile: cfg.PGPKeyringFile,
+		})
+		if err != nil {
+			log.Fatalf("Invalid PGP settings: %v", err)
+		}
+		result["PGP/MIME"] = pgp
+	}
+
+	return result
+}
+
 // Основная функция
 func main() {
 	rand.Seed(uint64(time.Now().UnixNano()))
@@ -155,8 +337,27 @@ func main() {
 	a := app.NewWithID("com.mclyashko.email_sender")
 	w := a.NewWindow("Email Sender")
 
-	// Начинаем с первого этапа
-	createSenderUI(a, w)
+	// .env необязателен: без него письма отправляются через SMTP-сервер из формы без подписи и шифрования.
+	// Ошибка в самом файле не игнорируется, иначе защита писем молча отключилась бы.
+	cfg, err := (&config.DotenvConfigLoader{}).Load()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Fatalf("Error loading config: %v", err)
+	}
+	available := loadProtectors(cfg.Email)
+
+	sender, err := email.NewLocalSenderFromConfig(cfg.Email)
+	if err != nil {
+		log.Fatalf("Cant get local sender: %v", err)
+	}
+
+	// С локальным транспортом из .env письма сохраняются без SMTP-сервера, и первый этап не нужен
+	if sender != nil {
+		createEmailUI(a, w, sender, available)
+		w.Show()
+	} else {
+		// Начинаем с первого этапа
+		createSenderUI(a, w, available)
+	}
 
 	a.Run()
 }
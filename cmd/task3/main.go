@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/csv"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"fyne.io/fyne/v2"
@@ -14,14 +19,38 @@ import (
 	"fyne.io/fyne/v2/widget"
 	"golang.org/x/exp/rand"
 
+	"github.com/mclyashko/IPORPIS/internal/config"
 	"github.com/mclyashko/IPORPIS/internal/email"
+	"github.com/mclyashko/IPORPIS/internal/suppression"
+)
+
+// Значения по умолчанию, если квоты и пауза в .env не заданы: не чаще одного письма в 5 секунд
+// плюс случайная пауза до 10 секунд, чтобы провайдер не счел рассылку спамом
+const (
+	defaultRateLimit  = "1/5s"
+	defaultRateJitter = 10 * time.Second
 )
 
 // Первый этап: Ввод данных для создания SMTP Sender
-func createSenderUI(a fyne.App, w fyne.Window) {
+func createSenderUI(a fyne.App, w fyne.Window, dkim *email.DKIMSigner, limits []email.RateLimitOption, protection email.Protection, suppressions email.SuppressionList, links email.UnsubscribeLinks) {
 	serverEntry := widget.NewSelect([]string{"smtp.rambler.ru"}, nil)
 	serverEntry.SetSelected("smtp.rambler.ru")
 
+	portEntry := widget.NewEntry()
+	portEntry.SetPlaceHolder("Введите порт")
+
+	// Режим защиты соединения; при смене режима подставляем его стандартный порт
+	var securityNames []string
+	for _, security := range email.Securities() {
+		securityNames = append(securityNames, security.String())
+	}
+	securityEntry := widget.NewSelect(securityNames, func(selected string) {
+		if security, err := email.ParseSecurity(selected); err == nil {
+			portEntry.SetText(security.DefaultPort())
+		}
+	})
+	securityEntry.SetSelected(email.SecurityTLS.String())
+
 	fromEntry := widget.NewEntry()
 	fromEntry.SetPlaceHolder("Введите адрес отправителя")
 
@@ -30,28 +59,48 @@ func createSenderUI(a fyne.App, w fyne.Window) {
 
 	continueButton := widget.NewButton("Продолжить", func() {
 		server := serverEntry.Selected
+		port := portEntry.Text
 		emailAddr := fromEntry.Text
 		password := passwordEntry.Text
 
-		if server == "" || emailAddr == "" || password == "" {
+		if server == "" || port == "" || emailAddr == "" || password == "" {
 			dialog.ShowError(fmt.Errorf("ошибка: Все поля должны быть заполнены"), w)
 			return
 		}
 
-		sender, err := email.NewSMTPSender(server, "465", emailAddr, password)
+		security, err := email.ParseSecurity(securityEntry.Selected)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("ошибка: Неизвестный режим защиты соединения"), w)
+			return
+		}
+
+		sender, err := email.NewSMTPSender(server, port, emailAddr, password, email.WithSecurity(security), email.WithDKIM(dkim))
 		if err != nil {
 			dialog.ShowError(fmt.Errorf("ошибка: Не удалось создать SMTP-соединение"), w)
 			log.Printf("Error creating SMTP sender: %v", err)
 			return
 		}
 
-		// Переход ко второму этапу
-		createBatchEmailUI(a, w, sender)
+		// Рассылка переиспользует открытые SMTP-сессии вместо подключения на каждую строку
+		pool := email.NewPooledSender(sender)
+		w.SetOnClosed(func() {
+			pool.Close()
+		})
+
+		// Паузы между письмами задает ограничитель скорости по квотам из .env, а не задержки в цикле рассылки
+		limited := email.NewRateLimitedSender(pool, limits...)
+
+		// Переход ко второму этапу; временные ошибки сервера повторяются автоматически
+		createBatchEmailUI(a, w, email.NewRetryingSender(limited), protection, suppressions, links)
 	})
 
 	content := container.NewVBox(
 		widget.NewLabel("Сервер:"),
 		serverEntry,
+		widget.NewLabel("Защита соединения:"),
+		securityEntry,
+		widget.NewLabel("Порт:"),
+		portEntry,
 		widget.NewLabel("Адрес отправителя:"),
 		fromEntry,
 		widget.NewLabel("Пароль:"),
@@ -65,7 +114,19 @@ func createSenderUI(a fyne.App, w fyne.Window) {
 }
 
 // Второй этап: Ввод данных для батчевой отправки
-func createBatchEmailUI(_ fyne.App, w fyne.Window, sender email.Sender) {
+func createBatchEmailUI(_ fyne.App, w fyne.Window, sender email.Sender, protection email.Protection, suppressions email.SuppressionList, links email.UnsubscribeLinks) {
+	if protection != nil {
+		sender = email.NewProtectedSender(sender, protection)
+	}
+	// Каждое письмо рассылки получает персональную ссылку отписки в один клик
+	if links != nil {
+		sender = email.NewUnsubscribeSender(sender, links)
+	}
+	// Адреса из списка исключений отсеиваются первыми и не расходуют квоты отправки
+	if suppressions != nil {
+		sender = email.NewSuppressingSender(sender, suppressions)
+	}
+
 	csvPathEntry := widget.NewEntry()
 	csvPathEntry.SetPlaceHolder("Выберите CSV файл")
 
@@ -77,7 +138,34 @@ func createBatchEmailUI(_ fyne.App, w fyne.Window, sender email.Sender) {
 		}, w).Show()
 	})
 
-	sendButton := widget.NewButton("Отправить", func() {
+	// Список статусов по строкам CSV обновляется по мере отправки
+	var statusMu sync.Mutex
+	var statuses []string
+	statusList := widget.NewList(
+		func() int {
+			statusMu.Lock()
+			defer statusMu.Unlock()
+			return len(statuses)
+		},
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(i int, obj fyne.CanvasObject) {
+			statusMu.Lock()
+			defer statusMu.Unlock()
+			obj.(*widget.Label).SetText(statuses[i])
+		},
+	)
+
+	// Кнопка отмены прерывает текущую отправку и оставшиеся письма
+	var cancelBatch context.CancelFunc
+	cancelButton := widget.NewButton("Отменить", func() {
+		if cancelBatch != nil {
+			cancelBatch()
+		}
+	})
+	cancelButton.Disable()
+
+	var sendButton *widget.Button
+	sendButton = widget.NewButton("Отправить", func() {
 		csvPath := csvPathEntry.Text
 		if csvPath == "" {
 			dialog.ShowError(fmt.Errorf("ошибка: Путь к CSV файлу не указан"), w)
@@ -99,46 +187,238 @@ func createBatchEmailUI(_ fyne.App, w fyne.Window, sender email.Sender) {
 			return
 		}
 
-		// Обработка записей из CSV
-		for _, record := range records {
-			delay := rand.Intn(10) + 5 // случайное число от 5 до 14
-			time.Sleep(time.Duration(delay) * time.Second)
-			log.Println("Задержка пройдена!")
+		// Отправляем в фоне, чтобы окно не зависало, пока идет рассылка
+		ctx, cancel := context.WithCancel(context.Background())
+		cancelBatch = cancel
+		sendButton.Disable()
+		cancelButton.Enable()
 
-			if len(record) < 3 {
-				dialog.ShowError(fmt.Errorf("ошибка: Неправильный формат строки в CSV"), w)
-				continue
-			}
+		statusMu.Lock()
+		statuses = nil
+		statusMu.Unlock()
+		statusList.Refresh()
+
+		go func() {
+			defer cancel()
 
-			recipient := record[0]
-			subject := record[1]
-			message := record[2]
-			var attachments []string
-			if len(record) > 3 {
-				for _, attachment := range record[3:] {
-					if attachment != "" { // Добавляем только непустые вложения
-						attachments = append(attachments, attachment)
-					}
+			failed, skipped := 0, 0
+			err := sendBatch(ctx, sender, records, func(result rowResult) {
+				switch {
+				case errors.Is(result.Err, email.ErrSuppressed):
+					skipped++
+				case result.Err != nil:
+					failed++
 				}
-			}
+				statusMu.Lock()
+				statuses = append(statuses, result.String())
+				statusMu.Unlock()
+				statusList.Refresh()
+			})
 
-			if err := sender.Send(recipient, subject, message, attachments); err != nil {
-				dialog.ShowError(fmt.Errorf("ошибка при отправке письма для %s: %v", recipient, err), w)
-			}
-		}
+			sendButton.Enable()
+			cancelButton.Disable()
 
-		dialog.ShowInformation("Успех", "Все письма успешно отправлены", w)
+			switch {
+			case err != nil:
+				dialog.ShowInformation("Отменено", "Рассылка остановлена", w)
+			case failed > 0:
+				dialog.ShowInformation("Готово", fmt.Sprintf("Не удалось отправить писем: %d из %d", failed, len(records)), w)
+			case skipped > 0:
+				dialog.ShowInformation("Готово", fmt.Sprintf("Пропущено адресов из списка исключений: %d из %d", skipped, len(records)), w)
+			default:
+				dialog.ShowInformation("Успех", "Все письма успешно отправлены", w)
+			}
+		}()
 	})
 
-	content := container.NewVBox(
+	controls := container.NewVBox(
 		widget.NewLabel("Путь до CSV файла:"),
 		csvPathEntry,
 		chooseFileButton,
 		sendButton,
+		cancelButton,
+		widget.NewLabel("Статус по строкам:"),
 	)
+	content := container.NewBorder(controls, nil, nil, nil, statusList)
 
 	w.SetContent(content)
-	w.Resize(fyne.NewSize(400, 300))
+	w.Resize(fyne.NewSize(600, 500))
+}
+
+// rowResult — итог отправки одной строки CSV
+type rowResult struct {
+	Row       int
+	Recipient string
+	Attempts  int
+	MessageID string
+	Err       error
+}
+
+// String описывает статус строки для списка в окне
+func (r rowResult) String() string {
+	prefix := fmt.Sprintf("%d. %s", r.Row, r.Recipient)
+	if r.Attempts > 1 {
+		prefix += fmt.Sprintf(" (попыток: %d)", r.Attempts)
+	}
+	if r.Err == nil {
+		return fmt.Sprintf("%s: отправлено %s", prefix, r.MessageID)
+	}
+
+	var smtpErr *email.SMTPError
+	var suppressed *email.SuppressedError
+	switch {
+	case errors.As(r.Err, &suppressed):
+		return fmt.Sprintf("%s: пропущено, адрес в списке исключений (%s)", prefix, suppressed.Reason)
+	case errors.Is(r.Err, email.ErrDeliveryUnknown):
+		return fmt.Sprintf("%s: связь оборвалась после передачи, письмо могло быть доставлено", prefix)
+	case errors.As(r.Err, &smtpErr) && smtpErr.Temporary():
+		return fmt.Sprintf("%s: временная ошибка, можно повторить позже (%v)", prefix, r.Err)
+	case errors.As(r.Err, &smtpErr) && smtpErr.Code != 0:
+		return fmt.Sprintf("%s: отклонено сервером, код %d %s", prefix, smtpErr.Code, smtpErr.EnhancedCode)
+	default:
+		return fmt.Sprintf("%s: ошибка (%v)", prefix, r.Err)
+	}
+}
+
+// sendBatch отправляет письма по строкам CSV и сообщает итог каждой строки в onResult.
+// При отмене ctx рассылка прерывается и возвращается ошибка контекста.
+func sendBatch(ctx context.Context, sender email.Sender, records [][]string, onResult func(rowResult)) error {
+	for i, record := range records {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		result := rowResult{Row: i + 1}
+		if len(record) > 0 {
+			result.Recipient = record[0]
+		}
+
+		msg, err := messageFromRecord(record)
+		if err != nil {
+			result.Err = err
+			onResult(result)
+			continue
+		}
+
+		sendResult, err := sender.SendMessage(ctx, msg)
+		if sendResult != nil {
+			result.Attempts = sendResult.Attempts
+			result.MessageID = sendResult.MessageID
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			result.Err = err
+		}
+		onResult(result)
+	}
+
+	return nil
+}
+
+// messageFromRecord собирает письмо из строки CSV: адрес, тема, текст и пути к вложениям
+func messageFromRecord(record []string) (*email.Message, error) {
+	if len(record) < 3 {
+		return nil, fmt.Errorf("неправильный формат строки в CSV")
+	}
+
+	recipient := record[0]
+	subject := record[1]
+	message := record[2]
+
+	msg := &email.Message{
+		To:      []email.Address{{Address: recipient}},
+		Subject: subject,
+	}
+	// HTML-тело отправляется вместе с автоматически построенной текстовой версией
+	if isHTML(message) {
+		msg.Parts = []email.Part{email.HTMLPart(message)}
+	} else {
+		msg.Parts = []email.Part{email.TextPart(message)}
+	}
+	for _, attachment := range record[3:] {
+		if attachment != "" { // Добавляем только непустые вложения
+			msg.Attachments = append(msg.Attachments, email.Attachment{Path: attachment})
+		}
+	}
+
+	return msg, nil
+}
+
+// isHTML определяет, что тело письма из CSV написано в HTML
+func isHTML(body string) bool {
+	trimmed := strings.ToLower(strings.TrimSpace(body))
+	return strings.HasPrefix(trimmed, "<!doctype html") || strings.HasPrefix(trimmed, "<html") ||
+		(strings.HasPrefix(trimmed, "<") && strings.HasSuffix(trimmed, ">"))
+}
+
+// rateLimits возвращает квоты рассылки из .env: SMTP_RATE_LIMIT, SMTP_DOMAIN_RATE_LIMIT и SMTP_RATE_JITTER
+func rateLimits(cfg config.Email) []email.RateLimitOption {
+	rateLimit, jitter := cfg.RateLimit, defaultRateJitter
+	if rateLimit == "" && cfg.DomainRateLimit == "" {
+		rateLimit = defaultRateLimit
+	}
+	accountQuotas, err := email.ParseQuotas(rateLimit)
+	if err != nil {
+		log.Fatalf("Invalid SMTP rate limit: %v", err)
+	}
+	domainQuotas, err := email.ParseQuotas(cfg.DomainRateLimit)
+	if err != nil {
+		log.Fatalf("Invalid SMTP domain rate limit: %v", err)
+	}
+	if cfg.RateJitter != "" {
+		if jitter, err = time.ParseDuration(cfg.RateJitter); err != nil {
+			log.Fatalf("Invalid SMTP rate jitter: %v", err)
+		}
+	}
+
+	return []email.RateLimitOption{
+		email.WithAccountQuota(accountQuotas...),
+		email.WithDomainQuota(domainQuotas...),
+		email.WithRateJitter(jitter),
+	}
+}
+
+// suppressionStore открывает список исключений, заданный в .env, или возвращает nil, если его нет
+func suppressionStore(cfg config.Email) suppression.Store {
+	if cfg.SuppressionStore == "" {
+		return nil
+	}
+
+	store, err := suppression.Open(context.Background(), cfg.SuppressionStore)
+	if err != nil {
+		log.Fatalf("Cant open suppression store: %v", err)
+	}
+	return store
+}
+
+// unsubscribeLinks возвращает выдачу ссылок отписки, заданную в .env, или nil, если отписка не настроена
+func unsubscribeLinks(cfg config.Email) email.UnsubscribeLinks {
+	if cfg.UnsubscribeURL == "" {
+		return nil
+	}
+
+	unsubscriber, err := suppression.NewUnsubscriber(cfg.UnsubscribeURL, []byte(cfg.UnsubscribeSecret), cfg.UnsubscribeMailto)
+	if err != nil {
+		log.Fatalf("Invalid unsubscribe settings: %v", err)
+	}
+	return unsubscriber
+}
+
+// withoutOneClick выдает ссылки отписки без List-Unsubscribe-Post: почтовые службы принимают
+// отписку в один клик только из писем, подписанных DKIM
+type withoutOneClick struct {
+	email.UnsubscribeLinks
+}
+
+// ListUnsubscribe возвращает способы отписки для recipient с отключенной отпиской в один клик
+func (l withoutOneClick) ListUnsubscribe(recipient string) *email.ListUnsubscribe {
+	links := l.UnsubscribeLinks.ListUnsubscribe(recipient)
+	if links != nil {
+		links.OneClick = false
+	}
+	return links
 }
 
 // Основная функция
@@ -148,8 +428,49 @@ func main() {
 	a := app.NewWithID("com.mclyashko.email_sender")
 	w := a.NewWindow("Email Sender")
 
-	// Начинаем с первого этапа
-	createSenderUI(a, w)
+	// .env необязателен: без него письма отправляются через SMTP-сервер из формы без S/MIME.
+	// Ошибка в самом файле не игнорируется, иначе S/MIME и локальный транспорт молча отключились бы.
+	cfg, err := (&config.DotenvConfigLoader{}).Load()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Fatalf("Error loading config: %v", err)
+	}
+	protection, err := email.NewSMIMEProtectionFromConfig(cfg.Email)
+	if err != nil {
+		log.Fatalf("Cant configure S/MIME: %v", err)
+	}
+
+	// Без списка исключений письма уходят на все адреса из CSV
+	var suppressions email.SuppressionList
+	if store := suppressionStore(cfg.Email); store != nil {
+		defer store.Close()
+		suppressions = store
+	}
+	links := unsubscribeLinks(cfg.Email)
+
+	dkim, err := email.NewDKIMSignerFromConfig(cfg.Email)
+	if err != nil {
+		log.Fatalf("Cant configure DKIM: %v", err)
+	}
+
+	sender, err := email.NewLocalSenderFromConfig(cfg.Email)
+	if err != nil {
+		log.Fatalf("Cant get local sender: %v", err)
+	}
+
+	// Локальный транспорт письма не подписывает, поэтому отписка в один клик возможна только через SMTP с DKIM
+	if links != nil && (dkim == nil || sender != nil) {
+		log.Println("Отписка в один клик отключена: письма рассылки не подписываются DKIM")
+		links = withoutOneClick{links}
+	}
+
+	// С локальным транспортом из .env письма сохраняются без SMTP-сервера, и первый этап не нужен
+	if sender != nil {
+		createBatchEmailUI(a, w, sender, protection, suppressions, links)
+		w.Show()
+	} else {
+		// Начинаем с первого этапа
+		createSenderUI(a, w, dkim, rateLimits(cfg.Email), protection, suppressions, links)
+	}
 
 	a.Run()
 }
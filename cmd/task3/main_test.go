@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mclyashko/IPORPIS/internal/email"
+	"github.com/mclyashko/IPORPIS/internal/email/emailtest"
+	"github.com/mclyashko/IPORPIS/internal/suppression"
+)
+
+func TestSendBatch(t *testing.T) {
+	server := emailtest.NewServer(t)
+	server.RejectRecipient("unknown@example.com", emailtest.Reply{Code: 550, Message: "5.1.1 User unknown"})
+
+	attachment := filepath.Join(t.TempDir(), "price.txt")
+	if err := os.WriteFile(attachment, []byte("прайс"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	records := [][]string{
+		{"first@example.com", "Тема", "Текст", attachment, ""},
+		{"broken@example.com", "только тема"},
+		{"unknown@example.com", "Тема", "Текст"},
+		{"html@example.com", "HTML", "<p>Привет, <b>мир</b></p>"},
+	}
+
+	var results []rowResult
+	err := sendBatch(context.Background(), server.Sender(), records, func(r rowResult) {
+		results = append(results, r)
+	})
+	if err != nil {
+		t.Fatalf("sendBatch: %v", err)
+	}
+	if len(results) != len(records) {
+		t.Fatalf("got %d results, want %d", len(results), len(records))
+	}
+
+	if results[0].Err != nil || results[3].Err != nil {
+		t.Errorf("valid rows failed: %v, %v", results[0].Err, results[3].Err)
+	}
+	if results[1].Err == nil {
+		t.Errorf("row with missing columns succeeded")
+	}
+	var smtpErr *email.SMTPError
+	if !errors.As(results[2].Err, &smtpErr) || smtpErr.Code != 550 {
+		t.Errorf("rejected row error = %v, want 550", results[2].Err)
+	}
+	for i, r := range results {
+		if r.Row != i+1 || r.Recipient != records[i][0] {
+			t.Errorf("result %d = %+v", i, r)
+		}
+	}
+
+	messages := server.Messages()
+	if len(messages) != 2 {
+		t.Fatalf("server received %d messages, want 2", len(messages))
+	}
+	if got := messages[0].Attachments(); len(got) != 1 || got[0].Filename != "price.txt" || string(got[0].Body) != "прайс" {
+		t.Errorf("attachments = %+v", got)
+	}
+	if messages[1].HTML() == "" || messages[1].Text() != "Привет, мир" {
+		t.Errorf("HTML row: html %q, text %q", messages[1].HTML(), messages[1].Text())
+	}
+}
+
+func TestSendBatchCanceled(t *testing.T) {
+	server := emailtest.NewServer(t)
+	records := [][]string{
+		{"a@example.com", "Тема", "Текст"},
+		{"b@example.com", "Тема", "Текст"},
+		{"c@example.com", "Тема", "Текст"},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var results []rowResult
+	err := sendBatch(ctx, server.Sender(), records, func(r rowResult) {
+		results = append(results, r)
+		cancel()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if len(results) != 1 || len(server.Messages()) != 1 {
+		t.Errorf("sent %d rows after cancellation, want 1", len(results))
+	}
+}
+
+func TestIsHTML(t *testing.T) {
+	tests := map[string]bool{
+		"Обычный текст":                false,
+		"<!DOCTYPE html><html></html>": true,
+		"  <p>абзац</p>\n":             true,
+		"a < b и c > d":                false,
+	}
+	for body, want := range tests {
+		if got := isHTML(body); got != want {
+			t.Errorf("isHTML(%q) = %v, want %v", body, got, want)
+		}
+	}
+}
+
+func TestWithoutOneClick(t *testing.T) {
+	unsubscriber, err := suppression.NewUnsubscriber("https://mail.example.com/unsubscribe", []byte("0123456789abcdef"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	links := withoutOneClick{unsubscriber}.ListUnsubscribe("ivan@example.org")
+	if links == nil || links.URL == "" {
+		t.Fatalf("ListUnsubscribe = %+v, want the unsubscribe link", links)
+	}
+	if links.OneClick {
+		t.Error("one-click unsubscribe enabled without DKIM")
+	}
+}
@@ -1,16 +1,24 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html/template"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
 	"golang.org/x/exp/rand"
 
 	"github.com/mclyashko/IPORPIS/internal/config"
 	"github.com/mclyashko/IPORPIS/internal/email"
+	"github.com/mclyashko/IPORPIS/internal/suppression"
 )
 
 // emailRequest представляет тело запроса для отправки письма
@@ -18,6 +26,26 @@ type emailRequest struct {
 	To      string `json:"to"`
 	Subject string `json:"subject"`
 	Body    string `json:"body"`
+	// HTML — необязательная HTML-версия письма; если body пуст, текст строится из HTML
+	HTML string `json:"html"`
+	// Headers — дополнительные заголовки письма, например References или X-Campaign-ID
+	Headers map[string]string `json:"headers"`
+}
+
+// message преобразует запрос в письмо
+func (r emailRequest) message() *email.Message {
+	msg := &email.Message{
+		To:      []email.Address{{Address: r.To}},
+		Subject: r.Subject,
+		Headers: r.Headers,
+	}
+	if r.Body != "" {
+		msg.Parts = append(msg.Parts, email.TextPart(r.Body))
+	}
+	if r.HTML != "" {
+		msg.Parts = append(msg.Parts, email.HTMLPart(r.HTML))
+	}
+	return msg
 }
 
 // mailHandler обрабатывает запросы на отправку письма
@@ -29,17 +57,136 @@ func mailHandler(w http.ResponseWriter, r *http.Request, es email.Sender) {
 	}
 
 	// Вызываем функцию для отправки письма
-	if err := es.Send(emailReq.To, emailReq.Subject, emailReq.Body, []string{}); err != nil {
+	// Контекст запроса отменяется, если клиент отключился, и отправка прерывается вместе с ним
+	result, err := es.SendMessage(r.Context(), emailReq.message())
+	if err != nil {
 		log.Printf("Error sending email: %v", err)
-		http.Error(w, "Ошибка отправки письма", http.StatusInternalServerError)
+		status, text := errorResponse(err)
+		if status == http.StatusServiceUnavailable {
+			w.Header().Set("Retry-After", "60")
+		}
+		http.Error(w, text, status)
 		return
 	}
 
-	// Отправляем успешный ответ
+	// Отправляем успешный ответ; Message-ID позволяет клиенту отследить письмо и ответы на него
+	w.Header().Set("X-Message-ID", result.MessageID)
 	w.WriteHeader(http.StatusAccepted)
-	fmt.Fprint(w, "Письмо успешно отправлено")
+	fmt.Fprintf(w, "Письмо успешно отправлено, Message-ID: %s", result.MessageID)
+}
+
+// maxUnsubscribeBody ограничивает тело запроса отписки: в нем только List-Unsubscribe=One-Click и токен
+const maxUnsubscribeBody = 64 << 10
+
+// unsubscribeHandler отписывает получателя по персональной ссылке из List-Unsubscribe. Почтовые службы
+// при отписке в один клик (RFC 8058) отправляют на ссылку POST с телом List-Unsubscribe=One-Click;
+// токен передается в параметре token ссылки или в теле формы.
+func unsubscribeHandler(w http.ResponseWriter, r *http.Request, unsubscriber *suppression.Unsubscriber, store suppression.Store) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUnsubscribeBody)
+	// RFC 8058 допускает тело как application/x-www-form-urlencoded, так и multipart/form-data
+	if err := r.ParseMultipartForm(maxUnsubscribeBody); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		http.Error(w, "Неверный формат данных", http.StatusBadRequest)
+		return
+	}
+
+	address, err := unsubscriber.Verify(r.Form.Get("token"))
+	if err != nil {
+		http.Error(w, "Недействительная ссылка отписки", http.StatusBadRequest)
+		return
+	}
+
+	detail := "unsubscribe link"
+	if r.PostForm.Get("List-Unsubscribe") == "One-Click" {
+		detail = "one-click unsubscribe"
+	}
+	entry := suppression.Entry{Address: address, Reason: suppression.ReasonUnsubscribe, Detail: detail}
+	if err := store.Add(r.Context(), entry); err != nil {
+		log.Printf("Error recording unsubscribe: %v", err)
+		http.Error(w, "Не удалось сохранить отписку, попробуйте позже", http.StatusInternalServerError)
+		return
+	}
+
+	log.Println("Получатель отписался от рассылки:", address)
+	fmt.Fprintf(w, "Адрес %s отписан от рассылки", address)
+}
+
+// unsubscribePage — страница подтверждения отписки: форма отправляет токен POST-запросом на ту же ссылку
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head><meta charset="utf-8"><title>Отписка от рассылки</title></head>
+<body>
+<p>Отписать адрес {{.Address}} от рассылки?</p>
+<form method="post">
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit">Отписаться</button>
+</form>
+</body>
+</html>
+`))
+
+// unsubscribePageHandler показывает получателю, перешедшему по ссылке отписки, форму подтверждения.
+// Сам GET-запрос ничего не меняет: по ссылкам из писем переходят и антивирусные сканеры.
+func unsubscribePageHandler(w http.ResponseWriter, r *http.Request, unsubscriber *suppression.Unsubscriber) {
+	token := r.URL.Query().Get("token")
+	address, err := unsubscriber.Verify(token)
+	if err != nil {
+		http.Error(w, "Недействительная ссылка отписки", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := unsubscribePage.Execute(w, struct{ Address, Token string }{address, token}); err != nil {
+		log.Printf("Error rendering unsubscribe page: %v", err)
+	}
 }
 
+// errorResponse переводит ошибку отправки в HTTP-статус и текст ответа
+func errorResponse(err error) (int, string) {
+	var smtpErr *email.SMTPError
+	var suppressed *email.SuppressedError
+	switch {
+	case errors.Is(err, email.ErrNoRecipients):
+		return http.StatusBadRequest, "Не указан получатель"
+	case errors.Is(err, email.ErrInvalidHeader):
+		return http.StatusBadRequest, fmt.Sprintf("Недопустимый заголовок письма: %v", err)
+	case errors.As(err, &suppressed):
+		// Получатель отписался, жаловался или его адрес недоставим; повтор не поможет
+		return http.StatusUnprocessableEntity, fmt.Sprintf("Получатель в списке исключений: %s", suppressed.Reason)
+	case errors.Is(err, email.ErrDeliveryUnknown):
+		// Сервер мог принять письмо: повтор со стороны клиента грозит дублем
+		return http.StatusBadGateway, "Связь с SMTP-сервером оборвалась после передачи письма; письмо могло быть доставлено, не повторяйте отправку"
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, "SMTP-сервер не ответил вовремя"
+	case errors.As(err, &smtpErr):
+		switch {
+		case smtpErr.Temporary():
+			// 4xx от сервера или обрыв соединения: клиенту имеет смысл повторить позже
+			return http.StatusServiceUnavailable, fmt.Sprintf("Временная ошибка отправки письма: %s", smtpErrorText(smtpErr))
+		case smtpErr.Phase == email.PhaseRcpt:
+			// Сервер окончательно отклонил адрес получателя, например 550 5.1.1
+			return http.StatusUnprocessableEntity, fmt.Sprintf("Получатель отклонен: %s", smtpErrorText(smtpErr))
+		default:
+			return http.StatusBadGateway, fmt.Sprintf("SMTP-сервер отклонил письмо: %s", smtpErrorText(smtpErr))
+		}
+	default:
+		return http.StatusInternalServerError, "Ошибка отправки письма"
+	}
+}
+
+// smtpErrorText возвращает коды и текст ответа сервера, если он был
+func smtpErrorText(err *email.SMTPError) string {
+	if err.Code == 0 {
+		return string(err.Phase)
+	}
+	if err.EnhancedCode != "" {
+		return fmt.Sprintf("%d %s %s", err.Code, err.EnhancedCode, err.Message)
+	}
+	return fmt.Sprintf("%d %s", err.Code, err.Message)
+}
+
+// shutdownTimeout ограничивает ожидание начатых запросов при остановке сервера
+const shutdownTimeout = 30 * time.Second
+
 func getConfig() config.App {
 	configLoader := &config.DotenvConfigLoader{}
 
@@ -55,20 +202,103 @@ func main() {
 	rand.Seed(uint64(time.Now().UnixNano()))
 
 	cfg := getConfig()
-	es, err := email.NewSMTPSender(
-		cfg.Email.Host,
-		cfg.Email.Port,
-		cfg.Email.Username,
-		cfg.Email.Password,
-	)
+	// Локальный транспорт из .env позволяет проверить сервис без отправки настоящих писем
+	sender, err := email.NewLocalSenderFromConfig(cfg.Email)
 	if err != nil {
-		log.Fatalf("Cant get SMTP sender: %v", err)
+		log.Fatalf("Cant get local sender: %v", err)
+	}
+
+	maxAttempts := email.DefaultMaxAttempts
+	if cfg.Email.MaxAttempts != "" {
+		maxAttempts, err = strconv.Atoi(cfg.Email.MaxAttempts)
+		if err != nil {
+			log.Fatalf("Invalid SMTP max attempts: %v", err)
+		}
+	}
+
+	var pool *email.PooledSender
+	if sender == nil {
+		// HTTP-обработчики отправляют письма параллельно через общий пул SMTP-сессий
+		smtpSender, err := email.NewSMTPSenderFromConfig(cfg.Email)
+		if err != nil {
+			log.Fatalf("Cant get SMTP sender: %v", err)
+		}
+		pool = email.NewPooledSender(smtpSender)
+
+		accountQuotas, err := email.ParseQuotas(cfg.Email.RateLimit)
+		if err != nil {
+			log.Fatalf("Invalid SMTP rate limit: %v", err)
+		}
+		domainQuotas, err := email.ParseQuotas(cfg.Email.DomainRateLimit)
+		if err != nil {
+			log.Fatalf("Invalid SMTP domain rate limit: %v", err)
+		}
+		sender = email.NewRateLimitedSender(pool,
+			email.WithAccountQuota(accountQuotas...),
+			email.WithDomainQuota(domainQuotas...),
+		)
+	}
+
+	var es email.Sender = email.NewRetryingSender(sender, email.WithMaxAttempts(maxAttempts))
+	var store suppression.Store
+	if cfg.Email.SuppressionStore != "" {
+		store, err = suppression.Open(context.Background(), cfg.Email.SuppressionStore)
+		if err != nil {
+			log.Fatalf("Cant open suppression store: %v", err)
+		}
+		es = email.NewSuppressingSender(es, store)
 	}
 
 	http.HandleFunc("POST /mail", func(w http.ResponseWriter, r *http.Request) {
 		mailHandler(w, r, es)
 	})
 
-	log.Println("Сервер запущен на порту 8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	// Ссылки отписки из писем рассылки task3 ведут сюда; отписавшиеся попадают в список исключений
+	if cfg.Email.UnsubscribeURL != "" {
+		if store == nil {
+			log.Fatal("UNSUBSCRIBE_URL requires SUPPRESSION_STORE")
+		}
+		unsubscriber, err := suppression.NewUnsubscriber(cfg.Email.UnsubscribeURL, []byte(cfg.Email.UnsubscribeSecret), cfg.Email.UnsubscribeMailto)
+		if err != nil {
+			log.Fatalf("Invalid unsubscribe settings: %v", err)
+		}
+		http.HandleFunc("GET /unsubscribe", func(w http.ResponseWriter, r *http.Request) {
+			unsubscribePageHandler(w, r, unsubscriber)
+		})
+		http.HandleFunc("POST /unsubscribe", func(w http.ResponseWriter, r *http.Request) {
+			unsubscribeHandler(w, r, unsubscriber, store)
+		})
+	}
+
+	// По Ctrl+C или SIGTERM сервер дожидается начатых отправок, а затем закрывает SMTP-сессии пула
+	// и список исключений
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server := &http.Server{Addr: ":8080"}
+	go func() {
+		log.Println("Сервер запущен на порту 8080")
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server error: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Останавливаем сервер...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down server: %v", err)
+	}
+
+	if pool != nil {
+		if err := pool.Close(); err != nil {
+			log.Printf("Error closing SMTP pool: %v", err)
+		}
+	}
+	if store != nil {
+		if err := store.Close(); err != nil {
+			log.Printf("Error closing suppression store: %v", err)
+		}
+	}
 }
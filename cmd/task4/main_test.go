@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mclyashko/IPORPIS/internal/email"
+	"github.com/mclyashko/IPORPIS/internal/email/emailtest"
+	"github.com/mclyashko/IPORPIS/internal/suppression"
+)
+
+func TestMailHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		script     func(*emailtest.Server)
+		wantStatus int
+		wantSent   int
+	}{
+		{
+			name:       "accepted",
+			body:       `{"to": "rcpt@example.com", "subject": "Тема", "body": "Текст"}`,
+			wantStatus: http.StatusAccepted,
+			wantSent:   1,
+		},
+		{
+			name:       "invalid json",
+			body:       `{"to":`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "no recipient",
+			body:       `{"subject": "Тема", "body": "Текст"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "header injection",
+			body:       `{"to": "rcpt@example.com", "subject": "Тема", "body": "Текст", "headers": {"X-Campaign-ID": "1\r\nBcc: victim@example.com"}}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "recipient rejected",
+			body: `{"to": "unknown@example.com", "subject": "Тема", "body": "Текст"}`,
+			script: func(s *emailtest.Server) {
+				s.RejectRecipient("unknown@example.com", emailtest.Reply{Code: 550, Message: "5.1.1 User unknown"})
+			},
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name: "temporary failure",
+			body: `{"to": "rcpt@example.com", "subject": "Тема", "body": "Текст"}`,
+			script: func(s *emailtest.Server) {
+				s.Script(email.PhaseMail, emailtest.Reply{Code: 451, Message: "4.7.1 Greylisted"})
+			},
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name: "message rejected",
+			body: `{"to": "rcpt@example.com", "subject": "Тема", "html": "<p>Текст</p>"}`,
+			script: func(s *emailtest.Server) {
+				s.Script(email.PhaseData, emailtest.Reply{Code: 554, Message: "5.7.1 Spam"})
+			},
+			wantStatus: http.StatusBadGateway,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := emailtest.NewServer(t)
+			if tt.script != nil {
+				tt.script(server)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/mail", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			mailHandler(rec, req, server.Sender())
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (%s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus == http.StatusServiceUnavailable && rec.Header().Get("Retry-After") == "" {
+				t.Errorf("503 without Retry-After")
+			}
+			if tt.wantStatus == http.StatusAccepted && rec.Header().Get("X-Message-ID") == "" {
+				t.Errorf("202 without X-Message-ID")
+			}
+			if n := len(server.Messages()); n != tt.wantSent {
+				t.Errorf("server received %d messages, want %d", n, tt.wantSent)
+			}
+		})
+	}
+}
+
+func TestMailHandlerSuppressed(t *testing.T) {
+	server := emailtest.NewServer(t)
+	store, err := suppression.OpenFileStore(filepath.Join(t.TempDir(), "suppressions.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add(context.Background(), suppression.Entry{Address: "gone@example.com", Reason: suppression.ReasonBounce}); err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"to": "Gone@example.com", "subject": "Тема", "body": "Текст"}`
+	req := httptest.NewRequest(http.MethodPost, "/mail", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	mailHandler(rec, req, email.NewSuppressingSender(server.Sender(), store))
+
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "bounce") {
+		t.Errorf("status = %d (%s), want 422 with the reason", rec.Code, rec.Body)
+	}
+	if server.Connections() != 0 {
+		t.Error("suppressed message reached the SMTP server")
+	}
+}
+
+func TestUnsubscribeHandler(t *testing.T) {
+	store, err := suppression.OpenFileStore(filepath.Join(t.TempDir(), "suppressions.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsubscriber, err := suppression.NewUnsubscriber("https://mail.example.com/unsubscribe", []byte("0123456789abcdef"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Запрос почтовой службы при отписке в один клик: токен в ссылке, One-Click в теле
+	link := unsubscriber.URL("Ivan@example.org")
+	req := httptest.NewRequest(http.MethodPost, link, strings.NewReader("List-Unsubscribe=One-Click"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	unsubscribeHandler(rec, req, unsubscriber, store)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d (%s)", rec.Code, rec.Body)
+	}
+	entries, err := store.Entries(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Address != "ivan@example.org" ||
+		entries[0].Reason != suppression.ReasonUnsubscribe || entries[0].Detail != "one-click unsubscribe" {
+		t.Errorf("entries = %+v", entries)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/unsubscribe?token=forged", strings.NewReader("List-Unsubscribe=One-Click"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	unsubscribeHandler(rec, req, unsubscriber, store)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("forged token: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestUnsubscribePageHandler(t *testing.T) {
+	store, err := suppression.OpenFileStore(filepath.Join(t.TempDir(), "suppressions.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsubscriber, err := suppression.NewUnsubscriber("https://mail.example.com/unsubscribe", []byte("0123456789abcdef"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Переход по ссылке, в том числе сканером ссылок, только показывает форму подтверждения
+	link := unsubscriber.URL("ivan@example.org")
+	rec := httptest.NewRecorder()
+	unsubscribePageHandler(rec, httptest.NewRequest(http.MethodGet, link, nil), unsubscriber)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d (%s)", rec.Code, rec.Body)
+	}
+	page := rec.Body.String()
+	if !strings.Contains(page, `method="post"`) || !strings.Contains(page, "ivan@example.org") {
+		t.Errorf("page = %s, want a confirmation form", page)
+	}
+	entries, err := store.Entries(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("GET unsubscribed %+v", entries)
+	}
+
+	rec = httptest.NewRecorder()
+	unsubscribePageHandler(rec, httptest.NewRequest(http.MethodGet, "/unsubscribe?token=forged", nil), unsubscriber)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("forged token: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestMailHandlerHTML(t *testing.T) {
+	server := emailtest.NewServer(t)
+
+	body := `{"to": "rcpt@example.com", "subject": "Новости", "html": "<h1>Заголовок</h1><p>Текст</p>", "headers": {"X-Campaign-ID": "news-1"}}`
+	req := httptest.NewRequest(http.MethodPost, "/mail", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	mailHandler(rec, req, server.Sender())
+
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d (%s)", rec.Code, rec.Body)
+	}
+	msg := server.Messages()[0]
+	if got := msg.Header.Get("Message-ID"); got == "" || got != rec.Header().Get("X-Message-ID") {
+		t.Errorf("Message-ID = %q, X-Message-ID = %q", got, rec.Header().Get("X-Message-ID"))
+	}
+	if got := msg.Header.Get("X-Campaign-ID"); got != "news-1" {
+		t.Errorf("X-Campaign-ID = %q", got)
+	}
+	if msg.Subject() != "Новости" {
+		t.Errorf("Subject() = %q", msg.Subject())
+	}
+	if !strings.Contains(msg.HTML(), "<h1>Заголовок</h1>") {
+		t.Errorf("HTML() = %q", msg.HTML())
+	}
+	if !strings.HasPrefix(msg.Text(), "ЗАГОЛОВОК") {
+		t.Errorf("Text() = %q, want generated plain text", msg.Text())
+	}
+}
@@ -0,0 +1,72 @@
+package bounce
+
+import (
+	"fmt"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// parseARF разбирает жалобу multipart/report; report-type=feedback-report (RFC 5965), которую почтовые
+// службы присылают по feedback loop, когда получатель нажимает «Это спам»
+func parseARF(header textproto.MIMEHeader, body []byte) ([]Report, error) {
+	parts, err := leaves(header, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var feedback, original textproto.MIMEHeader
+	for _, part := range parts {
+		switch {
+		case part.mediaType == "message/feedback-report" && feedback == nil:
+			feedback = readFields(part.body)
+		case isOriginalMessage(part.mediaType) && original == nil:
+			original = readFields(part.body)
+		}
+	}
+	if feedback == nil {
+		return nil, fmt.Errorf("%w: no message/feedback-report part", ErrNotBounce)
+	}
+
+	feedbackType := strings.ToLower(strings.TrimSpace(feedback.Get("Feedback-Type")))
+	if feedbackType == "" {
+		feedbackType = "abuse"
+	}
+	messageID := ""
+	if original != nil {
+		messageID = strings.TrimSpace(original.Get("Message-Id"))
+	}
+	date, _ := mail.ParseDate(feedback.Get("Arrival-Date"))
+	if date.IsZero() {
+		// Поле Received-Date из черновика ARF до RFC 5965 все еще встречается
+		date, _ = mail.ParseDate(feedback.Get("Received-Date"))
+	}
+
+	// Original-Rcpt-To есть не у всех служб: многие скрывают адрес и оставляют только заголовки письма
+	recipients := feedback.Values("Original-Rcpt-To")
+	if len(recipients) == 0 && original != nil {
+		if to, err := mail.ParseAddressList(original.Get("To")); err == nil {
+			for _, addr := range to {
+				recipients = append(recipients, addr.Address)
+			}
+		}
+	}
+
+	reports := []Report{}
+	for _, recipient := range recipients {
+		recipient = strings.Trim(strings.TrimSpace(recipient), "<>")
+		if recipient == "" {
+			continue
+		}
+		reports = append(reports, Report{
+			Type:       TypeComplaint,
+			Recipient:  recipient,
+			Diagnostic: feedbackType,
+			MessageID:  messageID,
+			// Получателю, который пожаловался, писать больше нельзя, даже если адрес рабочий
+			Permanent: true,
+			Date:      date,
+		})
+	}
+	return reports, nil
+}
@@ -0,0 +1,207 @@
+// Package bounce разбирает возвраты писем (уведомления о недоставке по RFC 3464 и распространенные
+// нестандартные форматы почтовых серверов) и жалобы получателей в формате ARF (RFC 5965).
+// Результат — адреса, на которые больше не стоит писать, с кодом и текстом ошибки
+// и Message-ID исходного письма.
+package bounce
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/mclyashko/IPORPIS/internal/email"
+)
+
+// ErrNotBounce возвращается Parse для писем, которые не являются ни возвратом, ни жалобой
+var ErrNotBounce = errors.New("message is not a bounce or feedback report")
+
+// Type — вид разобранного письма
+type Type string
+
+const (
+	// TypeBounce — письмо не доставлено или доставка задерживается
+	TypeBounce Type = "bounce"
+	// TypeComplaint — получатель пожаловался на письмо (ARF)
+	TypeComplaint Type = "complaint"
+)
+
+// Report — сведения об одном адресе из возврата или жалобы
+type Report struct {
+	Type Type
+	// Recipient — адрес, до которого письмо не дошло или который пожаловался, в том виде, в котором он был в рассылке
+	Recipient string
+	// Status — расширенный код статуса, например 5.1.1; пустой, если сервер его не сообщил
+	Status string
+	// Diagnostic — ответ сервера получателя или тип жалобы (Feedback-Type), например abuse
+	Diagnostic string
+	// MessageID — Message-ID исходного письма, если его удалось найти
+	MessageID string
+	// Permanent — адрес недоставим окончательно (ошибка 5xx или жалоба) и его стоит исключить из рассылок;
+	// false для задержек и временных ошибок
+	Permanent bool
+	// Date — время возврата или жалобы
+	Date time.Time
+	// Source — откуда взято письмо: путь к файлу, для mbox — с номером письма, например inbox.mbox:3
+	Source string
+}
+
+// Parse разбирает одно письмо. Для писем, которые не являются возвратом или жалобой, возвращает ErrNotBounce;
+// для отчета о доставке без ошибок — пустой список.
+func Parse(r io.Reader) ([]Report, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading message: %w", err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error reading message: %w", err)
+	}
+	body, err := io.ReadAll(msg.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading message: %w", err)
+	}
+
+	var reports []Report
+	mediaType, params, _ := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	reportType := strings.ToLower(params["report-type"])
+	switch {
+	case mediaType == "multipart/report" && reportType == "delivery-status":
+		reports, err = parseDSN(data)
+		if errors.Is(err, email.ErrNotDeliveryReport) {
+			// Некоторые серверы объявляют multipart/report, но не прикладывают message/delivery-status
+			reports, err = parseNonStandard(msg.Header, body)
+		}
+	case mediaType == "multipart/report" && reportType == "feedback-report":
+		reports, err = parseARF(textproto.MIMEHeader(msg.Header), body)
+	default:
+		reports, err = parseNonStandard(msg.Header, body)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	date, _ := msg.Header.Date()
+	for i := range reports {
+		if reports[i].Date.IsZero() {
+			reports[i].Date = date
+		}
+	}
+	return reports, nil
+}
+
+// parseDSN разбирает стандартное уведомление о доставке; доставленные получатели пропускаются
+func parseDSN(data []byte) ([]Report, error) {
+	report, err := email.ParseDeliveryReport(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	reports := []Report{}
+	for _, rcpt := range report.Recipients {
+		if rcpt.Action != email.ActionFailed && rcpt.Action != email.ActionDelayed {
+			continue
+		}
+
+		// В списке рассылки был исходный адрес, а не тот, на который его могли переслать
+		recipient := rcpt.OriginalRecipient
+		if recipient == "" {
+			recipient = rcpt.FinalRecipient
+		}
+		date := rcpt.LastAttempt
+		if date.IsZero() {
+			date = report.ArrivalDate
+		}
+		reports = append(reports, Report{
+			Type:       TypeBounce,
+			Recipient:  recipient,
+			Status:     rcpt.Status,
+			Diagnostic: rcpt.DiagnosticCode,
+			MessageID:  report.MessageID,
+			Permanent:  rcpt.Action == email.ActionFailed && !strings.HasPrefix(rcpt.Status, "4"),
+			Date:       date,
+		})
+	}
+	return reports, nil
+}
+
+// leaf — листовая MIME-часть с раскодированным содержимым
+type leaf struct {
+	mediaType string
+	body      []byte
+}
+
+// leaves обходит MIME-дерево и возвращает его листья; вложенные письма message/rfc822 не раскрываются
+func leaves(header textproto.MIMEHeader, body []byte) ([]leaf, error) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType = email.ContentTypePlain
+	}
+
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		decoded, err := io.ReadAll(decodeTransferEncoding(header.Get("Content-Transfer-Encoding"), bytes.NewReader(body)))
+		if err != nil {
+			return nil, fmt.Errorf("error decoding %s part: %w", mediaType, err)
+		}
+		return []leaf{{mediaType: mediaType, body: decoded}}, nil
+	}
+
+	var result []leaf
+	reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return result, fmt.Errorf("error reading %s: %w", mediaType, err)
+		}
+		content, err := io.ReadAll(part)
+		if err != nil {
+			return result, fmt.Errorf("error reading %s: %w", mediaType, err)
+		}
+		children, err := leaves(part.Header, content)
+		result = append(result, children...)
+		if err != nil {
+			return result, err
+		}
+	}
+}
+
+// readFields разбирает блок полей вида "Имя: значение", например message/feedback-report или заголовки письма
+func readFields(data []byte) textproto.MIMEHeader {
+	// Пустая строка в конце завершает блок, даже если ее нет в исходных данных
+	reader := textproto.NewReader(bufio.NewReader(io.MultiReader(bytes.NewReader(data), strings.NewReader("\r\n\r\n"))))
+	fields, _ := reader.ReadMIMEHeader()
+	return fields
+}
+
+// isOriginalMessage сообщает, что часть содержит исходное письмо или его заголовки
+func isOriginalMessage(mediaType string) bool {
+	switch mediaType {
+	case "message/rfc822", "text/rfc822-headers", "message/global", "message/global-headers":
+		return true
+	}
+	return false
+}
+
+// decodeTransferEncoding раскодирует тело части по Content-Transfer-Encoding
+func decodeTransferEncoding(transferEncoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(transferEncoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	default:
+		return body
+	}
+}
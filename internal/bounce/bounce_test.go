@@ -0,0 +1,237 @@
+package bounce_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mclyashko/IPORPIS/internal/bounce"
+)
+
+const dsnBounce = "From: MAILER-DAEMON@mx.example.com (Mail Delivery System)\r\n" +
+	"To: sender@example.com\r\n" +
+	"Subject: Undelivered Mail Returned to Sender\r\n" +
+	"Date: Mon, 13 Nov 2023 10:00:10 +0300\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/report; report-type=delivery-status; boundary=\"B\"\r\n" +
+	"\r\n" +
+	"--B\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"I'm sorry to have to inform you that your message could not be delivered.\r\n" +
+	"--B\r\n" +
+	"Content-Type: message/delivery-status\r\n" +
+	"\r\n" +
+	"Reporting-MTA: dns; mx.example.com\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; ivan@example.org\r\n" +
+	"Action: delivered\r\n" +
+	"Status: 2.0.0\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; petr@mail.example.org\r\n" +
+	"Original-Recipient: rfc822;petr@example.org\r\n" +
+	"Action: failed\r\n" +
+	"Status: 5.1.1\r\n" +
+	"Diagnostic-Code: smtp; 550 5.1.1 User unknown\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; anna@example.org\r\n" +
+	"Action: delayed\r\n" +
+	"Status: 4.2.2\r\n" +
+	"Diagnostic-Code: smtp; 452 4.2.2 Mailbox full\r\n" +
+	"\r\n" +
+	"--B\r\n" +
+	"Content-Type: text/rfc822-headers\r\n" +
+	"\r\n" +
+	"From: sender@example.com\r\n" +
+	"Message-ID: <1700000000.original@example.com>\r\n" +
+	"\r\n" +
+	"--B--\r\n"
+
+func TestParseDSN(t *testing.T) {
+	reports, err := bounce.Parse(strings.NewReader(dsnBounce))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(reports) != 2 {
+		t.Fatalf("got %d reports, want 2 (delivered recipients are skipped): %+v", len(reports), reports)
+	}
+
+	failed, delayed := reports[0], reports[1]
+	if failed.Type != bounce.TypeBounce || failed.Recipient != "petr@example.org" || failed.Status != "5.1.1" ||
+		failed.Diagnostic != "550 5.1.1 User unknown" || !failed.Permanent {
+		t.Errorf("failed report = %+v", failed)
+	}
+	if failed.MessageID != "<1700000000.original@example.com>" {
+		t.Errorf("MessageID = %q", failed.MessageID)
+	}
+	if want := time.Date(2023, 11, 13, 7, 0, 10, 0, time.UTC); !failed.Date.Equal(want) {
+		t.Errorf("Date = %v, want the date of the bounce", failed.Date)
+	}
+	if delayed.Recipient != "anna@example.org" || delayed.Status != "4.2.2" || delayed.Permanent {
+		t.Errorf("delayed report = %+v", delayed)
+	}
+}
+
+const arfReport = "From: feedback@fbl.example.net\r\n" +
+	"To: abuse@example.com\r\n" +
+	"Subject: FW: Договор\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/report; report-type=feedback-report; boundary=\"F\"\r\n" +
+	"\r\n" +
+	"--F\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"This is an email abuse report for an email message received from IP 192.0.2.10.\r\n" +
+	"--F\r\n" +
+	"Content-Type: message/feedback-report\r\n" +
+	"\r\n" +
+	"Feedback-Type: abuse\r\n" +
+	"User-Agent: ExampleFBL/1.0\r\n" +
+	"Version: 1\r\n" +
+	"Original-Mail-From: <sender@example.com>\r\n" +
+	"Original-Rcpt-To: <olga@example.net>\r\n" +
+	"Arrival-Date: Tue, 14 Nov 2023 12:00:00 +0000\r\n" +
+	"\r\n" +
+	"--F\r\n" +
+	"Content-Type: message/rfc822\r\n" +
+	"\r\n" +
+	"From: sender@example.com\r\n" +
+	"To: olga@example.net\r\n" +
+	"Message-ID: <1700000001.original@example.com>\r\n" +
+	"\r\n" +
+	"Договор во вложении\r\n" +
+	"--F--\r\n"
+
+func TestParseARF(t *testing.T) {
+	reports, err := bounce.Parse(strings.NewReader(arfReport))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(reports) != 1 {
+		t.Fatalf("got %d reports, want 1", len(reports))
+	}
+	got := reports[0]
+	if got.Type != bounce.TypeComplaint || got.Recipient != "olga@example.net" || got.Diagnostic != "abuse" || !got.Permanent {
+		t.Errorf("report = %+v", got)
+	}
+	if got.MessageID != "<1700000001.original@example.com>" {
+		t.Errorf("MessageID = %q", got.MessageID)
+	}
+	if want := time.Date(2023, 11, 14, 12, 0, 0, 0, time.UTC); !got.Date.Equal(want) {
+		t.Errorf("Date = %v, want Arrival-Date", got.Date)
+	}
+
+	// Служба скрыла Original-Rcpt-To: адрес берется из заголовка To исходного письма
+	redacted := strings.Replace(arfReport, "Original-Rcpt-To: <olga@example.net>\r\n", "", 1)
+	reports, err = bounce.Parse(strings.NewReader(redacted))
+	if err != nil || len(reports) != 1 || reports[0].Recipient != "olga@example.net" {
+		t.Errorf("Parse without Original-Rcpt-To = %+v, %v", reports, err)
+	}
+}
+
+func TestParseExim(t *testing.T) {
+	msg := "From: Mail Delivery System <Mailer-Daemon@mx.example.com>\r\n" +
+		"To: sender@example.com\r\n" +
+		"Subject: Mail delivery failed: returning message to sender\r\n" +
+		"X-Failed-Recipients: petr@example.org\r\n" +
+		"\r\n" +
+		"This message was created automatically by mail delivery software.\r\n" +
+		"\r\n" +
+		"A message that you sent could not be delivered to one or more of its\r\n" +
+		"recipients. This is a permanent error. The following address(es) failed:\r\n" +
+		"\r\n" +
+		"  petr@example.org\r\n" +
+		"    host mx.example.org [192.0.2.1]\r\n" +
+		"    SMTP error from remote mail server after RCPT TO:<petr@example.org>:\r\n" +
+		"    550 5.1.1 <petr@example.org>: Recipient address rejected: User unknown\r\n" +
+		"\r\n" +
+		"------ This is a copy of the message, including all the headers. ------\r\n" +
+		"\r\n" +
+		"From: sender@example.com\r\n" +
+		"To: petr@example.org\r\n" +
+		"Message-Id: <1700000002.original@example.com>\r\n" +
+		"\r\n" +
+		"Договор во вложении\r\n"
+
+	reports, err := bounce.Parse(strings.NewReader(msg))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(reports) != 1 {
+		t.Fatalf("got %d reports, want 1: %+v", len(reports), reports)
+	}
+	got := reports[0]
+	if got.Recipient != "petr@example.org" || got.Status != "5.1.1" || !got.Permanent ||
+		got.Diagnostic != "550 5.1.1 <petr@example.org>: Recipient address rejected: User unknown" {
+		t.Errorf("report = %+v", got)
+	}
+	if got.MessageID != "<1700000002.original@example.com>" {
+		t.Errorf("MessageID = %q", got.MessageID)
+	}
+}
+
+func TestParseQmail(t *testing.T) {
+	msg := "From: MAILER-DAEMON@mx.example.com\r\n" +
+		"To: sender@example.com\r\n" +
+		"Subject: failure notice\r\n" +
+		"\r\n" +
+		"Hi. This is the qmail-send program at mx.example.com.\r\n" +
+		"I'm afraid I wasn't able to deliver your message to the following addresses.\r\n" +
+		"This is a permanent error; I've given up. Sorry it didn't work out.\r\n" +
+		"\r\n" +
+		"<petr@example.org>:\r\n" +
+		"192.0.2.1 does not like recipient.\r\n" +
+		"Remote host said: 550 No such user\r\n" +
+		"\r\n" +
+		"<anna@example.org>:\r\n" +
+		"Sorry, no mailbox here by that name.\r\n" +
+		"\r\n" +
+		"--- Below this line is a copy of the message.\r\n" +
+		"\r\n" +
+		"From: sender@example.com\r\n" +
+		"To: petr@example.org, anna@example.org\r\n" +
+		"Message-ID: <1700000003.original@example.com>\r\n"
+
+	reports, err := bounce.Parse(strings.NewReader(msg))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(reports) != 2 {
+		t.Fatalf("got %d reports, want 2: %+v", len(reports), reports)
+	}
+	if got := reports[0]; got.Recipient != "petr@example.org" || got.Status != "" ||
+		got.Diagnostic != "Remote host said: 550 No such user" || !got.Permanent {
+		t.Errorf("first report = %+v", got)
+	}
+	if got := reports[1]; got.Recipient != "anna@example.org" || got.Diagnostic != "Sorry, no mailbox here by that name." || !got.Permanent {
+		t.Errorf("second report = %+v", got)
+	}
+	if reports[0].MessageID != "<1700000003.original@example.com>" {
+		t.Errorf("MessageID = %q", reports[0].MessageID)
+	}
+}
+
+func TestParseDelayWarning(t *testing.T) {
+	msg := "From: postmaster@example.org\r\n" +
+		"To: sender@example.com\r\n" +
+		"Subject: =?utf-8?b?0J/QuNGB0YzQvNC+INC90LUg0LTQvtGB0YLQsNCy0LvQtdC90L4=?=\r\n" +
+		"\r\n" +
+		"Your message wasn't delivered to anna@example.org yet.\r\n" +
+		"Delivery has been delayed, we will keep trying for 3 more days.\r\n"
+
+	reports, err := bounce.Parse(strings.NewReader(msg))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(reports) != 1 || reports[0].Recipient != "anna@example.org" || reports[0].Permanent {
+		t.Errorf("reports = %+v, want a temporary failure for anna@example.org", reports)
+	}
+}
+
+func TestParseNotBounce(t *testing.T) {
+	msg := "From: ivan@example.org\r\nTo: sender@example.com\r\nSubject: Re: Договор\r\n\r\nСпасибо, получил.\r\n"
+	if _, err := bounce.Parse(strings.NewReader(msg)); !errors.Is(err, bounce.ErrNotBounce) {
+		t.Errorf("err = %v, want ErrNotBounce", err)
+	}
+}
@@ -0,0 +1,157 @@
+package bounce
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ParseFile разбирает одно письмо из файла .eml
+func ParseFile(path string) ([]Report, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening message file: %w", err)
+	}
+	defer f.Close()
+
+	reports, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for i := range reports {
+		reports[i].Source = path
+	}
+	return reports, nil
+}
+
+// ParseMbox разбирает все письма из mbox-файла (mboxo или mboxrd). Письма, которые не являются
+// возвратами или жалобами, пропускаются; ошибки разбора остальных писем объединяются в одну,
+// а найденные к этому моменту сведения все равно возвращаются.
+func ParseMbox(path string) ([]Report, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening mbox file: %w", err)
+	}
+	defer f.Close()
+
+	var reports []Report
+	var errs []error
+	n := 0
+	parse := func(data []byte) {
+		n++
+		source := fmt.Sprintf("%s:%d", path, n)
+		found, err := Parse(bytes.NewReader(data))
+		errs = appendError(errs, source, err)
+		for i := range found {
+			found[i].Source = source
+		}
+		reports = append(reports, found...)
+	}
+
+	reader := bufio.NewReader(f)
+	var msg bytes.Buffer
+	started, prevBlank := false, true
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			switch {
+			case prevBlank && bytes.HasPrefix(line, []byte("From ")):
+				// Разделитель писем; сама строка в письмо не входит
+				if started {
+					parse(msg.Bytes())
+					msg.Reset()
+				}
+				started = true
+			case started:
+				// mboxrd: убираем один ">" из экранированных строк ">*From "
+				if bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From ")) && line[0] == '>' {
+					line = line[1:]
+				}
+				msg.Write(line)
+			}
+			prevBlank = len(bytes.TrimRight(line, "\r\n")) == 0
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return reports, fmt.Errorf("error reading mbox file: %w", err)
+		}
+	}
+	if started {
+		parse(msg.Bytes())
+	}
+	return reports, errors.Join(errs...)
+}
+
+// ParseMaildir разбирает письма из каталогов new и cur Maildir. Как и ParseMbox, пропускает письма,
+// которые не являются возвратами, и возвращает найденные сведения вместе с ошибками разбора.
+func ParseMaildir(dir string) ([]Report, error) {
+	var reports []Report
+	var errs []error
+	for _, sub := range []string{"new", "cur"} {
+		entries, err := os.ReadDir(filepath.Join(dir, sub))
+		if err != nil {
+			return reports, fmt.Errorf("error reading maildir: %w", err)
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+		for _, entry := range entries {
+			// Скрытые файлы — служебные файлы почтовых клиентов, а не письма
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			found, err := ParseFile(filepath.Join(dir, sub, entry.Name()))
+			errs = appendError(errs, "", err)
+			reports = append(reports, found...)
+		}
+	}
+	return reports, errors.Join(errs...)
+}
+
+// ParsePath разбирает путь любого поддерживаемого вида: каталог Maildir, mbox-файл или файл .eml.
+// mbox отличается от отдельного письма по строке-разделителю "From " в начале файла. Отдельное письмо,
+// которое не является возвратом, как и в mbox, дает пустой список без ошибки.
+func ParsePath(path string) ([]Report, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", path, err)
+	}
+	if info.IsDir() {
+		return ParseMaildir(path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", path, err)
+	}
+	head := make([]byte, len("From "))
+	n, _ := io.ReadFull(f, head)
+	f.Close()
+	if string(head[:n]) == "From " {
+		return ParseMbox(path)
+	}
+
+	reports, err := ParseFile(path)
+	if errors.Is(err, ErrNotBounce) {
+		return nil, nil
+	}
+	return reports, err
+}
+
+// appendError добавляет ошибку разбора письма; письма, которые не являются возвратами, ошибкой не считаются
+func appendError(errs []error, source string, err error) []error {
+	if err == nil || errors.Is(err, ErrNotBounce) {
+		return errs
+	}
+	if source != "" {
+		err = fmt.Errorf("%s: %w", source, err)
+	}
+	return append(errs, err)
+}
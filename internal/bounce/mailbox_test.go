@@ -0,0 +1,100 @@
+package bounce_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mclyashko/IPORPIS/internal/bounce"
+)
+
+// toLF переводит письмо к переводам строк LF, как его хранят mbox и Maildir
+func toLF(msg string) string {
+	return strings.ReplaceAll(msg, "\r\n", "\n")
+}
+
+const regularMessage = "From: ivan@example.org\nTo: sender@example.com\nSubject: Re: Договор\n\n" +
+	">From the contract it follows that...\n"
+
+func TestParseMbox(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bounces.mbox")
+	mbox := "From MAILER-DAEMON Mon Nov 13 07:00:10 2023\n" + toLF(dsnBounce) + "\n" +
+		"From ivan@example.org Mon Nov 13 08:00:00 2023\n" + regularMessage + "\n" +
+		"From feedback@fbl.example.net Tue Nov 14 12:00:00 2023\n" + toLF(arfReport) + "\n"
+	if err := os.WriteFile(path, []byte(mbox), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, parse := range map[string]func(string) ([]bounce.Report, error){
+		"ParseMbox": bounce.ParseMbox,
+		"ParsePath": bounce.ParsePath,
+	} {
+		reports, err := parse(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(reports) != 3 {
+			t.Fatalf("%s: got %d reports, want 3: %+v", name, len(reports), reports)
+		}
+		if reports[0].Recipient != "petr@example.org" || reports[0].Source != path+":1" {
+			t.Errorf("%s: first report = %+v", name, reports[0])
+		}
+		if reports[2].Type != bounce.TypeComplaint || reports[2].Source != path+":3" {
+			t.Errorf("%s: complaint = %+v", name, reports[2])
+		}
+	}
+}
+
+func TestParseMaildir(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		"new/1700000000.1.host":     toLF(dsnBounce),
+		"new/1700000001.1.host":     regularMessage,
+		"cur/1700000002.1.host:2,S": toLF(arfReport),
+		// Недописанное письмо из tmp не читается
+		"tmp/1700000003.1.host": "garbage",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reports, err := bounce.ParsePath(dir)
+	if err != nil {
+		t.Fatalf("ParsePath: %v", err)
+	}
+	if len(reports) != 3 {
+		t.Fatalf("got %d reports, want 3: %+v", len(reports), reports)
+	}
+	if reports[0].Source != filepath.Join(dir, "new/1700000000.1.host") || reports[2].Type != bounce.TypeComplaint {
+		t.Errorf("reports = %+v", reports)
+	}
+}
+
+func TestParsePathSingleMessage(t *testing.T) {
+	dir := t.TempDir()
+	bouncePath := filepath.Join(dir, "bounce.eml")
+	regularPath := filepath.Join(dir, "reply.eml")
+	if err := os.WriteFile(bouncePath, []byte(dsnBounce), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(regularPath, []byte(regularMessage), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	reports, err := bounce.ParsePath(bouncePath)
+	if err != nil || len(reports) != 2 || reports[0].Source != bouncePath {
+		t.Errorf("ParsePath(bounce) = %+v, %v", reports, err)
+	}
+	reports, err = bounce.ParsePath(regularPath)
+	if err != nil || len(reports) != 0 {
+		t.Errorf("ParsePath(reply) = %+v, %v, want no reports", reports, err)
+	}
+}
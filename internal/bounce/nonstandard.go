@@ -0,0 +1,207 @@
+package bounce
+
+import (
+	"errors"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+)
+
+// addressPattern — адрес электронной почты в тексте возврата
+const addressPattern = `[^\s<>()\[\]"',;:@]+@[^\s<>()\[\]"',;:@]+`
+
+var (
+	// bounceFrom — отправители, от имени которых серверы присылают возвраты
+	bounceFrom = regexp.MustCompile(`(?i)mailer-daemon|postmaster|mail delivery (subsystem|system)`)
+	// bounceSubject — темы возвратов распространенных серверов и почтовых служб
+	bounceSubject = regexp.MustCompile(`(?i)undeliver|delivery (status notification|failure|failed|has failed|problem)|` +
+		`mail delivery failed|returned mail|failure notice|could not be delivered|delayed mail|` +
+		`недоставлен|не доставлено|не удалось доставить`)
+
+	// enhancedStatus — расширенный код статуса (RFC 3463); IP-адреса и номера версий не подходят из-за соседних цифр и точек
+	enhancedStatus = regexp.MustCompile(`(?:^|[^\d.])([45]\.\d{1,3}\.\d{1,3})(?:[^\d.]|\.\D|\.?$)`)
+	// basicStatus — трехзначный код ответа SMTP, например "550 User unknown" или "550-Mailbox full"
+	basicStatus = regexp.MustCompile(`(?:^|[\s:])([45]\d\d)(?:[\s-]|$)`)
+	// delayedText — признаки уведомления о задержке, а не об окончательной недоставке
+	delayedText = regexp.MustCompile(`(?i)delayed|will (retry|keep trying|try again)|temporar|has not yet been delivered`)
+
+	// Строки, на которых нестандартные форматы называют получателя
+	rcptToLine      = regexp.MustCompile(`(?i)RCPT TO:\s*<(` + addressPattern + `)>`)
+	deliveredToLine = regexp.MustCompile(`(?i)(?:wasn't|was not|couldn't be|could not be|cannot be) delivered to\s+<?(` + addressPattern + `)>?`)
+	// addressLine — строка только с адресом: "<ivan@example.org>:" у qmail, "  ivan@example.org" у Exim
+	addressLine = regexp.MustCompile(`^<?(` + addressPattern + `)>?:?$`)
+
+	// originalMarker — начало копии исходного письма в тексте возврата; адреса после него не относятся к ошибкам
+	originalMarker = regexp.MustCompile(`(?im)^(-+ ?(Below this line is a copy|This is a copy|Original message|` +
+		`The header of the original|Message headers follow)|Return-Path:|Received:)`)
+	messageIDLine = regexp.MustCompile(`(?im)^\s*Message-ID:\s*(<[^>\s]+>)`)
+)
+
+// parseNonStandard разбирает возврат без message/delivery-status: заголовок X-Failed-Recipients (Exim),
+// текст qmail ("Hi. This is the qmail-send program") и похожие сообщения других серверов и почтовых служб
+func parseNonStandard(header mail.Header, body []byte) ([]Report, error) {
+	if !isBounce(header) {
+		return nil, ErrNotBounce
+	}
+
+	parts, err := leaves(textproto.MIMEHeader(header), body)
+	if err != nil && len(parts) == 0 {
+		return nil, err
+	}
+
+	var text strings.Builder
+	messageID := ""
+	for _, part := range parts {
+		switch {
+		case isOriginalMessage(part.mediaType):
+			if messageID == "" {
+				messageID = strings.TrimSpace(readFields(part.body).Get("Message-Id"))
+			}
+		case part.mediaType == "text/plain":
+			text.Write(part.body)
+			text.WriteString("\n")
+		}
+	}
+	if messageID == "" {
+		// qmail и многие другие серверы вставляют копию письма прямо в текст
+		if m := messageIDLine.FindStringSubmatch(text.String()); m != nil {
+			messageID = m[1]
+		}
+	}
+
+	explanation := text.String()
+	if loc := originalMarker.FindStringIndex(explanation); loc != nil {
+		explanation = explanation[:loc[0]]
+	}
+	lines := strings.Split(strings.ReplaceAll(explanation, "\r\n", "\n"), "\n")
+
+	recipients := failedRecipients(header, lines)
+	if len(recipients) == 0 {
+		return nil, errors.New("no failed recipient found in bounce message")
+	}
+
+	reports := make([]Report, 0, len(recipients))
+	for i, recipient := range recipients {
+		// Ошибка получателя описана между строкой с его адресом и строкой со следующим адресом
+		start, end := findLine(lines, recipient, 0), len(lines)
+		if start < 0 {
+			start = 0
+		} else if i+1 < len(recipients) {
+			if next := findLine(lines, recipients[i+1], start+1); next > start {
+				end = next
+			}
+		}
+		status, diagnostic, permanent := describeFailure(lines[start:end])
+
+		reports = append(reports, Report{
+			Type:       TypeBounce,
+			Recipient:  recipient,
+			Status:     status,
+			Diagnostic: diagnostic,
+			MessageID:  messageID,
+			Permanent:  permanent,
+		})
+	}
+	return reports, nil
+}
+
+// isBounce распознает возврат по отправителю, теме или заголовку X-Failed-Recipients
+func isBounce(header mail.Header) bool {
+	if header.Get("X-Failed-Recipients") != "" || bounceFrom.MatchString(header.Get("From")) {
+		return true
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(header.Get("Subject"))
+	if err != nil {
+		subject = header.Get("Subject")
+	}
+	return bounceSubject.MatchString(subject)
+}
+
+// failedRecipients возвращает адреса, до которых письмо не дошло, без повторов и без адресов самого возврата
+func failedRecipients(header mail.Header, lines []string) []string {
+	skip := map[string]bool{}
+	for _, field := range []string{"From", "To"} {
+		if list, err := header.AddressList(field); err == nil {
+			for _, addr := range list {
+				skip[strings.ToLower(addr.Address)] = true
+			}
+		}
+	}
+
+	var recipients []string
+	add := func(address string) {
+		address = strings.TrimRight(strings.TrimSpace(address), ".")
+		if address == "" || skip[strings.ToLower(address)] {
+			return
+		}
+		skip[strings.ToLower(address)] = true
+		recipients = append(recipients, address)
+	}
+
+	if failed := header.Get("X-Failed-Recipients"); failed != "" {
+		for _, address := range strings.Split(failed, ",") {
+			add(address)
+		}
+		return recipients
+	}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		for _, pattern := range []*regexp.Regexp{rcptToLine, deliveredToLine, addressLine} {
+			if m := pattern.FindStringSubmatch(line); m != nil {
+				add(m[1])
+				break
+			}
+		}
+	}
+	return recipients
+}
+
+// findLine возвращает номер первой строки не раньше from, в которой упоминается адрес, или -1
+func findLine(lines []string, address string, from int) int {
+	address = strings.ToLower(address)
+	for i := from; i < len(lines); i++ {
+		if strings.Contains(strings.ToLower(lines[i]), address) {
+			return i
+		}
+	}
+	return -1
+}
+
+// describeFailure находит в описании ошибки код статуса и ответ сервера и решает, окончательна ли ошибка
+func describeFailure(lines []string) (status, diagnostic string, permanent bool) {
+	basic := ""
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if m := enhancedStatus.FindStringSubmatch(line); m != nil && status == "" {
+			status = m[1]
+			diagnostic = line
+		}
+		if m := basicStatus.FindStringSubmatch(line); m != nil && basic == "" {
+			basic = m[1]
+			if diagnostic == "" {
+				diagnostic = line
+			}
+		}
+	}
+	if diagnostic == "" {
+		// Без кода берем первую строку пояснения после адреса
+		for _, line := range lines[min(1, len(lines)):] {
+			if line = strings.TrimSpace(line); line != "" {
+				diagnostic = line
+				break
+			}
+		}
+	}
+
+	switch {
+	case status != "":
+		permanent = status[0] == '5'
+	case basic != "":
+		permanent = basic[0] == '5'
+	default:
+		permanent = !delayedText.MatchString(strings.Join(lines, "\n"))
+	}
+	return status, diagnostic, permanent
+}
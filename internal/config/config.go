@@ -12,6 +12,79 @@ type Email struct {
 	Port     string
 	Username string
 	Password string
+	// Security — режим защиты соединения: tls, starttls, starttls-opportunistic или none
+	Security string
+	// Auth — механизм аутентификации: auto, none, plain, login, cram-md5 или xoauth2
+	Auth string
+	// OAuth2Token — токен доступа для XOAUTH2
+	OAuth2Token string
+	// Timeout — тайм-аут подключения и операций с сервером в формате time.ParseDuration, например 30s
+	Timeout string
+	// MaxAttempts — число попыток отправки при временных ошибках, включая первую
+	MaxAttempts string
+	// RateLimit — квоты на все письма аккаунта, например 20/m,500/h,2000/d
+	RateLimit string
+	// DomainRateLimit — квоты на письма в каждый домен получателей в том же формате
+	DomainRateLimit string
+	// RateJitter — случайная пауза до этой длительности перед каждым письмом рассылки, например 10s
+	RateJitter string
+	// TLSCAFile — PEM-файл с корпоративными или иными удостоверяющими центрами вместо системных
+	TLSCAFile string
+	// TLSCertFile и TLSKeyFile — клиентский сертификат и ключ в PEM
+	TLSCertFile string
+	TLSKeyFile  string
+	// TLSServerName — имя для проверки сертификата, если оно отличается от SMTP_HOST
+	TLSServerName string
+	// TLSMinVersion — минимальная версия TLS, например 1.2
+	TLSMinVersion string
+	// TLSPinSHA256 — закрепленный отпечаток SHA-256 сертификата сервера
+	TLSPinSHA256 string
+	// TLSInsecure — true отключает проверку сертификата; только для отладки
+	TLSInsecure string
+	// DKIMKeyFile — PEM-файл с закрытым ключом RSA или Ed25519; пустое значение отключает DKIM
+	DKIMKeyFile string
+	// DKIMDomain и DKIMSelector — домен подписи и селектор, под которым открытый ключ опубликован в DNS
+	DKIMDomain   string
+	DKIMSelector string
+	// DKIMCanonicalization — канонизация заголовков и тела, например relaxed/simple
+	DKIMCanonicalization string
+	// DKIMHeaders — подписываемые заголовки через запятую
+	DKIMHeaders string
+	// SMIMECertFile и SMIMEKeyFile — сертификат и ключ отправителя в PEM для подписи S/MIME
+	SMIMECertFile string
+	SMIMEKeyFile  string
+	// SMIMECertsDir — каталог с сертификатами получателей <адрес>.pem для шифрования S/MIME
+	SMIMECertsDir string
+	// SMIMESign и SMIMEEncrypt — true включает подпись и шифрование писем по S/MIME при рассылке
+	SMIMESign    string
+	SMIMEEncrypt string
+	// PGPKeyFile и PGPPassphrase — закрытый ключ OpenPGP отправителя для подписи и его пароль
+	PGPKeyFile    string
+	PGPPassphrase string
+	// PGPKeyringFile — связка открытых ключей получателей для шифрования PGP/MIME
+	PGPKeyringFile string
+	// From — адрес в заголовке From, можно с отображаемым именем: "Отдел продаж <sales@example.com>"
+	From string
+	// Sender — заголовок Sender, когда письмо отправляется от имени другого адреса
+	Sender string
+	// ReturnPath — адрес конверта MAIL FROM, на который приходят возвраты
+	ReturnPath string
+	// AlignedFrom — true запрещает From и Return-Path, отличные от аккаунта SMTP, если этого требует провайдер
+	AlignedFrom string
+	// Transport — куда доставлять письма: smtp (по умолчанию), file, mbox, maildir или memory
+	Transport string
+	// TransportPath — каталог или файл для транспортов file, mbox и maildir
+	TransportPath string
+	// SuppressionStore — список исключений: путь к CSV-файлу или строка подключения postgres://;
+	// пустое значение отключает проверку получателей
+	SuppressionStore string
+	// UnsubscribeURL — адрес обработчика POST /unsubscribe сервиса task4, например https://mail.example.com/unsubscribe;
+	// пустое значение отключает заголовки List-Unsubscribe
+	UnsubscribeURL string
+	// UnsubscribeSecret — ключ подписи персональных ссылок отписки, не короче 16 символов
+	UnsubscribeSecret string
+	// UnsubscribeMailto — необязательный адрес для отписки письмом
+	UnsubscribeMailto string
 }
 
 // App содержит всю конфигурацию приложения
@@ -35,10 +108,48 @@ func (d *DotenvConfigLoader) Load() (App, error) {
 	}
 
 	email := Email{
-		Host:     os.Getenv("SMTP_HOST"),
-		Port:     os.Getenv("SMTP_PORT"),
-		Username: os.Getenv("SMTP_USERNAME"),
-		Password: os.Getenv("SMTP_PASSWORD"),
+		Host:                 os.Getenv("SMTP_HOST"),
+		Port:                 os.Getenv("SMTP_PORT"),
+		Username:             os.Getenv("SMTP_USERNAME"),
+		Password:             os.Getenv("SMTP_PASSWORD"),
+		Security:             os.Getenv("SMTP_SECURITY"),
+		Auth:                 os.Getenv("SMTP_AUTH"),
+		OAuth2Token:          os.Getenv("SMTP_OAUTH2_TOKEN"),
+		Timeout:              os.Getenv("SMTP_TIMEOUT"),
+		MaxAttempts:          os.Getenv("SMTP_MAX_ATTEMPTS"),
+		RateLimit:            os.Getenv("SMTP_RATE_LIMIT"),
+		DomainRateLimit:      os.Getenv("SMTP_DOMAIN_RATE_LIMIT"),
+		RateJitter:           os.Getenv("SMTP_RATE_JITTER"),
+		TLSCAFile:            os.Getenv("SMTP_TLS_CA_FILE"),
+		TLSCertFile:          os.Getenv("SMTP_TLS_CERT_FILE"),
+		TLSKeyFile:           os.Getenv("SMTP_TLS_KEY_FILE"),
+		TLSServerName:        os.Getenv("SMTP_TLS_SERVER_NAME"),
+		TLSMinVersion:        os.Getenv("SMTP_TLS_MIN_VERSION"),
+		TLSPinSHA256:         os.Getenv("SMTP_TLS_PIN_SHA256"),
+		TLSInsecure:          os.Getenv("SMTP_TLS_INSECURE"),
+		DKIMKeyFile:          os.Getenv("DKIM_PRIVATE_KEY_FILE"),
+		DKIMDomain:           os.Getenv("DKIM_DOMAIN"),
+		DKIMSelector:         os.Getenv("DKIM_SELECTOR"),
+		DKIMCanonicalization: os.Getenv("DKIM_CANONICALIZATION"),
+		DKIMHeaders:          os.Getenv("DKIM_HEADERS"),
+		SMIMECertFile:        os.Getenv("SMIME_CERT_FILE"),
+		SMIMEKeyFile:         os.Getenv("SMIME_KEY_FILE"),
+		SMIMECertsDir:        os.Getenv("SMIME_CERTS_DIR"),
+		SMIMESign:            os.Getenv("SMIME_SIGN"),
+		SMIMEEncrypt:         os.Getenv("SMIME_ENCRYPT"),
+		PGPKeyFile:           os.Getenv("PGP_PRIVATE_KEY_FILE"),
+		PGPPassphrase:        os.Getenv("PGP_PASSPHRASE"),
+		PGPKeyringFile:       os.Getenv("PGP_KEYRING_FILE"),
+		From:                 os.Getenv("EMAIL_FROM"),
+		Sender:               os.Getenv("EMAIL_SENDER"),
+		ReturnPath:           os.Getenv("EMAIL_RETURN_PATH"),
+		AlignedFrom:          os.Getenv("SMTP_REQUIRE_ALIGNED_FROM"),
+		Transport:            os.Getenv("EMAIL_TRANSPORT"),
+		TransportPath:        os.Getenv("EMAIL_TRANSPORT_PATH"),
+		SuppressionStore:     os.Getenv("SUPPRESSION_STORE"),
+		UnsubscribeURL:       os.Getenv("UNSUBSCRIBE_URL"),
+		UnsubscribeSecret:    os.Getenv("UNSUBSCRIBE_SECRET"),
+		UnsubscribeMailto:    os.Getenv("UNSUBSCRIBE_MAILTO"),
 	}
 
 	return App{
@@ -0,0 +1,225 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+	"net/smtp"
+	"slices"
+	"strings"
+)
+
+// Mechanism — название механизма SMTP AUTH в том виде, в котором его объявляет сервер
+type Mechanism string
+
+const (
+	// MechanismPlain — AUTH PLAIN (RFC 4616)
+	MechanismPlain Mechanism = "PLAIN"
+	// MechanismLogin — устаревший, но распространенный AUTH LOGIN
+	MechanismLogin Mechanism = "LOGIN"
+	// MechanismCRAMMD5 — AUTH CRAM-MD5 (RFC 2195)
+	MechanismCRAMMD5 Mechanism = "CRAM-MD5"
+	// MechanismXOAUTH2 — AUTH XOAUTH2 с OAuth2-токеном доступа (Gmail, Outlook)
+	MechanismXOAUTH2 Mechanism = "XOAUTH2"
+)
+
+var (
+	// ErrAuthNotSupported возвращается, когда аутентификация настроена, а сервер не объявляет AUTH
+	ErrAuthNotSupported = errors.New("SMTP server does not support AUTH")
+	// ErrNoCommonMechanism возвращается, когда ни один из настроенных механизмов не объявлен сервером
+	ErrNoCommonMechanism = errors.New("no common SMTP AUTH mechanism")
+)
+
+// Authenticator выбирает механизм аутентификации по списку, который объявил сервер
+type Authenticator interface {
+	// Authenticate возвращает smtp.Auth для одного из объявленных механизмов
+	// или nil, если аутентификация не требуется
+	Authenticate(host string, advertised []Mechanism) (smtp.Auth, error)
+}
+
+// TokenSource выдает OAuth2-токены доступа для XOAUTH2
+type TokenSource interface {
+	// Token возвращает действующий токен доступа, при необходимости обновляя его
+	Token() (string, error)
+}
+
+// StaticTokenSource — TokenSource, всегда возвращающий один и тот же токен
+type StaticTokenSource string
+
+// Token возвращает сохраненный токен
+func (t StaticTokenSource) Token() (string, error) {
+	if t == "" {
+		return "", errors.New("empty OAuth2 token")
+	}
+	return string(t), nil
+}
+
+// noAuthenticator пропускает аутентификацию (локальные релеи)
+type noAuthenticator struct{}
+
+// NoAuth возвращает Authenticator, который не выполняет AUTH
+func NoAuth() Authenticator {
+	return noAuthenticator{}
+}
+
+func (noAuthenticator) Authenticate(string, []Mechanism) (smtp.Auth, error) {
+	return nil, nil
+}
+
+// passwordAuthenticator выбирает первый из разрешенных парольных механизмов, объявленный сервером
+type passwordAuthenticator struct {
+	username   string
+	password   string
+	mechanisms []Mechanism
+}
+
+// PasswordAuth возвращает Authenticator для логина и пароля.
+// Механизмы перечисляются в порядке предпочтения; по умолчанию PLAIN, LOGIN, CRAM-MD5.
+func PasswordAuth(username, password string, mechanisms ...Mechanism) Authenticator {
+	if len(mechanisms) == 0 {
+		mechanisms = []Mechanism{MechanismPlain, MechanismLogin, MechanismCRAMMD5}
+	}
+	return &passwordAuthenticator{
+		username:   username,
+		password:   password,
+		mechanisms: mechanisms,
+	}
+}
+
+func (a *passwordAuthenticator) Authenticate(host string, advertised []Mechanism) (smtp.Auth, error) {
+	for _, m := range a.mechanisms {
+		if !slices.Contains(advertised, m) {
+			continue
+		}
+		switch m {
+		case MechanismPlain:
+			return smtp.PlainAuth("", a.username, a.password, host), nil
+		case MechanismLogin:
+			return &loginAuth{username: a.username, password: a.password, host: host}, nil
+		case MechanismCRAMMD5:
+			return smtp.CRAMMD5Auth(a.username, a.password), nil
+		default:
+			return nil, fmt.Errorf("mechanism %s does not use a password", m)
+		}
+	}
+	return nil, fmt.Errorf("%w: configured %v, server offers %v", ErrNoCommonMechanism, a.mechanisms, advertised)
+}
+
+// oauth2Authenticator выполняет AUTH XOAUTH2 с токеном из TokenSource
+type oauth2Authenticator struct {
+	username string
+	tokens   TokenSource
+}
+
+// OAuth2Auth возвращает Authenticator для XOAUTH2
+func OAuth2Auth(username string, tokens TokenSource) Authenticator {
+	return &oauth2Authenticator{username: username, tokens: tokens}
+}
+
+func (a *oauth2Authenticator) Authenticate(host string, advertised []Mechanism) (smtp.Auth, error) {
+	if !slices.Contains(advertised, MechanismXOAUTH2) {
+		return nil, fmt.Errorf("%w: configured %s, server offers %v", ErrNoCommonMechanism, MechanismXOAUTH2, advertised)
+	}
+
+	token, err := a.tokens.Token()
+	if err != nil {
+		return nil, fmt.Errorf("error getting OAuth2 token: %w", err)
+	}
+
+	return &xoauth2Auth{username: a.username, token: token, host: host}, nil
+}
+
+// ParseAuthenticator создает Authenticator по названию из конфигурации:
+// auto (пустая строка), none, plain, login, cram-md5 или xoauth2
+func ParseAuthenticator(name, username, password, token string) (Authenticator, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "auto":
+		return PasswordAuth(username, password), nil
+	case "none":
+		return NoAuth(), nil
+	case "plain":
+		return PasswordAuth(username, password, MechanismPlain), nil
+	case "login":
+		return PasswordAuth(username, password, MechanismLogin), nil
+	case "cram-md5":
+		return PasswordAuth(username, password, MechanismCRAMMD5), nil
+	case "xoauth2":
+		return OAuth2Auth(username, StaticTokenSource(token)), nil
+	default:
+		return nil, fmt.Errorf("unknown SMTP auth mechanism %q", name)
+	}
+}
+
+// parseMechanisms разбирает параметры расширения AUTH из ответа на EHLO
+func parseMechanisms(params string) []Mechanism {
+	var mechanisms []Mechanism
+	for _, field := range strings.Fields(params) {
+		mechanisms = append(mechanisms, Mechanism(strings.ToUpper(field)))
+	}
+	return mechanisms
+}
+
+// requireTLS запрещает передавать секреты по открытому соединению, кроме как на localhost,
+// по тем же правилам, что и smtp.PlainAuth
+func requireTLS(server *smtp.ServerInfo, host string) error {
+	if server.Name != host {
+		return errors.New("wrong host name")
+	}
+	if !server.TLS && server.Name != "localhost" && server.Name != "127.0.0.1" && server.Name != "::1" {
+		return errors.New("unencrypted connection")
+	}
+	return nil
+}
+
+// loginAuth реализует AUTH LOGIN
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if err := requireTLS(server, a.host); err != nil {
+		return "", nil, err
+	}
+	return string(MechanismLogin), nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	// Сервер спрашивает "Username:" и "Password:", но встречаются и другие варианты написания
+	prompt := strings.ToLower(string(fromServer))
+	switch {
+	case strings.HasPrefix(prompt, "user"):
+		return []byte(a.username), nil
+	case strings.HasPrefix(prompt, "pass"):
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected LOGIN challenge %q", fromServer)
+	}
+}
+
+// xoauth2Auth реализует AUTH XOAUTH2
+type xoauth2Auth struct {
+	username string
+	token    string
+	host     string
+}
+
+func (a *xoauth2Auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if err := requireTLS(server, a.host); err != nil {
+		return "", nil, err
+	}
+	resp := "user=" + a.username + "\x01auth=Bearer " + a.token + "\x01\x01"
+	return string(MechanismXOAUTH2), []byte(resp), nil
+}
+
+func (a *xoauth2Auth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		// При ошибке сервер присылает JSON с описанием, а не очередной вопрос
+		return nil, fmt.Errorf("XOAUTH2 rejected: %s", fromServer)
+	}
+	return nil, nil
+}
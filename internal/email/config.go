@@ -0,0 +1,183 @@
+package email
+
+import (
+	"fmt"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mclyashko/IPORPIS/internal/config"
+)
+
+// NewSMTPSenderFromConfig создает SMTP-отправитель по настройкам из .env: режим защиты, аутентификацию,
+// тайм-аут, TLS, DKIM и адреса отправителя
+func NewSMTPSenderFromConfig(cfg config.Email) (*SMTPSender, error) {
+	security, err := ParseSecurity(cfg.Security)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP security mode: %w", err)
+	}
+
+	auth, err := ParseAuthenticator(cfg.Auth, cfg.Username, cfg.Password, cfg.OAuth2Token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP auth mechanism: %w", err)
+	}
+
+	timeout := DefaultTimeout
+	if cfg.Timeout != "" {
+		if timeout, err = time.ParseDuration(cfg.Timeout); err != nil {
+			return nil, fmt.Errorf("invalid SMTP timeout: %w", err)
+		}
+	}
+
+	insecure, err := parseOptionalBool("SMTP_TLS_INSECURE", cfg.TLSInsecure)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := NewTLSConfig(TLSOptions{
+		CAFile:     cfg.TLSCAFile,
+		CertFile:   cfg.TLSCertFile,
+		KeyFile:    cfg.TLSKeyFile,
+		ServerName: cfg.TLSServerName,
+		MinVersion: cfg.TLSMinVersion,
+		PinSHA256:  cfg.TLSPinSHA256,
+		Insecure:   insecure,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP TLS settings: %w", err)
+	}
+
+	dkim, err := NewDKIMSignerFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []Option{
+		WithSecurity(security),
+		WithAuth(auth),
+		WithTimeout(timeout),
+		WithTLSConfig(tlsConfig),
+		WithDKIM(dkim),
+		WithReturnPath(cfg.ReturnPath),
+	}
+	if cfg.From != "" {
+		from, err := mail.ParseAddress(cfg.From)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EMAIL_FROM address: %w", err)
+		}
+		opts = append(opts, WithFrom(Address{Name: from.Name, Address: from.Address}))
+	}
+	if cfg.Sender != "" {
+		sender, err := mail.ParseAddress(cfg.Sender)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EMAIL_SENDER address: %w", err)
+		}
+		opts = append(opts, WithSenderHeader(Address{Name: sender.Name, Address: sender.Address}))
+	}
+	if cfg.AlignedFrom != "" {
+		aligned, err := parseOptionalBool("SMTP_REQUIRE_ALIGNED_FROM", cfg.AlignedFrom)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithAlignedFrom(aligned))
+	}
+
+	return NewSMTPSender(cfg.Host, cfg.Port, cfg.Username, cfg.Password, opts...)
+}
+
+// NewDKIMSignerFromConfig создает DKIM-подпись по настройкам из .env или возвращает nil, если ключ не задан
+func NewDKIMSignerFromConfig(cfg config.Email) (*DKIMSigner, error) {
+	if cfg.DKIMKeyFile == "" {
+		return nil, nil
+	}
+
+	key, err := LoadDKIMKey(cfg.DKIMKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DKIM key: %w", err)
+	}
+
+	headerCanon, bodyCanon, err := ParseCanonicalization(cfg.DKIMCanonicalization)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DKIM canonicalization: %w", err)
+	}
+
+	var headers []string
+	for _, header := range strings.Split(cfg.DKIMHeaders, ",") {
+		if header = strings.TrimSpace(header); header != "" {
+			headers = append(headers, header)
+		}
+	}
+
+	signer, err := NewDKIMSigner(DKIMOptions{
+		Domain:                 cfg.DKIMDomain,
+		Selector:               cfg.DKIMSelector,
+		Key:                    key,
+		HeaderCanonicalization: headerCanon,
+		BodyCanonicalization:   bodyCanon,
+		Headers:                headers,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid DKIM settings: %w", err)
+	}
+	return signer, nil
+}
+
+// NewLocalSenderFromConfig создает отправитель локального транспорта, заданного в .env,
+// или возвращает nil, если письма нужно отправлять через SMTP-сервер
+func NewLocalSenderFromConfig(cfg config.Email) (Sender, error) {
+	transport, err := ParseTransport(cfg.Transport)
+	if err != nil {
+		return nil, err
+	}
+	if transport == TransportSMTP {
+		return nil, nil
+	}
+
+	sender, err := NewLocalSender(transport, cfg.TransportPath, cfg.Username)
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s sender: %w", transport, err)
+	}
+	return sender, nil
+}
+
+// NewSMIMEProtectionFromConfig возвращает защиту писем S/MIME, включенную в .env через SMIME_SIGN
+// и SMIME_ENCRYPT, или nil, если письма отправляются открытыми
+func NewSMIMEProtectionFromConfig(cfg config.Email) (Protection, error) {
+	sign, err := parseOptionalBool("SMIME_SIGN", cfg.SMIMESign)
+	if err != nil {
+		return nil, err
+	}
+	encrypt, err := parseOptionalBool("SMIME_ENCRYPT", cfg.SMIMEEncrypt)
+	if err != nil {
+		return nil, err
+	}
+	if !sign && !encrypt {
+		return nil, nil
+	}
+
+	smime, err := NewSMIME(SMIMEOptions{
+		CertFile: cfg.SMIMECertFile,
+		KeyFile:  cfg.SMIMEKeyFile,
+		CertsDir: cfg.SMIMECertsDir,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid S/MIME settings: %w", err)
+	}
+	protection, err := smime.Protection(sign, encrypt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S/MIME settings: %w", err)
+	}
+	return protection, nil
+}
+
+// parseOptionalBool разбирает необязательный флаг из .env; пустое значение означает false
+func parseOptionalBool(name, value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s value: %w", name, err)
+	}
+	return b, nil
+}
@@ -0,0 +1,120 @@
+package email_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mclyashko/IPORPIS/internal/config"
+	"github.com/mclyashko/IPORPIS/internal/email"
+	"github.com/mclyashko/IPORPIS/internal/email/emailtest"
+)
+
+// writeDKIMKey сохраняет новый ключ Ed25519 в PEM-файл и возвращает путь к нему
+func writeDKIMKey(t *testing.T) string {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "dkim.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNewSMTPSenderFromConfig(t *testing.T) {
+	server := emailtest.NewServer(t)
+
+	sender, err := email.NewSMTPSenderFromConfig(config.Email{
+		Host:         server.Host,
+		Port:         server.Port,
+		Username:     "sender@example.com",
+		Security:     "none",
+		Auth:         "none",
+		Timeout:      "5s",
+		DKIMKeyFile:  writeDKIMKey(t),
+		DKIMDomain:   "example.com",
+		DKIMSelector: "mail",
+		From:         "Отдел продаж <sales@example.com>",
+		ReturnPath:   "bounces@example.com",
+	})
+	if err != nil {
+		t.Fatalf("NewSMTPSenderFromConfig: %v", err)
+	}
+
+	_, err = sender.SendMessage(context.Background(), &email.Message{
+		To:    []email.Address{{Address: "rcpt@example.com"}},
+		Parts: []email.Part{email.TextPart("body")},
+	})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	msg := server.Messages()[0]
+	if msg.From != "bounces@example.com" {
+		t.Errorf("MAIL FROM = %q, want the configured return path", msg.From)
+	}
+	if got := msg.Header.Get("From"); !strings.Contains(got, "sales@example.com") {
+		t.Errorf("From = %q", got)
+	}
+	if got := msg.Header.Get("DKIM-Signature"); !strings.Contains(got, "d=example.com") {
+		t.Errorf("DKIM-Signature = %q", got)
+	}
+}
+
+func TestNewSMTPSenderFromConfigInvalid(t *testing.T) {
+	tests := map[string]config.Email{
+		"security":     {Security: "ssl3"},
+		"auth":         {Auth: "kerberos"},
+		"timeout":      {Timeout: "soon"},
+		"tls insecure": {TLSInsecure: "maybe"},
+		"tls pin":      {TLSPinSHA256: "abc"},
+		"dkim key":     {DKIMKeyFile: filepath.Join(t.TempDir(), "missing.pem")},
+		"from":         {From: "not an address"},
+		"aligned from": {AlignedFrom: "sometimes"},
+	}
+	for name, cfg := range tests {
+		cfg.Host, cfg.Port = "smtp.example.com", "465"
+		if _, err := email.NewSMTPSenderFromConfig(cfg); err == nil {
+			t.Errorf("%s: NewSMTPSenderFromConfig succeeded", name)
+		}
+	}
+}
+
+func TestNewLocalSenderFromConfig(t *testing.T) {
+	if sender, err := email.NewLocalSenderFromConfig(config.Email{}); err != nil || sender != nil {
+		t.Errorf("SMTP transport: sender = %v, err = %v; want nil, nil", sender, err)
+	}
+	if sender, err := email.NewLocalSenderFromConfig(config.Email{Transport: "memory"}); err != nil || sender == nil {
+		t.Errorf("memory transport: sender = %v, err = %v", sender, err)
+	}
+	if _, err := email.NewLocalSenderFromConfig(config.Email{Transport: "pigeon"}); err == nil {
+		t.Error("unknown transport accepted")
+	}
+}
+
+func TestNewSMIMEProtectionFromConfig(t *testing.T) {
+	if protection, err := email.NewSMIMEProtectionFromConfig(config.Email{}); err != nil || protection != nil {
+		t.Errorf("S/MIME disabled: protection = %v, err = %v; want nil, nil", protection, err)
+	}
+	if _, err := email.NewSMIMEProtectionFromConfig(config.Email{SMIMESign: "yes please"}); err == nil {
+		t.Error("invalid SMIME_SIGN accepted")
+	}
+	// Подпись включена, а сертификат не задан: ошибка, а не молчаливая отправка без подписи
+	if _, err := email.NewSMIMEProtectionFromConfig(config.Email{SMIMESign: "true"}); err == nil {
+		t.Error("SMIME_SIGN without a certificate accepted")
+	}
+}
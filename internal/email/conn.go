@@ -0,0 +1,99 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// DefaultTimeout — тайм-аут подключения и каждой операции чтения или записи по умолчанию
+const DefaultTimeout = 30 * time.Second
+
+// ctxConn ограничивает каждую операцию ввода-вывода тайм-аутом и прерывает ее при отмене контекста
+type ctxConn struct {
+	net.Conn
+	ctx     context.Context
+	timeout time.Duration
+	stop    func() bool
+}
+
+// newCtxConn оборачивает соединение и привязывает его к ctx
+func newCtxConn(ctx context.Context, conn net.Conn, timeout time.Duration) *ctxConn {
+	c := &ctxConn{Conn: conn, timeout: timeout}
+	c.bind(ctx)
+	return c
+}
+
+// bind перепривязывает соединение к другому контексту, например при выдаче из пула.
+// Вызывать можно только тогда, когда с соединением никто не работает.
+func (c *ctxConn) bind(ctx context.Context) {
+	if c.stop != nil {
+		c.stop()
+	}
+	c.ctx = ctx
+
+	// Отмена контекста сдвигает дедлайн в прошлое, и заблокированные Read/Write сразу возвращаются
+	conn := c.Conn
+	c.stop = context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Unix(1, 0))
+	})
+}
+
+// Close отвязывает соединение от контекста и закрывает его
+func (c *ctxConn) Close() error {
+	c.stop()
+	return c.Conn.Close()
+}
+
+func (c *ctxConn) Read(p []byte) (int, error) {
+	if err := c.extendDeadline(); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *ctxConn) Write(p []byte) (int, error) {
+	if err := c.extendDeadline(); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(p)
+}
+
+// extendDeadline устанавливает дедлайн следующей операции: тайм-аут, но не позже дедлайна контекста
+func (c *ctxConn) extendDeadline() error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+
+	deadline := time.Time{}
+	if c.timeout > 0 {
+		deadline = time.Now().Add(c.timeout)
+	}
+	if ctxDeadline, ok := c.ctx.Deadline(); ok && (deadline.IsZero() || ctxDeadline.Before(deadline)) {
+		deadline = ctxDeadline
+	}
+	if err := c.Conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+
+	// Контекст мог быть отменен между проверкой и установкой дедлайна
+	if err := c.ctx.Err(); err != nil {
+		_ = c.Conn.SetDeadline(time.Unix(1, 0))
+		return err
+	}
+	return nil
+}
+
+// contextError оборачивает ошибку в ошибку контекста, если операция прервана из-за отмены или дедлайна,
+// чтобы вызывающий мог проверить ее через errors.Is(err, context.Canceled)
+func contextError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil && !errors.Is(err, ctxErr) {
+		return fmt.Errorf("%w: %w", ctxErr, err)
+	}
+	return err
+}
@@ -0,0 +1,334 @@
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Canonicalization — алгоритм канонизации DKIM (RFC 6376, раздел 3.4)
+type Canonicalization string
+
+const (
+	// CanonicalizationSimple не допускает никаких изменений письма по пути
+	CanonicalizationSimple Canonicalization = "simple"
+	// CanonicalizationRelaxed допускает перенос заголовков и изменение пробелов
+	CanonicalizationRelaxed Canonicalization = "relaxed"
+)
+
+// DefaultDKIMHeaders — заголовки, которые подписываются, если список не задан.
+// Отсутствующие в письме заголовки пропускаются.
+var DefaultDKIMHeaders = []string{
+	"From", "Sender", "Reply-To", "Subject", "Date", "Message-ID", "To", "Cc",
+	"MIME-Version", "Content-Type", "Content-Transfer-Encoding",
+	"In-Reply-To", "References", "List-Unsubscribe", "List-Unsubscribe-Post",
+}
+
+// DKIMOptions описывает параметры DKIM-подписи
+type DKIMOptions struct {
+	// Domain — домен подписи (тег d=), обычно домен адреса From
+	Domain string
+	// Selector — селектор (тег s=): открытый ключ публикуется в DNS как <selector>._domainkey.<domain>
+	Selector string
+	// Key — закрытый ключ *rsa.PrivateKey или ed25519.PrivateKey
+	Key crypto.Signer
+	// HeaderCanonicalization и BodyCanonicalization по умолчанию relaxed и simple
+	HeaderCanonicalization Canonicalization
+	BodyCanonicalization   Canonicalization
+	// Headers — подписываемые заголовки; по умолчанию DefaultDKIMHeaders
+	Headers []string
+}
+
+// DKIMSigner подписывает готовые письма заголовком DKIM-Signature
+type DKIMSigner struct {
+	opts      DKIMOptions
+	algorithm string
+	now       func() time.Time
+}
+
+// NewDKIMSigner проверяет параметры и создает DKIMSigner
+func NewDKIMSigner(opts DKIMOptions) (*DKIMSigner, error) {
+	if opts.Domain == "" || opts.Selector == "" {
+		return nil, errors.New("DKIM domain and selector are required")
+	}
+	if opts.HeaderCanonicalization == "" {
+		opts.HeaderCanonicalization = CanonicalizationRelaxed
+	}
+	if opts.BodyCanonicalization == "" {
+		opts.BodyCanonicalization = CanonicalizationSimple
+	}
+	for _, c := range []Canonicalization{opts.HeaderCanonicalization, opts.BodyCanonicalization} {
+		if c != CanonicalizationSimple && c != CanonicalizationRelaxed {
+			return nil, fmt.Errorf("unknown DKIM canonicalization %q", c)
+		}
+	}
+	if len(opts.Headers) == 0 {
+		opts.Headers = DefaultDKIMHeaders
+	}
+	if !containsFold(opts.Headers, "From") {
+		return nil, errors.New("DKIM signed headers must include From")
+	}
+
+	d := &DKIMSigner{opts: opts, now: time.Now}
+	switch opts.Key.(type) {
+	case *rsa.PrivateKey:
+		d.algorithm = "rsa-sha256"
+	case ed25519.PrivateKey:
+		d.algorithm = "ed25519-sha256"
+	default:
+		return nil, fmt.Errorf("unsupported DKIM key type %T", opts.Key)
+	}
+
+	return d, nil
+}
+
+// ParseCanonicalization разбирает значение вида relaxed/simple; одно слово задает канонизацию только заголовков
+func ParseCanonicalization(s string) (header, body Canonicalization, err error) {
+	if strings.TrimSpace(s) == "" {
+		return CanonicalizationRelaxed, CanonicalizationSimple, nil
+	}
+
+	h, b, found := strings.Cut(strings.ToLower(strings.TrimSpace(s)), "/")
+	header, body = Canonicalization(h), CanonicalizationSimple
+	if found {
+		body = Canonicalization(b)
+	}
+	for _, c := range []Canonicalization{header, body} {
+		if c != CanonicalizationSimple && c != CanonicalizationRelaxed {
+			return "", "", fmt.Errorf("unknown DKIM canonicalization %q", s)
+		}
+	}
+	return header, body, nil
+}
+
+// LoadDKIMKey читает закрытый ключ RSA или Ed25519 из PEM-файла
+func LoadDKIMKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading DKIM key: %w", err)
+	}
+	return ParseDKIMKey(data)
+}
+
+// ParseDKIMKey разбирает закрытый ключ в PEM: PKCS#1 для RSA или PKCS#8 для RSA и Ed25519
+func ParseDKIMKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("DKIM key is not PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing DKIM key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported DKIM key type %T", key)
+	}
+	return signer, nil
+}
+
+// Sign возвращает заголовок DKIM-Signature с завершающим CRLF, который нужно поставить перед письмом.
+// message — письмо целиком в формате RFC 5322 с окончаниями строк CRLF.
+func (d *DKIMSigner) Sign(message []byte) (string, error) {
+	header, body := splitMessage(message)
+	fields := parseHeaderFields(header)
+
+	bodyHash := sha256.Sum256(canonicalBody(body, d.opts.BodyCanonicalization))
+
+	// Заголовки выбираются снизу вверх: повторяющееся имя подписывает следующее сверху вхождение
+	used := make(map[int]bool)
+	var names []string
+	signed := &bytes.Buffer{}
+	for _, name := range d.opts.Headers {
+		for i := len(fields) - 1; i >= 0; i-- {
+			if used[i] || !strings.EqualFold(fields[i].name, name) {
+				continue
+			}
+			used[i] = true
+			names = append(names, fields[i].name)
+			signed.WriteString(canonicalHeader(fields[i], d.opts.HeaderCanonicalization))
+		}
+	}
+
+	tags := []string{
+		"v=1",
+		"a=" + d.algorithm,
+		fmt.Sprintf("c=%s/%s", d.opts.HeaderCanonicalization, d.opts.BodyCanonicalization),
+		"d=" + d.opts.Domain,
+		"s=" + d.opts.Selector,
+		fmt.Sprintf("t=%d", d.now().Unix()),
+		"h=" + strings.Join(names, ":"),
+		"bh=" + base64.StdEncoding.EncodeToString(bodyHash[:]),
+		"b=",
+	}
+	signature := foldTags("DKIM-Signature: ", tags)
+
+	// Сам заголовок подписи входит в подпись с пустым b= и без завершающего CRLF
+	canonical := canonicalHeader(headerField{name: "DKIM-Signature", raw: signature + "\r\n"}, d.opts.HeaderCanonicalization)
+	signed.WriteString(strings.TrimSuffix(canonical, "\r\n"))
+
+	digest := sha256.Sum256(signed.Bytes())
+	var opts crypto.SignerOpts = crypto.SHA256
+	if d.algorithm == "ed25519-sha256" {
+		// RFC 8463: Ed25519 подписывает уже посчитанный SHA-256 как обычное сообщение
+		opts = crypto.Hash(0)
+	}
+	sig, err := d.opts.Key.Sign(rand.Reader, digest[:], opts)
+	if err != nil {
+		return "", fmt.Errorf("error signing message with DKIM: %w", err)
+	}
+
+	return signature + foldBase64(base64.StdEncoding.EncodeToString(sig)) + "\r\n", nil
+}
+
+// headerField — один заголовок письма вместе с продолжениями строк
+type headerField struct {
+	name string
+	// raw — заголовок в исходном виде, включая CRLF
+	raw string
+}
+
+// splitMessage отделяет заголовки от тела по первой пустой строке
+func splitMessage(message []byte) (header, body []byte) {
+	if i := bytes.Index(message, []byte("\r\n\r\n")); i >= 0 {
+		return message[:i+2], message[i+4:]
+	}
+	return message, nil
+}
+
+func parseHeaderFields(header []byte) []headerField {
+	var fields []headerField
+	for _, line := range strings.SplitAfter(string(header), "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1].raw += line
+			continue
+		}
+		name, _, _ := strings.Cut(line, ":")
+		fields = append(fields, headerField{name: strings.TrimRight(name, " \t"), raw: line})
+	}
+	return fields
+}
+
+// canonicalHeader канонизирует один заголовок; результат заканчивается CRLF
+func canonicalHeader(f headerField, c Canonicalization) string {
+	if c == CanonicalizationSimple {
+		return f.raw
+	}
+
+	_, value, _ := strings.Cut(f.raw, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = strings.Join(strings.FieldsFunc(value, isWSP), " ")
+	return strings.ToLower(f.name) + ":" + value + "\r\n"
+}
+
+// canonicalBody канонизирует тело письма
+func canonicalBody(body []byte, c Canonicalization) []byte {
+	if c == CanonicalizationRelaxed {
+		lines := bytes.SplitAfter(body, []byte("\r\n"))
+		var buf bytes.Buffer
+		for _, line := range lines {
+			content, hasCRLF := bytes.CutSuffix(line, []byte("\r\n"))
+			// Пробелы в конце строки удаляются, остальные последовательности пробелов схлопываются
+			content = bytes.TrimRight(content, " \t")
+			buf.Write(collapseWSP(content))
+			if hasCRLF {
+				buf.WriteString("\r\n")
+			}
+		}
+		body = buf.Bytes()
+	}
+
+	// Пустые строки в конце тела не учитываются
+	for bytes.HasSuffix(body, []byte("\r\n")) {
+		body = body[:len(body)-2]
+	}
+	if len(body) == 0 && c == CanonicalizationRelaxed {
+		return nil
+	}
+	return append(bytes.Clone(body), '\r', '\n')
+}
+
+func collapseWSP(line []byte) []byte {
+	var out []byte
+	inWSP := false
+	for _, b := range line {
+		if b == ' ' || b == '\t' {
+			if !inWSP {
+				out = append(out, ' ')
+			}
+			inWSP = true
+			continue
+		}
+		inWSP = false
+		out = append(out, b)
+	}
+	return out
+}
+
+func isWSP(r rune) bool {
+	return r == ' ' || r == '\t'
+}
+
+// foldTags собирает заголовок из тегов, перенося строки между тегами, чтобы они не превышали 78 символов
+func foldTags(prefix string, tags []string) string {
+	var b strings.Builder
+	b.WriteString(prefix)
+	lineLen := len(prefix)
+	for i, tag := range tags {
+		if i < len(tags)-1 {
+			tag += ";"
+		}
+		if i > 0 {
+			if lineLen+1+len(tag) > 78 {
+				b.WriteString("\r\n ")
+				lineLen = 1
+			} else {
+				b.WriteString(" ")
+				lineLen++
+			}
+		}
+		b.WriteString(tag)
+		lineLen += len(tag)
+	}
+	return b.String()
+}
+
+// foldBase64 разбивает значение b= на строки; пробелы внутри b= при проверке игнорируются
+func foldBase64(s string) string {
+	const width = 72
+	var b strings.Builder
+	for len(s) > width {
+		b.WriteString(s[:width])
+		b.WriteString("\r\n ")
+		s = s[width:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,255 @@
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// Пример из RFC 8463, приложение A
+const (
+	rfc8463Message = "From: Joe SixPack <joe@football.example.com>\r\n" +
+		"To: Suzie Q <suzie@shopping.example.net>\r\n" +
+		"Subject: Is dinner ready?\r\n" +
+		"Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)\r\n" +
+		"Message-ID: <20030712040037.46341.5F8J@football.example.com>\r\n" +
+		"\r\n" +
+		"Hi.\r\n\r\nWe lost the game.  Are you hungry yet?\r\n\r\nJoe.\r\n"
+	rfc8463Signature = "DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed;\r\n" +
+		" d=football.example.com; i=@football.example.com;\r\n" +
+		" q=dns/txt; s=brisbane; t=1528637909; h=from : to :\r\n" +
+		" subject : date : message-id : from : subject : date;\r\n" +
+		" bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;\r\n" +
+		" b=/gCrinpcQOoIfuHNQIbq4pgh9kyIK3AQUdt9OdqQehSwhEIug4D11Bus\r\n" +
+		" Fa3bT3FY5OsU7ZbnKELq+eXdp1Q1Dw==\r\n"
+	rfc8463Seed = "nWGxne/9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A="
+)
+
+var dkimTagRe = regexp.MustCompile(`\s*([a-z]+)\s*=\s*([^;]*)`)
+
+// verifyDKIM проверяет первый заголовок DKIM-Signature письма открытым ключом pub
+func verifyDKIM(message []byte, pub crypto.PublicKey) error {
+	header, body := splitMessage(message)
+	fields := parseHeaderFields(header)
+
+	sigIndex := -1
+	for i, f := range fields {
+		if strings.EqualFold(f.name, "DKIM-Signature") {
+			sigIndex = i
+			break
+		}
+	}
+	if sigIndex < 0 {
+		return errors.New("no DKIM-Signature")
+	}
+	sigField := fields[sigIndex]
+
+	_, value, _ := strings.Cut(sigField.raw, ":")
+	tags := make(map[string]string)
+	for _, m := range dkimTagRe.FindAllStringSubmatch(value, -1) {
+		tags[m[1]] = strings.Join(strings.Fields(m[2]), "")
+	}
+
+	hc, bc, _ := strings.Cut(tags["c"], "/")
+	if bc == "" {
+		bc = "simple"
+	}
+
+	bh := sha256.Sum256(canonicalBody(body, Canonicalization(bc)))
+	if got := base64.StdEncoding.EncodeToString(bh[:]); got != tags["bh"] {
+		return fmt.Errorf("body hash mismatch: %s != %s", got, tags["bh"])
+	}
+
+	used := map[int]bool{sigIndex: true}
+	var signed bytes.Buffer
+	for _, name := range strings.Split(tags["h"], ":") {
+		for i := len(fields) - 1; i >= 0; i-- {
+			if used[i] || !strings.EqualFold(fields[i].name, strings.TrimSpace(name)) {
+				continue
+			}
+			used[i] = true
+			signed.WriteString(canonicalHeader(fields[i], Canonicalization(hc)))
+			break
+		}
+	}
+	stripped := regexp.MustCompile(`b=[^;]*(\r\n)?$`).ReplaceAllString(sigField.raw, "b=")
+	canonical := canonicalHeader(headerField{name: sigField.name, raw: stripped + "\r\n"}, Canonicalization(hc))
+	signed.WriteString(strings.TrimSuffix(canonical, "\r\n"))
+
+	sig, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(signed.Bytes())
+	switch key := pub.(type) {
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, digest[:], sig) {
+			return errors.New("bad ed25519 signature")
+		}
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig)
+	}
+	return nil
+}
+
+func TestVerifyDKIMAgainstRFC8463(t *testing.T) {
+	seed, _ := base64.StdEncoding.DecodeString(rfc8463Seed)
+	pub := ed25519.NewKeyFromSeed(seed).Public()
+
+	if err := verifyDKIM([]byte(rfc8463Signature+rfc8463Message), pub); err != nil {
+		t.Fatalf("RFC 8463 example does not verify: %v", err)
+	}
+}
+
+func TestDKIMSignerRoundTrip(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed, _ := base64.StdEncoding.DecodeString(rfc8463Seed)
+	edKey := ed25519.NewKeyFromSeed(seed)
+
+	msg := &Message{
+		From:    Address{Name: "Рассылка", Address: "news@example.org"},
+		To:      []Address{{Address: "rcpt@example.com"}},
+		Subject: "Проверка подписи с длинной темой, которая точно будет перенесена на несколько строк",
+		Parts:   []Part{TextPart("Привет!  \r\n\r\nСтрока с пробелами в конце   \r\n\r\n\r\n"), HTMLPart("<p>Привет!</p>")},
+	}
+	prepared, err := prepareMessage(msg, Address{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := buildMIMETree(prepared, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := writeMessage(&buf, prepared, root); err != nil {
+		t.Fatal(err)
+	}
+	raw := buf.Bytes()
+
+	for _, key := range []crypto.Signer{rsaKey, edKey} {
+		for _, c := range []string{"simple/simple", "relaxed/simple", "relaxed/relaxed", "simple/relaxed"} {
+			t.Run(fmt.Sprintf("%T %s", key, c), func(t *testing.T) {
+				hc, bc, err := ParseCanonicalization(c)
+				if err != nil {
+					t.Fatal(err)
+				}
+				signer, err := NewDKIMSigner(DKIMOptions{
+					Domain: "example.org", Selector: "mail", Key: key,
+					HeaderCanonicalization: hc, BodyCanonicalization: bc,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				sig, err := signer.Sign(raw)
+				if err != nil {
+					t.Fatalf("Sign: %v", err)
+				}
+				for _, line := range strings.Split(sig, "\r\n") {
+					if len(line) > 998 {
+						t.Errorf("signature line is %d characters long", len(line))
+					}
+				}
+
+				signed := append([]byte(sig), raw...)
+				if err := verifyDKIM(signed, key.Public()); err != nil {
+					t.Fatalf("signature does not verify: %v\n%s", err, sig)
+				}
+
+				tampered := bytes.Replace(signed, []byte("Message-ID: <"), []byte("Message-ID: <x"), 1)
+				if err := verifyDKIM(tampered, key.Public()); err == nil {
+					t.Errorf("tampered header still verifies")
+				}
+			})
+		}
+	}
+}
+
+func TestDKIMRelaxedSurvivesRefolding(t *testing.T) {
+	seed, _ := base64.StdEncoding.DecodeString(rfc8463Seed)
+	key := ed25519.NewKeyFromSeed(seed)
+	signer, err := NewDKIMSigner(DKIMOptions{
+		Domain: "example.org", Selector: "mail", Key: key,
+		HeaderCanonicalization: CanonicalizationRelaxed, BodyCanonicalization: CanonicalizationRelaxed,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig, err := signer.Sign([]byte(rfc8463Message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	refolded := strings.Replace(rfc8463Message, "Subject: Is dinner ready?", "subject:   Is dinner\r\n\tready?  ", 1)
+	refolded = strings.Replace(refolded, "Are you hungry", "Are \t you hungry", 1)
+	if err := verifyDKIM([]byte(sig+refolded), key.Public()); err != nil {
+		t.Errorf("relaxed signature broken by refolding: %v", err)
+	}
+}
+
+func TestParseDKIMKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	pkcs8, _ := x509.MarshalPKCS8PrivateKey(edKey)
+
+	keys := map[string][]byte{
+		"rsa pkcs1":     pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}),
+		"ed25519 pkcs8": pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}),
+	}
+	for name, data := range keys {
+		if _, err := ParseDKIMKey(data); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if _, err := ParseDKIMKey([]byte("not a key")); err == nil {
+		t.Errorf("garbage parsed as a key")
+	}
+}
+
+func TestSMTPSenderWritesDKIMSignature(t *testing.T) {
+	seed, _ := base64.StdEncoding.DecodeString(rfc8463Seed)
+	key := ed25519.NewKeyFromSeed(seed)
+	signer, err := NewDKIMSigner(DKIMOptions{Domain: "example.org", Selector: "mail", Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender, err := NewSMTPSender("smtp.example.org", "", "news@example.org", "", WithDKIM(signer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = sender.WriteMessage(&buf, &Message{
+		To:          []Address{{Address: "rcpt@example.com"}},
+		Subject:     "Отчет",
+		Parts:       []Part{TextPart("Во вложении отчет")},
+		Attachments: []Attachment{NewAttachment("report.csv", []byte("a;b\n1;2\n"))},
+	})
+	if err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+
+	if !bytes.HasPrefix(buf.Bytes(), []byte("DKIM-Signature: ")) {
+		t.Fatalf("message does not start with DKIM-Signature:\n%s", buf.Bytes())
+	}
+	if err := verifyDKIM(buf.Bytes(), key.Public()); err != nil {
+		t.Errorf("signature does not verify: %v", err)
+	}
+}
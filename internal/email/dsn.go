@@ -0,0 +1,120 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DSNNotify — события, о которых сервер должен прислать уведомление о доставке (NOTIFY, RFC 3461)
+type DSNNotify uint8
+
+const (
+	// NotifySuccess — уведомить об успешной доставке
+	NotifySuccess DSNNotify = 1 << iota
+	// NotifyFailure — уведомить о недоставке
+	NotifyFailure
+	// NotifyDelay — уведомить о задержке доставки
+	NotifyDelay
+	// NotifyNever запрещает любые уведомления, в том числе о недоставке; не сочетается с остальными
+	NotifyNever
+)
+
+// String возвращает значение параметра NOTIFY, например SUCCESS,FAILURE,DELAY
+func (n DSNNotify) String() string {
+	if n&NotifyNever != 0 {
+		return "NEVER"
+	}
+	var values []string
+	for _, v := range []struct {
+		flag DSNNotify
+		name string
+	}{{NotifySuccess, "SUCCESS"}, {NotifyFailure, "FAILURE"}, {NotifyDelay, "DELAY"}} {
+		if n&v.flag != 0 {
+			values = append(values, v.name)
+		}
+	}
+	return strings.Join(values, ",")
+}
+
+// DSNReturn — что вернуть в уведомлении о недоставке: только заголовки или письмо целиком (RET)
+type DSNReturn string
+
+const (
+	// ReturnHeaders — вернуть только заголовки письма
+	ReturnHeaders DSNReturn = "HDRS"
+	// ReturnFull — вернуть письмо целиком
+	ReturnFull DSNReturn = "FULL"
+)
+
+// maxEnvelopeIDLength — предел длины ENVID по RFC 3461
+const maxEnvelopeIDLength = 100
+
+// DSN — запрос уведомлений о доставке письма (RFC 3461). Параметры передаются, только если
+// сервер объявил расширение DSN; принял ли сервер запрос, сообщает Result.DSN.
+type DSN struct {
+	// Notify — события, о которых нужно уведомить; 0 оставляет выбор серверу (обычно только о недоставке)
+	Notify DSNNotify
+	// Return — что вернуть в уведомлении о недоставке; пустое значение оставляет выбор серверу
+	Return DSNReturn
+	// EnvelopeID — идентификатор конверта, который сервер вернет в Original-Envelope-Id;
+	// по умолчанию Message-ID письма, чтобы уведомление можно было сопоставить с письмом
+	EnvelopeID string
+}
+
+// validate проверяет, что запрос можно передать серверу
+func (d *DSN) validate() error {
+	if d.Notify&NotifyNever != 0 && d.Notify != NotifyNever {
+		return errors.New("invalid DSN request: NOTIFY=NEVER cannot be combined with other events")
+	}
+	if d.Return != "" && d.Return != ReturnHeaders && d.Return != ReturnFull {
+		return fmt.Errorf("invalid DSN request: unknown RET value %q", d.Return)
+	}
+	if len(d.EnvelopeID) > maxEnvelopeIDLength || !isASCII(d.EnvelopeID) {
+		return fmt.Errorf("invalid DSN request: ENVID must be at most %d ASCII characters", maxEnvelopeIDLength)
+	}
+	return nil
+}
+
+// mailParams возвращает параметры MAIL FROM: RET и ENVID
+func (d *DSN) mailParams(messageID string) []string {
+	var params []string
+	if d.Return != "" {
+		params = append(params, "RET="+string(d.Return))
+	}
+	envelopeID := d.EnvelopeID
+	if envelopeID == "" && len(messageID) <= maxEnvelopeIDLength {
+		envelopeID = messageID
+	}
+	if envelopeID != "" {
+		params = append(params, "ENVID="+xtext(envelopeID))
+	}
+	return params
+}
+
+// rcptParams возвращает параметры RCPT TO: NOTIFY и исходный адрес получателя ORCPT
+func (d *DSN) rcptParams(rcpt string) []string {
+	var params []string
+	if d.Notify != 0 {
+		params = append(params, "NOTIFY="+d.Notify.String())
+	}
+	// Не-ASCII адрес требует формы utf-8-addr из RFC 6533; без ORCPT сервер подставит адрес из RCPT TO
+	if isASCII(rcpt) {
+		params = append(params, "ORCPT=rfc822;"+xtext(rcpt))
+	}
+	return params
+}
+
+// xtext кодирует значение параметра ESMTP по RFC 3461: "+", "=" и непечатные символы заменяются на +XX
+func xtext(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < '!' || c > '~' || c == '+' || c == '=' {
+			fmt.Fprintf(&b, "+%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,183 @@
+package email_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mclyashko/IPORPIS/internal/email"
+	"github.com/mclyashko/IPORPIS/internal/email/emailtest"
+)
+
+func TestDSNRequest(t *testing.T) {
+	server := emailtest.NewServer(t)
+
+	msg := &email.Message{
+		To:    []email.Address{{Address: "rcpt@example.com"}},
+		Parts: []email.Part{email.TextPart("Договор во вложении")},
+		DSN:   &email.DSN{Notify: email.NotifySuccess | email.NotifyFailure | email.NotifyDelay, Return: email.ReturnHeaders},
+	}
+	result, err := server.Sender().SendMessage(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	if !result.DSN {
+		t.Error("Result.DSN = false, want true")
+	}
+
+	got := server.Messages()[0]
+	if got.Params["RET"] != "HDRS" || got.Params["ENVID"] != result.MessageID {
+		t.Errorf("MAIL FROM params = %v, want RET=HDRS ENVID=%s", got.Params, result.MessageID)
+	}
+	rcpt := got.RecipientParams["rcpt@example.com"]
+	if rcpt["NOTIFY"] != "SUCCESS,FAILURE,DELAY" || rcpt["ORCPT"] != "rfc822;rcpt@example.com" {
+		t.Errorf("RCPT TO params = %v", rcpt)
+	}
+
+	// Служебные символы ENVID кодируются в xtext
+	msg.DSN = &email.DSN{Notify: email.NotifyNever, EnvelopeID: "order+42=paid"}
+	if _, err := server.Sender().SendMessage(context.Background(), msg); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	got = server.Messages()[1]
+	if got.Params["ENVID"] != "order+2B42+3Dpaid" || got.RecipientParams["rcpt@example.com"]["NOTIFY"] != "NEVER" {
+		t.Errorf("params = %v %v", got.Params, got.RecipientParams)
+	}
+}
+
+func TestDSNNotSupported(t *testing.T) {
+	server := emailtest.NewServer(t, emailtest.WithoutExtensions("DSN"))
+
+	msg := &email.Message{
+		To:    []email.Address{{Address: "rcpt@example.com"}},
+		Parts: []email.Part{email.TextPart("body")},
+		DSN:   &email.DSN{Notify: email.NotifySuccess},
+	}
+	result, err := server.Sender().SendMessage(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	if result.DSN {
+		t.Error("Result.DSN = true for a server without DSN")
+	}
+	if got := server.Messages()[0]; got.Params["ENVID"] != "" || len(got.RecipientParams["rcpt@example.com"]) != 0 {
+		t.Errorf("DSN parameters sent to a server without DSN: %v %v", got.Params, got.RecipientParams)
+	}
+}
+
+func TestDSNInvalidRequest(t *testing.T) {
+	server := emailtest.NewServer(t)
+
+	for name, dsn := range map[string]*email.DSN{
+		"never with success": {Notify: email.NotifyNever | email.NotifySuccess},
+		"bad return":         {Return: "BODY"},
+		"long envelope id":   {EnvelopeID: strings.Repeat("x", 101)},
+	} {
+		msg := &email.Message{
+			To:    []email.Address{{Address: "rcpt@example.com"}},
+			Parts: []email.Part{email.TextPart("body")},
+			DSN:   dsn,
+		}
+		if _, err := server.Sender().SendMessage(context.Background(), msg); err == nil {
+			t.Errorf("%s: SendMessage succeeded", name)
+		}
+	}
+	if n := server.Connections(); n != 0 {
+		t.Errorf("invalid requests opened %d connections", n)
+	}
+}
+
+const deliveryReport = "From: MAILER-DAEMON@mx.example.com (Mail Delivery System)\r\n" +
+	"To: sender@example.com\r\n" +
+	"Subject: Successful Mail Delivery Report\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/report; report-type=delivery-status; boundary=\"B\"\r\n" +
+	"\r\n" +
+	"--B\r\n" +
+	"Content-Type: text/plain; charset=us-ascii\r\n" +
+	"\r\n" +
+	"This is the mail system at host mx.example.com.\r\n" +
+	"\r\n" +
+	"Your message was successfully delivered to the destination(s) listed below.\r\n" +
+	"--B\r\n" +
+	"Content-Type: message/delivery-status\r\n" +
+	"\r\n" +
+	"Reporting-MTA: dns; mx.example.com\r\n" +
+	"Original-Envelope-Id: <1700000000.abc@example.com>\r\n" +
+	"Arrival-Date: Mon, 13 Nov 2023 10:00:00 +0300\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; ivan@example.org\r\n" +
+	"Original-Recipient: rfc822;ivan+2Bcontracts@example.org\r\n" +
+	"Action: delivered\r\n" +
+	"Status: 2.0.0\r\n" +
+	"Remote-MTA: dns; mail.example.org\r\n" +
+	"Diagnostic-Code: smtp; 250 2.0.0 OK queued\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; petr@example.org\r\n" +
+	"Action: failed\r\n" +
+	"Status: 5.1.1\r\n" +
+	"Diagnostic-Code: smtp; 550 5.1.1 User unknown\r\n" +
+	"Last-Attempt-Date: Mon, 13 Nov 2023 10:00:05 +0300\r\n" +
+	"\r\n" +
+	"--B\r\n" +
+	"Content-Type: text/rfc822-headers\r\n" +
+	"\r\n" +
+	"From: sender@example.com\r\n" +
+	"Subject: =?utf-8?b?0JTQvtCz0L7QstC+0YA=?=\r\n" +
+	"Message-ID: <1700000000.original@example.com>\r\n" +
+	"\r\n" +
+	"--B--\r\n"
+
+func TestParseDeliveryReport(t *testing.T) {
+	report, err := email.ParseDeliveryReport(strings.NewReader(deliveryReport))
+	if err != nil {
+		t.Fatalf("ParseDeliveryReport: %v", err)
+	}
+
+	if report.MessageID != "<1700000000.original@example.com>" {
+		t.Errorf("MessageID = %q", report.MessageID)
+	}
+	if report.EnvelopeID != "<1700000000.abc@example.com>" || report.ReportingMTA != "mx.example.com" {
+		t.Errorf("EnvelopeID = %q, ReportingMTA = %q", report.EnvelopeID, report.ReportingMTA)
+	}
+	if want := time.Date(2023, 11, 13, 7, 0, 0, 0, time.UTC); !report.ArrivalDate.Equal(want) {
+		t.Errorf("ArrivalDate = %v", report.ArrivalDate)
+	}
+	if !strings.HasPrefix(report.Explanation, "This is the mail system") {
+		t.Errorf("Explanation = %q", report.Explanation)
+	}
+
+	if len(report.Recipients) != 2 {
+		t.Fatalf("got %d recipients, want 2", len(report.Recipients))
+	}
+	delivered, failed := report.Recipients[0], report.Recipients[1]
+	if delivered.FinalRecipient != "ivan@example.org" || delivered.OriginalRecipient != "ivan+contracts@example.org" ||
+		delivered.Action != email.ActionDelivered || delivered.Status != "2.0.0" || delivered.RemoteMTA != "mail.example.org" {
+		t.Errorf("delivered recipient = %+v", delivered)
+	}
+	if failed.Action != email.ActionFailed || failed.Status != "5.1.1" || failed.DiagnosticCode != "550 5.1.1 User unknown" || failed.LastAttempt.IsZero() {
+		t.Errorf("failed recipient = %+v", failed)
+	}
+}
+
+func TestParseDeliveryReportEnvelopeID(t *testing.T) {
+	// Без возвращенных заголовков письмо находится по ENVID, который по умолчанию равен Message-ID
+	withoutHeaders := deliveryReport[:strings.Index(deliveryReport, "--B\r\nContent-Type: text/rfc822-headers")] + "--B--\r\n"
+
+	report, err := email.ParseDeliveryReport(strings.NewReader(withoutHeaders))
+	if err != nil {
+		t.Fatalf("ParseDeliveryReport: %v", err)
+	}
+	if report.MessageID != "<1700000000.abc@example.com>" {
+		t.Errorf("MessageID = %q, want the envelope id", report.MessageID)
+	}
+}
+
+func TestParseDeliveryReportNotReport(t *testing.T) {
+	plain := "From: a@example.com\r\nContent-Type: text/plain\r\n\r\nhello\r\n"
+	if _, err := email.ParseDeliveryReport(strings.NewReader(plain)); !errors.Is(err, email.ErrNotDeliveryReport) {
+		t.Errorf("err = %v, want ErrNotDeliveryReport", err)
+	}
+}
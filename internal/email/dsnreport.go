@@ -0,0 +1,196 @@
+package email
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// ErrNotDeliveryReport возвращается ParseDeliveryReport для писем, которые не являются уведомлением о доставке
+var ErrNotDeliveryReport = errors.New("message is not a delivery status notification")
+
+// DSNAction — итог доставки получателю из поля Action (RFC 3464)
+type DSNAction string
+
+const (
+	// ActionFailed — доставить письмо не удалось
+	ActionFailed DSNAction = "failed"
+	// ActionDelayed — доставка задерживается, сервер продолжает попытки
+	ActionDelayed DSNAction = "delayed"
+	// ActionDelivered — письмо доставлено получателю
+	ActionDelivered DSNAction = "delivered"
+	// ActionRelayed — письмо передано в систему без DSN, и дальнейших уведомлений не будет
+	ActionRelayed DSNAction = "relayed"
+	// ActionExpanded — письмо доставлено на адрес рассылки или пересылки и разослано дальше
+	ActionExpanded DSNAction = "expanded"
+)
+
+// RecipientReport — состояние доставки одному получателю
+type RecipientReport struct {
+	// FinalRecipient — адрес, для которого сервер сообщает итог
+	FinalRecipient string
+	// OriginalRecipient — адрес из ORCPT, если сервер его вернул
+	OriginalRecipient string
+	Action            DSNAction
+	// Status — расширенный код статуса, например 2.0.0 или 5.1.1
+	Status string
+	// DiagnosticCode — ответ удаленного сервера, например "550 5.1.1 User unknown"
+	DiagnosticCode string
+	RemoteMTA      string
+	LastAttempt    time.Time
+}
+
+// DeliveryReport — уведомление о доставке multipart/report (RFC 3464)
+type DeliveryReport struct {
+	// MessageID — Message-ID исходного письма из возвращенных заголовков, а если их нет — из ENVID
+	MessageID string
+	// EnvelopeID — ENVID, переданный при отправке (Original-Envelope-Id)
+	EnvelopeID   string
+	ReportingMTA string
+	ArrivalDate  time.Time
+	// Explanation — пояснение для человека из первой части уведомления
+	Explanation string
+	Recipients  []RecipientReport
+}
+
+// ParseDeliveryReport разбирает уведомление о доставке multipart/report; report-type=delivery-status
+// и сопоставляет его с исходным письмом по Message-ID
+func ParseDeliveryReport(r io.Reader) (*DeliveryReport, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading delivery report: %w", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/report" || !strings.EqualFold(params["report-type"], "delivery-status") {
+		return nil, ErrNotDeliveryReport
+	}
+
+	report := &DeliveryReport{}
+	foundStatus := false
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for i := 0; ; i++ {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading delivery report: %w", err)
+		}
+
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		body := decodeTransferEncoding(part.Header.Get("Content-Transfer-Encoding"), part)
+		switch {
+		case i == 0 && (partType == "" || partType == ContentTypePlain):
+			text, err := io.ReadAll(body)
+			if err != nil {
+				return nil, fmt.Errorf("error reading delivery report: %w", err)
+			}
+			report.Explanation = strings.TrimSpace(string(text))
+		case partType == "message/delivery-status" || partType == "message/global-delivery-status":
+			if err := report.parseStatus(body); err != nil {
+				return nil, err
+			}
+			foundStatus = true
+		case partType == "message/rfc822" || partType == "text/rfc822-headers" ||
+			partType == "message/global" || partType == "message/global-headers":
+			// Возвращенное письмо или только его заголовки; в конце добавляем пустую строку на случай, если ее нет
+			header, err := textproto.NewReader(bufio.NewReader(io.MultiReader(body, strings.NewReader("\r\n\r\n")))).ReadMIMEHeader()
+			if err == nil {
+				report.MessageID = strings.TrimSpace(header.Get("Message-Id"))
+			}
+		}
+	}
+
+	if !foundStatus {
+		return nil, fmt.Errorf("%w: no message/delivery-status part", ErrNotDeliveryReport)
+	}
+	if report.MessageID == "" && strings.HasPrefix(report.EnvelopeID, "<") && strings.HasSuffix(report.EnvelopeID, ">") {
+		// При отправке ENVID по умолчанию равен Message-ID
+		report.MessageID = report.EnvelopeID
+	}
+	return report, nil
+}
+
+// parseStatus разбирает message/delivery-status: блок полей о сообщении и по блоку на каждого получателя
+func (d *DeliveryReport) parseStatus(body io.Reader) error {
+	reader := textproto.NewReader(bufio.NewReader(body))
+
+	perMessage := true
+	for {
+		fields, err := reader.ReadMIMEHeader()
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("error parsing delivery status: %w", err)
+		}
+
+		switch {
+		case len(fields) == 0:
+			// Лишние пустые строки между блоками пропускаем
+		case perMessage:
+			d.EnvelopeID = xtextDecode(fields.Get("Original-Envelope-Id"))
+			d.ReportingMTA = typedValue(fields.Get("Reporting-Mta"))
+			d.ArrivalDate, _ = mail.ParseDate(fields.Get("Arrival-Date"))
+			perMessage = false
+		default:
+			rcpt := RecipientReport{
+				FinalRecipient:    typedValue(fields.Get("Final-Recipient")),
+				OriginalRecipient: xtextDecode(typedValue(fields.Get("Original-Recipient"))),
+				Action:            DSNAction(strings.ToLower(strings.TrimSpace(fields.Get("Action")))),
+				Status:            strings.TrimSpace(fields.Get("Status")),
+				DiagnosticCode:    typedValue(fields.Get("Diagnostic-Code")),
+				RemoteMTA:         typedValue(fields.Get("Remote-Mta")),
+			}
+			rcpt.LastAttempt, _ = mail.ParseDate(fields.Get("Last-Attempt-Date"))
+			d.Recipients = append(d.Recipients, rcpt)
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// typedValue отбрасывает тип из значения вида "rfc822; user@example.com" или "smtp; 550 5.1.1 ..."
+func typedValue(value string) string {
+	if _, rest, ok := strings.Cut(value, ";"); ok {
+		return strings.TrimSpace(rest)
+	}
+	return strings.TrimSpace(value)
+}
+
+// xtextDecode раскодирует значение xtext (RFC 3461), например ORCPT или ENVID
+func xtextDecode(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '+' && i+2 < len(value) {
+			var c byte
+			if _, err := fmt.Sscanf(value[i+1:i+3], "%02X", &c); err == nil {
+				b.WriteByte(c)
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(value[i])
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// decodeTransferEncoding раскодирует тело части по Content-Transfer-Encoding
+func decodeTransferEncoding(transferEncoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(transferEncoding)) {
+	case encodingBase64:
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case encodingQuotedPrintable:
+		return quotedprintable.NewReader(body)
+	default:
+		return body
+	}
+}
@@ -0,0 +1,174 @@
+package emailtest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// Message — письмо, принятое сервером
+type Message struct {
+	// From и Recipients — адреса конверта из MAIL FROM и принятых RCPT TO
+	From       string
+	Recipients []string
+	// Params — параметры MAIL FROM в верхнем регистре, например SIZE: "1024", BODY: "8BITMIME", SMTPUTF8: ""
+	Params map[string]string
+	// RecipientParams — параметры RCPT TO по адресам получателей, например NOTIFY: "SUCCESS,FAILURE"
+	RecipientParams map[string]map[string]string
+	// Pipelined сообщает, что RCPT TO пришли вместе с MAIL FROM, не дожидаясь ответа на него
+	Pipelined bool
+	// Data — письмо в том виде, в котором оно пришло в DATA, с окончаниями строк CRLF
+	Data []byte
+	// TLS сообщает, было ли соединение зашифровано
+	TLS bool
+	// AuthUser — имя, под которым клиент прошел AUTH, или пустая строка
+	AuthUser string
+
+	// Header — заголовки письма
+	Header mail.Header
+	// Parts — листовые MIME-части в порядке следования, с уже декодированным содержимым
+	Parts []Part
+	// ParseErr — ошибка разбора письма; Data при этом все равно заполнено
+	ParseErr error
+}
+
+// Part — листовая MIME-часть письма
+type Part struct {
+	Header textproto.MIMEHeader
+	// ContentType — тип содержимого без параметров, например text/plain
+	ContentType string
+	// Filename — имя файла из Content-Disposition или Content-Type, если оно есть
+	Filename string
+	// Body — содержимое после декодирования base64 или quoted-printable
+	Body []byte
+}
+
+// Subject возвращает тему письма с раскодированными encoded-word
+func (m *Message) Subject() string {
+	return decodeHeader(m.Header.Get("Subject"))
+}
+
+// Text возвращает первую текстовую часть, не являющуюся вложением
+func (m *Message) Text() string {
+	return m.body("text/plain")
+}
+
+// HTML возвращает первую HTML-часть, не являющуюся вложением
+func (m *Message) HTML() string {
+	return m.body("text/html")
+}
+
+// Attachments возвращает вложения, включая встроенные в HTML изображения
+func (m *Message) Attachments() []Part {
+	var attachments []Part
+	for _, part := range m.Parts {
+		if part.isAttachment() {
+			attachments = append(attachments, part)
+		}
+	}
+	return attachments
+}
+
+func (m *Message) body(contentType string) string {
+	for _, part := range m.Parts {
+		if part.ContentType == contentType && !part.isAttachment() {
+			return string(part.Body)
+		}
+	}
+	return ""
+}
+
+func (p Part) isAttachment() bool {
+	disposition, _, _ := mime.ParseMediaType(p.Header.Get("Content-Disposition"))
+	return p.Filename != "" || disposition == "attachment" || p.Header.Get("Content-ID") != ""
+}
+
+// parseMessage разбирает принятое письмо; ошибка сохраняется в ParseErr
+func parseMessage(data []byte) *Message {
+	msg := &Message{Data: data}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		msg.ParseErr = err
+		return msg
+	}
+	msg.Header = parsed.Header
+
+	msg.Parts, msg.ParseErr = parseParts(textproto.MIMEHeader(parsed.Header), parsed.Body)
+	return msg
+}
+
+// parseParts рекурсивно обходит MIME-дерево и возвращает его листья
+func parseParts(header textproto.MIMEHeader, body io.Reader) ([]Part, error) {
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("parsing Content-Type %q: %w", contentType, err)
+	}
+
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		part, err := parseLeaf(header, mediaType, params, body)
+		if err != nil {
+			return nil, err
+		}
+		return []Part{part}, nil
+	}
+
+	var parts []Part
+	reader := multipart.NewReader(body, params["boundary"])
+	for {
+		child, err := reader.NextRawPart()
+		if err == io.EOF {
+			return parts, nil
+		}
+		if err != nil {
+			return parts, fmt.Errorf("reading %s: %w", mediaType, err)
+		}
+
+		children, err := parseParts(child.Header, child)
+		parts = append(parts, children...)
+		if err != nil {
+			return parts, err
+		}
+	}
+}
+
+func parseLeaf(header textproto.MIMEHeader, mediaType string, params map[string]string, body io.Reader) (Part, error) {
+	part := Part{Header: header, ContentType: mediaType, Filename: params["name"]}
+	if _, dispParams, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil && dispParams["filename"] != "" {
+		part.Filename = dispParams["filename"]
+	}
+	part.Filename = decodeHeader(part.Filename)
+
+	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return part, fmt.Errorf("decoding %s part: %w", mediaType, err)
+	}
+	part.Body = data
+	return part, nil
+}
+
+func decodeHeader(value string) string {
+	decoded, err := new(mime.WordDecoder).DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
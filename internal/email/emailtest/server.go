@@ -0,0 +1,328 @@
+// Package emailtest запускает в процессе теста поддельный SMTP-сервер,
+// который записывает принятые письма и может отвечать заданными кодами на любом этапе отправки.
+package emailtest
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mclyashko/IPORPIS/internal/email"
+)
+
+// Mode определяет, как сервер защищает соединение
+type Mode int
+
+const (
+	// ModePlain — открытое соединение без STARTTLS
+	ModePlain Mode = iota
+	// ModeStartTLS — открытое соединение с поддержкой STARTTLS
+	ModeStartTLS
+	// ModeTLS — неявный TLS сразу после подключения
+	ModeTLS
+)
+
+// Security возвращает режим защиты, которым клиент должен подключаться к серверу в этом режиме
+func (m Mode) Security() email.Security {
+	switch m {
+	case ModeStartTLS:
+		return email.SecurityStartTLS
+	case ModeTLS:
+		return email.SecurityTLS
+	default:
+		return email.SecurityNone
+	}
+}
+
+// Reply — ответ сервера на команду
+type Reply struct {
+	Code int
+	// Message — текст ответа, при необходимости с расширенным кодом, например "5.1.1 User unknown"
+	Message string
+	// Drop закрывает соединение вместо ответа. На этапе data письмо перед этим считается принятым:
+	// так выглядит обрыв связи после того, как сервер уже поставил письмо в очередь.
+	Drop bool
+}
+
+// Server — поддельный SMTP-сервер на 127.0.0.1. Безопасен для одновременного использования.
+type Server struct {
+	// Host и Port — адрес, на котором сервер принимает соединения
+	Host string
+	Port string
+
+	t        testing.TB
+	mode     Mode
+	username string
+	password string
+	// sizeLimit — предел SIZE; 0 — предел не объявляется
+	sizeLimit int64
+	// disabled — расширения, которые сервер не объявляет
+	disabled map[string]bool
+
+	listener  net.Listener
+	tlsConfig *tls.Config
+	cert      *x509.Certificate
+	certPool  *x509.CertPool
+	wg        sync.WaitGroup
+
+	mu          sync.Mutex
+	messages    []*Message
+	scripts     map[email.Phase][]Reply
+	rejected    map[string]Reply
+	conns       map[net.Conn]struct{}
+	connections int
+	closed      bool
+}
+
+// Option настраивает Server
+type Option func(*Server)
+
+// WithMode задает режим защиты соединения (по умолчанию ModePlain)
+func WithMode(mode Mode) Option {
+	return func(s *Server) {
+		s.mode = mode
+	}
+}
+
+// WithAuth включает AUTH PLAIN и LOGIN с единственной учетной записью; без нее AUTH не объявляется
+func WithAuth(username, password string) Option {
+	return func(s *Server) {
+		s.username = username
+		s.password = password
+	}
+}
+
+// WithSizeLimit объявляет SIZE с пределом limit байт: сервер отклоняет MAIL FROM с большим SIZE
+// и письма, превышающие предел, ответом 552
+func WithSizeLimit(limit int64) Option {
+	return func(s *Server) {
+		s.sizeLimit = limit
+	}
+}
+
+// WithoutExtensions отключает расширения, которые сервер объявляет по умолчанию:
+// 8BITMIME, SMTPUTF8, PIPELINING и DSN
+func WithoutExtensions(names ...string) Option {
+	return func(s *Server) {
+		for _, name := range names {
+			s.disabled[strings.ToUpper(name)] = true
+		}
+	}
+}
+
+// NewServer запускает сервер и останавливает его по окончании теста
+func NewServer(t testing.TB, opts ...Option) *Server {
+	t.Helper()
+
+	s := &Server{
+		t:        t,
+		scripts:  make(map[email.Phase][]Reply),
+		rejected: make(map[string]Reply),
+		conns:    make(map[net.Conn]struct{}),
+		disabled: make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	cert, pool, err := selfSignedCertificate()
+	if err != nil {
+		t.Fatalf("emailtest: generating certificate: %v", err)
+	}
+	s.tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	s.cert = cert.Leaf
+	s.certPool = pool
+
+	s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("emailtest: listening: %v", err)
+	}
+	s.Host, s.Port, _ = net.SplitHostPort(s.listener.Addr().String())
+
+	s.wg.Add(1)
+	go s.serve()
+
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Addr возвращает адрес сервера в виде host:port
+func (s *Server) Addr() string {
+	return net.JoinHostPort(s.Host, s.Port)
+}
+
+// ClientTLSConfig возвращает настройки TLS, при которых клиент доверяет самоподписанному сертификату сервера
+func (s *Server) ClientTLSConfig() *tls.Config {
+	return &tls.Config{RootCAs: s.certPool, ServerName: s.Host}
+}
+
+// Certificate возвращает самоподписанный сертификат сервера
+func (s *Server) Certificate() *x509.Certificate {
+	return s.cert
+}
+
+// Sender создает SMTPSender, настроенный на этот сервер: режим защиты, доверенный сертификат и учетная запись.
+// opts применяются последними и могут переопределить любую настройку.
+func (s *Server) Sender(opts ...email.Option) *email.SMTPSender {
+	s.t.Helper()
+
+	username, auth := "sender@example.com", email.NoAuth()
+	if s.username != "" {
+		username, auth = s.username, email.PasswordAuth(s.username, s.password)
+	}
+
+	base := []email.Option{
+		email.WithSecurity(s.mode.Security()),
+		email.WithAuth(auth),
+		email.WithTLSConfig(s.ClientTLSConfig()),
+		email.WithTimeout(5 * time.Second),
+	}
+	sender, err := email.NewSMTPSender(s.Host, s.Port, username, s.password, append(base, opts...)...)
+	if err != nil {
+		s.t.Fatalf("emailtest: creating sender: %v", err)
+	}
+	return sender
+}
+
+// Script ставит в очередь ответы на команды этапа phase: каждая следующая команда этапа получает
+// следующий ответ вместо обычного. Для PhaseDial ответ заменяет приветствие, и соединение закрывается,
+// для PhaseData — ответ после точки, завершающей письмо.
+func (s *Server) Script(phase email.Phase, replies ...Reply) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.scripts[phase] = append(s.scripts[phase], replies...)
+}
+
+// RejectRecipient заставляет сервер всегда отвечать reply на RCPT TO с адресом address
+func (s *Server) RejectRecipient(address string, reply Reply) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rejected[strings.ToLower(address)] = reply
+}
+
+// Messages возвращает принятые письма в порядке приема
+func (s *Server) Messages() []*Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*Message(nil), s.messages...)
+}
+
+// Connections возвращает число принятых соединений
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.connections
+}
+
+// Reset забывает принятые письма, очереди ответов и отклоняемых получателей
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages = nil
+	s.scripts = make(map[email.Phase][]Reply)
+	s.rejected = make(map[string]Reply)
+}
+
+// Close останавливает сервер и разрывает открытые соединения
+func (s *Server) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.t.Logf("emailtest: accept: %v", err)
+			}
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.connections++
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() {
+				s.mu.Lock()
+				delete(s.conns, conn)
+				s.mu.Unlock()
+			}()
+
+			newSession(s, conn).run()
+		}()
+	}
+}
+
+// supports сообщает, объявляет ли сервер расширение name
+func (s *Server) supports(name string) bool {
+	switch name {
+	case "":
+		return false
+	case "SIZE":
+		return s.sizeLimit > 0
+	default:
+		return !s.disabled[name]
+	}
+}
+
+// next извлекает очередной заданный ответ для этапа phase
+func (s *Server) next(phase email.Phase) (Reply, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queue := s.scripts[phase]
+	if len(queue) == 0 {
+		return Reply{}, false
+	}
+	s.scripts[phase] = queue[1:]
+	return queue[0], true
+}
+
+// rejection возвращает ответ для отклоняемого получателя
+func (s *Server) rejection(address string) (Reply, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reply, ok := s.rejected[strings.ToLower(address)]
+	return reply, ok
+}
+
+func (s *Server) record(msg *Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages = append(s.messages, msg)
+}
@@ -0,0 +1,439 @@
+package emailtest
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mclyashko/IPORPIS/internal/email"
+)
+
+// session обслуживает одно SMTP-соединение
+type session struct {
+	server *Server
+	conn   net.Conn
+	text   *textproto.Conn
+	tls    bool
+
+	greeted  bool
+	authUser string
+
+	// Текущая почтовая транзакция
+	from       string
+	params     map[string]string
+	pipelined  bool
+	recipients []string
+	rcptParams map[string]map[string]string
+}
+
+// paramExtensions сопоставляет параметры MAIL FROM и RCPT TO расширениям, которые их разрешают
+var paramExtensions = map[string]string{
+	"SIZE":     "SIZE",
+	"BODY":     "8BITMIME",
+	"SMTPUTF8": "SMTPUTF8",
+	"RET":      "DSN",
+	"ENVID":    "DSN",
+	"NOTIFY":   "DSN",
+	"ORCPT":    "DSN",
+}
+
+func newSession(s *Server, conn net.Conn) *session {
+	sess := &session{server: s, conn: conn, rcptParams: make(map[string]map[string]string)}
+	if s.mode == ModeTLS {
+		tlsConn := tls.Server(conn, s.tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			s.t.Logf("emailtest: TLS handshake: %v", err)
+		}
+		sess.conn = tlsConn
+		sess.tls = true
+	}
+	sess.text = textproto.NewConn(sess.conn)
+	return sess
+}
+
+func (sess *session) run() {
+	defer sess.conn.Close()
+
+	if reply, ok := sess.server.next(email.PhaseDial); ok {
+		sess.replyScripted(reply)
+		return
+	}
+	sess.reply(220, "emailtest ESMTP ready")
+
+	for {
+		line, err := sess.text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			sess.ehlo()
+		case "HELO":
+			sess.greeted = true
+			sess.reply(250, "emailtest")
+		case "STARTTLS":
+			if !sess.startTLS() {
+				return
+			}
+		case "AUTH":
+			sess.auth(arg)
+		case "MAIL":
+			sess.mail(arg)
+		case "RCPT":
+			sess.rcpt(arg)
+		case "DATA":
+			if !sess.data() {
+				return
+			}
+		case "RSET":
+			sess.reset()
+			sess.reply(250, "2.0.0 OK")
+		case "NOOP":
+			sess.reply(250, "2.0.0 OK")
+		case "QUIT":
+			sess.reply(221, "2.0.0 Bye")
+			return
+		default:
+			sess.reply(502, "5.5.2 Command not recognized")
+		}
+	}
+}
+
+func (sess *session) reply(code int, message string) {
+	sess.text.PrintfLine("%d %s", code, message)
+}
+
+func (sess *session) replyScripted(reply Reply) {
+	if reply.Drop {
+		// Следующее чтение команды завершится ошибкой, и сессия закончится
+		sess.conn.Close()
+		return
+	}
+	sess.reply(reply.Code, reply.Message)
+}
+
+func (sess *session) ehlo() {
+	sess.greeted = true
+	sess.reset()
+
+	lines := []string{"emailtest"}
+	for _, ext := range []string{"8BITMIME", "SMTPUTF8", "PIPELINING", "DSN"} {
+		if sess.server.supports(ext) {
+			lines = append(lines, ext)
+		}
+	}
+	if sess.server.supports("SIZE") {
+		lines = append(lines, fmt.Sprintf("SIZE %d", sess.server.sizeLimit))
+	}
+	if sess.server.mode == ModeStartTLS && !sess.tls {
+		lines = append(lines, "STARTTLS")
+	}
+	if sess.server.username != "" {
+		lines = append(lines, "AUTH PLAIN LOGIN")
+	}
+
+	for i, line := range lines {
+		sep := "-"
+		if i == len(lines)-1 {
+			sep = " "
+		}
+		sess.text.PrintfLine("250%s%s", sep, line)
+	}
+}
+
+// startTLS переводит соединение в TLS; false означает, что соединение нужно закрыть
+func (sess *session) startTLS() bool {
+	if sess.server.mode != ModeStartTLS || sess.tls {
+		sess.reply(502, "5.5.1 STARTTLS not available")
+		return true
+	}
+	if reply, ok := sess.server.next(email.PhaseTLS); ok {
+		sess.replyScripted(reply)
+		return true
+	}
+
+	sess.reply(220, "2.0.0 Ready to start TLS")
+	tlsConn := tls.Server(sess.conn, sess.server.tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		sess.server.t.Logf("emailtest: STARTTLS handshake: %v", err)
+		return false
+	}
+
+	// После STARTTLS клиент обязан начать сессию заново
+	sess.conn = tlsConn
+	sess.text = textproto.NewConn(tlsConn)
+	sess.tls = true
+	sess.greeted = false
+	sess.authUser = ""
+	sess.reset()
+	return true
+}
+
+func (sess *session) auth(arg string) {
+	if sess.server.username == "" {
+		sess.reply(502, "5.5.1 AUTH not available")
+		return
+	}
+	if sess.authUser != "" {
+		sess.reply(503, "5.5.1 Already authenticated")
+		return
+	}
+	if reply, ok := sess.server.next(email.PhaseAuth); ok {
+		sess.replyScripted(reply)
+		return
+	}
+
+	mechanism, initial, _ := strings.Cut(arg, " ")
+	var username, password string
+	var ok bool
+	switch strings.ToUpper(mechanism) {
+	case "PLAIN":
+		username, password, ok = sess.authPlain(initial)
+	case "LOGIN":
+		username, password, ok = sess.authLogin(initial)
+	default:
+		sess.reply(504, "5.5.4 Unrecognized authentication type")
+		return
+	}
+	if !ok {
+		sess.reply(501, "5.5.2 Malformed authentication response")
+		return
+	}
+
+	if username != sess.server.username || password != sess.server.password {
+		sess.reply(535, "5.7.8 Authentication credentials invalid")
+		return
+	}
+	sess.authUser = username
+	sess.reply(235, "2.7.0 Authentication successful")
+}
+
+func (sess *session) authPlain(initial string) (string, string, bool) {
+	if initial == "" {
+		var ok bool
+		if initial, ok = sess.challenge(""); !ok {
+			return "", "", false
+		}
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(initial)
+	if err != nil {
+		return "", "", false
+	}
+	fields := bytes.Split(decoded, []byte{0})
+	if len(fields) != 3 {
+		return "", "", false
+	}
+	return string(fields[1]), string(fields[2]), true
+}
+
+func (sess *session) authLogin(initial string) (string, string, bool) {
+	var ok bool
+	if initial == "" {
+		if initial, ok = sess.challenge("Username:"); !ok {
+			return "", "", false
+		}
+	}
+	username, err := base64.StdEncoding.DecodeString(initial)
+	if err != nil {
+		return "", "", false
+	}
+
+	encoded, ok := sess.challenge("Password:")
+	if !ok {
+		return "", "", false
+	}
+	password, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", "", false
+	}
+	return string(username), string(password), true
+}
+
+// challenge отправляет запрос 334 и читает ответ клиента; "*" означает отмену
+func (sess *session) challenge(prompt string) (string, bool) {
+	sess.reply(334, base64.StdEncoding.EncodeToString([]byte(prompt)))
+	line, err := sess.text.ReadLine()
+	if err != nil || line == "*" {
+		return "", false
+	}
+	return line, true
+}
+
+func (sess *session) mail(arg string) {
+	switch {
+	case !sess.greeted:
+		sess.reply(503, "5.5.1 Send EHLO first")
+		return
+	case sess.server.username != "" && sess.authUser == "":
+		sess.reply(530, "5.7.0 Authentication required")
+		return
+	case sess.from != "":
+		sess.reply(503, "5.5.1 Nested MAIL command")
+		return
+	}
+
+	from, params, ok := parsePath(arg, "FROM:")
+	if !ok {
+		sess.reply(501, "5.5.4 Syntax: MAIL FROM:<address>")
+		return
+	}
+	for key := range params {
+		if !sess.server.supports(paramExtensions[key]) {
+			sess.reply(555, "5.5.4 Unsupported MAIL parameter "+key)
+			return
+		}
+	}
+	if body, ok := params["BODY"]; ok && body != "8BITMIME" && body != "7BIT" {
+		sess.reply(555, "5.5.4 Unsupported BODY "+body)
+		return
+	}
+	if size, err := strconv.ParseInt(params["SIZE"], 10, 64); err == nil && sess.server.sizeLimit > 0 && size > sess.server.sizeLimit {
+		sess.reply(552, "5.3.4 Message size exceeds fixed maximum message size")
+		return
+	}
+	if _, ok := params["SMTPUTF8"]; !ok && !isASCII(from) {
+		sess.reply(553, "5.6.7 Non-ASCII address requires SMTPUTF8")
+		return
+	}
+	if reply, ok := sess.server.next(email.PhaseMail); ok {
+		sess.replyScripted(reply)
+		return
+	}
+
+	sess.from = from
+	sess.params = params
+	// Если следующая команда уже в буфере, клиент отправил ее, не дожидаясь ответа на MAIL
+	sess.pipelined = sess.text.R.Buffered() > 0
+	sess.reply(250, "2.1.0 OK")
+}
+
+func (sess *session) rcpt(arg string) {
+	if sess.from == "" {
+		sess.reply(503, "5.5.1 Need MAIL before RCPT")
+		return
+	}
+
+	rcpt, params, ok := parsePath(arg, "TO:")
+	if !ok || rcpt == "" {
+		sess.reply(501, "5.5.4 Syntax: RCPT TO:<address>")
+		return
+	}
+	for key := range params {
+		if !sess.server.supports(paramExtensions[key]) {
+			sess.reply(555, "5.5.4 Unsupported RCPT parameter "+key)
+			return
+		}
+	}
+	if _, ok := sess.params["SMTPUTF8"]; !ok && !isASCII(rcpt) {
+		sess.reply(553, "5.6.7 Non-ASCII address requires SMTPUTF8")
+		return
+	}
+	if reply, ok := sess.server.rejection(rcpt); ok {
+		sess.replyScripted(reply)
+		return
+	}
+	if reply, ok := sess.server.next(email.PhaseRcpt); ok {
+		sess.replyScripted(reply)
+		return
+	}
+
+	sess.recipients = append(sess.recipients, rcpt)
+	sess.rcptParams[rcpt] = params
+	sess.reply(250, "2.1.5 OK")
+}
+
+// data принимает тело письма; false означает, что соединение оборвалось
+func (sess *session) data() bool {
+	if len(sess.recipients) == 0 {
+		sess.reply(503, "5.5.1 No valid recipients")
+		return true
+	}
+
+	sess.reply(354, "End data with <CR><LF>.<CR><LF>")
+	data, err := sess.text.ReadDotBytes()
+	if err != nil {
+		return false
+	}
+	// DotReader заменяет CRLF на LF; возвращаем письму исходные окончания строк
+	data = bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n"))
+
+	if limit := sess.server.sizeLimit; limit > 0 && int64(len(data)) > limit {
+		sess.reset()
+		sess.reply(552, "5.3.4 Message size exceeds fixed maximum message size")
+		return true
+	}
+
+	reply, scripted := sess.server.next(email.PhaseData)
+	if scripted && !reply.Drop {
+		sess.reset()
+		sess.replyScripted(reply)
+		return true
+	}
+
+	msg := parseMessage(data)
+	msg.From = sess.from
+	msg.Recipients = sess.recipients
+	msg.Params = sess.params
+	msg.RecipientParams = sess.rcptParams
+	msg.Pipelined = sess.pipelined
+	msg.TLS = sess.tls
+	msg.AuthUser = sess.authUser
+	sess.server.record(msg)
+
+	sess.reset()
+	if scripted {
+		sess.replyScripted(reply)
+		return true
+	}
+	sess.reply(250, fmt.Sprintf("2.0.0 OK queued as %d", len(sess.server.Messages())))
+	return true
+}
+
+func (sess *session) reset() {
+	sess.from = ""
+	sess.params = nil
+	sess.pipelined = false
+	sess.recipients = nil
+	sess.rcptParams = make(map[string]map[string]string)
+}
+
+// parsePath разбирает аргумент MAIL FROM или RCPT TO и возвращает адрес без угловых скобок
+// и параметры после него, например SIZE=1024 или BODY=8BITMIME
+func parsePath(arg, prefix string) (string, map[string]string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", nil, false
+	}
+	fields := strings.Fields(arg[len(prefix):])
+	if len(fields) == 0 {
+		return "", nil, false
+	}
+	path := fields[0]
+	if !strings.HasPrefix(path, "<") || !strings.HasSuffix(path, ">") {
+		return "", nil, false
+	}
+
+	params := make(map[string]string)
+	for _, param := range fields[1:] {
+		key, value, _ := strings.Cut(param, "=")
+		params[strings.ToUpper(key)] = value
+	}
+	return path[1 : len(path)-1], params, true
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,201 @@
+package email
+
+import (
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxLineLength — рекомендуемая длина строки письма по RFC 5322 и RFC 2045
+	maxLineLength = 76
+	// maxRawLineLength — жесткий предел длины строки без CRLF по RFC 5321
+	maxRawLineLength = 998
+)
+
+// Значения Content-Transfer-Encoding
+const (
+	encoding7bit            = "7bit"
+	encoding8bit            = "8bit"
+	encodingQuotedPrintable = "quoted-printable"
+	encodingBase64          = "base64"
+)
+
+// chooseTransferEncoding подбирает кодирование тела по содержимому:
+// ASCII с короткими строками остается 7bit, текст с редкими не-ASCII символами
+// кодируется quoted-printable, а преимущественно не-ASCII текст (например, русский) — base64.
+// Если сервер объявил 8BITMIME (allow8bit), текст с короткими строками и без управляющих
+// символов передается как есть в 8bit (RFC 6152).
+func chooseTransferEncoding(body string, allow8bit bool) string {
+	nonASCII := 0
+	lineLength := 0
+	longLines := false
+	control := false
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		switch {
+		case c == '\n':
+			lineLength = 0
+			continue
+		case c == '\r' && i+1 < len(body) && body[i+1] == '\n':
+			// CR из CRLF не входит в длину строки
+			continue
+		case c == '\r':
+			// Одиночный CR без LF в 8bit недопустим
+			nonASCII++
+			control = true
+		case c >= utf8.RuneSelf:
+			nonASCII++
+		case c < ' ' && c != '\t':
+			nonASCII++
+			control = true
+		}
+		lineLength++
+		if lineLength > maxRawLineLength {
+			longLines = true
+		}
+	}
+
+	switch {
+	case nonASCII == 0 && !longLines:
+		return encoding7bit
+	case allow8bit && !longLines && !control:
+		return encoding8bit
+	case nonASCII*5 <= len(body):
+		return encodingQuotedPrintable
+	default:
+		return encodingBase64
+	}
+}
+
+// newBodyEncoder возвращает writer, кодирующий данные в указанное Content-Transfer-Encoding.
+// Close обязателен: он дописывает остаток буфера кодировщика.
+func newBodyEncoder(w io.Writer, transferEncoding string) io.WriteCloser {
+	switch transferEncoding {
+	case encodingQuotedPrintable:
+		return quotedprintable.NewWriter(w)
+	case encodingBase64:
+		return newBase64Encoder(w)
+	default:
+		return nopWriteCloser{w}
+	}
+}
+
+// base64Encoder кодирует данные в base64 и разбивает результат на строки по 76 символов
+type base64Encoder struct {
+	encoder io.WriteCloser
+	lines   *lineBreaker
+}
+
+func newBase64Encoder(w io.Writer) *base64Encoder {
+	lines := &lineBreaker{w: w}
+	return &base64Encoder{
+		encoder: base64.NewEncoder(base64.StdEncoding, lines),
+		lines:   lines,
+	}
+}
+
+func (e *base64Encoder) Write(p []byte) (int, error) {
+	return e.encoder.Write(p)
+}
+
+func (e *base64Encoder) Close() error {
+	if err := e.encoder.Close(); err != nil {
+		return err
+	}
+	return e.lines.Close()
+}
+
+// lineBreaker вставляет CRLF после каждых maxLineLength байт
+type lineBreaker struct {
+	w      io.Writer
+	column int
+}
+
+func (l *lineBreaker) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(maxLineLength-l.column, len(p))
+		if _, err := l.w.Write(p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		l.column += n
+		p = p[n:]
+
+		if l.column == maxLineLength {
+			if _, err := io.WriteString(l.w, "\r\n"); err != nil {
+				return written, err
+			}
+			l.column = 0
+		}
+	}
+	return written, nil
+}
+
+// Close завершает последнюю неполную строку
+func (l *lineBreaker) Close() error {
+	if l.column == 0 {
+		return nil
+	}
+	l.column = 0
+	_, err := io.WriteString(l.w, "\r\n")
+	return err
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// encodeHeaderValue кодирует значение заголовка в encoded-words по RFC 2047, если в нем есть не-ASCII символы
+func encodeHeaderValue(value string) string {
+	return mime.BEncoding.Encode("utf-8", value)
+}
+
+// formatHeader возвращает строку заголовка с CRLF, свернутую по пробелам до maxLineLength символов
+func formatHeader(key, value string) string {
+	line := key + ": " + value
+	if len(line) <= maxLineLength {
+		return line + "\r\n"
+	}
+
+	var b strings.Builder
+	column := 0
+	for i, word := range strings.Split(line, " ") {
+		if i > 0 {
+			// Первое слово значения всегда остается на строке с именем заголовка
+			if i > 1 && column+1+len(word) > maxLineLength {
+				b.WriteString("\r\n")
+				column = 0
+			}
+			b.WriteString(" ")
+			column++
+		}
+		b.WriteString(word)
+		column += len(word)
+	}
+	b.WriteString("\r\n")
+
+	return b.String()
+}
+
+// formatDisposition формирует Content-Disposition; не-ASCII имя файла кодируется по RFC 2231
+func formatDisposition(disposition, filename string) string {
+	return mime.FormatMediaType(disposition, map[string]string{"filename": filename})
+}
+
+// formatContentType формирует Content-Type с параметрами, кодируя не-ASCII значения по RFC 2231
+func formatContentType(mediaType string, params map[string]string) string {
+	formatted := mime.FormatMediaType(mediaType, params)
+	if formatted == "" {
+		return mediaType
+	}
+	return formatted
+}
@@ -0,0 +1,204 @@
+package email
+
+import (
+	"bytes"
+	"mime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestChooseTransferEncoding(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		allow8bit bool
+		want      string
+	}{
+		{"ASCII", "Hello,\r\nworld!\r\n", false, encoding7bit},
+		{"ASCII with 8BITMIME", "Hello,\r\nworld!\r\n", true, encoding7bit},
+		{"mostly ASCII", "Total: 100 EUR. Thank you for your order, café", false, encodingQuotedPrintable},
+		{"mostly non-ASCII", "Привет, мир!", false, encodingBase64},
+		{"non-ASCII with 8BITMIME", "Привет, мир!", true, encoding8bit},
+		{"line of 998 bytes", strings.Repeat("a", 998), false, encoding7bit},
+		{"line of 998 bytes before CRLF", strings.Repeat("a", 998) + "\r\nb", false, encoding7bit},
+		{"line of 999 bytes", strings.Repeat("a", 999), false, encodingQuotedPrintable},
+		{"line of 999 bytes with 8BITMIME", strings.Repeat("a", 999), true, encodingQuotedPrintable},
+		{"long non-ASCII line with 8BITMIME", strings.Repeat("я", 500), true, encodingBase64},
+		{"control character", "ring the bell \a now", false, encodingQuotedPrintable},
+		{"control character with 8BITMIME", "ring the bell \a now, Ж", true, encodingQuotedPrintable},
+		{"bare CR with 8BITMIME", "first line\rsecond line", true, encodingQuotedPrintable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := chooseTransferEncoding(tt.body, tt.allow8bit); got != tt.want {
+				t.Errorf("chooseTransferEncoding = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLineBreaker(t *testing.T) {
+	tests := []struct {
+		name   string
+		length int
+		want   []int
+	}{
+		{"empty", 0, nil},
+		{"short", 10, []int{10}},
+		{"exactly one line", maxLineLength, []int{maxLineLength}},
+		{"one byte over", maxLineLength + 1, []int{maxLineLength, 1}},
+		{"several lines", 3*maxLineLength + 5, []int{maxLineLength, maxLineLength, maxLineLength, 5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			lines := &lineBreaker{w: &buf}
+			// Пишем кусками разного размера, чтобы проверить перенос через границы Write
+			data := []byte(strings.Repeat("x", tt.length))
+			for len(data) > 0 {
+				n := min(7, len(data))
+				if _, err := lines.Write(data[:n]); err != nil {
+					t.Fatal(err)
+				}
+				data = data[n:]
+			}
+			if err := lines.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			out := buf.String()
+			if out != "" && !strings.HasSuffix(out, "\r\n") {
+				t.Fatalf("output does not end with CRLF: %q", out)
+			}
+			var got []int
+			for _, line := range strings.SplitAfter(out, "\r\n") {
+				if line != "" {
+					got = append(got, len(line)-len("\r\n"))
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("line lengths = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatHeader(t *testing.T) {
+	longValue := strings.TrimSpace(strings.Repeat("recipient@example.com, ", 10))
+	longWord := strings.Repeat("z", 100)
+
+	tests := []struct {
+		name  string
+		key   string
+		value string
+		lines int
+	}{
+		{"short", "Subject", "Hello", 1},
+		{"exactly 76", "Subject", strings.Repeat("a", maxLineLength-len("Subject: ")), 1},
+		{"folded", "To", longValue, 4},
+		{"long first word", "Message-ID", longWord + " tail", 2},
+		{"encoded words", "Subject", encodeHeaderValue(strings.Repeat("Длинная тема письма ", 5)), 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := formatHeader(tt.key, tt.value)
+			if !strings.HasSuffix(got, "\r\n") {
+				t.Fatalf("header does not end with CRLF: %q", got)
+			}
+
+			lines := strings.Split(strings.TrimSuffix(got, "\r\n"), "\r\n")
+			if len(lines) != tt.lines {
+				t.Errorf("header folded into %d lines, want %d:\n%s", len(lines), tt.lines, got)
+			}
+			for i, line := range lines {
+				if i > 0 && !strings.HasPrefix(line, " ") {
+					t.Errorf("continuation line %d does not start with whitespace: %q", i, line)
+				}
+				// Длиннее 76 может быть только первая строка, если первое слово значения не помещается
+				if i > 0 && len(line) > maxLineLength {
+					t.Errorf("line %d is %d bytes long: %q", i, len(line), line)
+				}
+			}
+
+			if unfolded := strings.ReplaceAll(strings.TrimSuffix(got, "\r\n"), "\r\n", ""); unfolded != tt.key+": "+tt.value {
+				t.Errorf("unfolded header = %q, want %q", unfolded, tt.key+": "+tt.value)
+			}
+		})
+	}
+}
+
+func TestEncodeHeaderValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		encoded bool
+	}{
+		{"ASCII", "Monthly report", false},
+		{"Cyrillic", "Ежемесячный отчет", true},
+		{"mixed", "Report — март", true},
+		{"long", strings.Repeat("Очень длинная тема ", 10), true},
+	}
+
+	var decoder mime.WordDecoder
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := encodeHeaderValue(tt.value)
+			if !tt.encoded {
+				if got != tt.value {
+					t.Errorf("ASCII value changed: %q", got)
+				}
+				return
+			}
+
+			if !strings.HasPrefix(got, "=?utf-8?b?") {
+				t.Errorf("value is not an encoded word: %q", got)
+			}
+			for _, word := range strings.Fields(got) {
+				if len(word) > 75 {
+					t.Errorf("encoded word is %d bytes long, RFC 2047 allows 75: %q", len(word), word)
+				}
+			}
+			decoded, err := decoder.DecodeHeader(got)
+			if err != nil {
+				t.Fatalf("DecodeHeader: %v", err)
+			}
+			if decoded != tt.value {
+				t.Errorf("decoded = %q, want %q", decoded, tt.value)
+			}
+		})
+	}
+}
+
+func TestFormatDisposition(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		want     string
+	}{
+		{"ASCII", "report.pdf", `attachment; filename=report.pdf`},
+		{"with spaces", "annual report.pdf", `attachment; filename="annual report.pdf"`},
+		{"Cyrillic", "отчет.pdf", `attachment; filename*=utf-8''%D0%BE%D1%82%D1%87%D0%B5%D1%82.pdf`},
+		{"mixed", "Résumé 2024.docx", `attachment; filename*=utf-8''R%C3%A9sum%C3%A9%202024.docx`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := formatDisposition("attachment", tt.filename)
+			if got != tt.want {
+				t.Errorf("formatDisposition = %q, want %q", got, tt.want)
+			}
+
+			disposition, params, err := mime.ParseMediaType(got)
+			if err != nil {
+				t.Fatalf("ParseMediaType: %v", err)
+			}
+			if disposition != "attachment" || params["filename"] != tt.filename {
+				t.Errorf("parsed back as %q, filename %q", disposition, params["filename"])
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"syscall"
+)
+
+// Phase — этап SMTP-сессии, на котором произошла ошибка
+type Phase string
+
+const (
+	// PhaseDial — подключение к серверу и его приветствие
+	PhaseDial Phase = "dial"
+	// PhaseTLS — TLS-рукопожатие или STARTTLS
+	PhaseTLS Phase = "tls"
+	// PhaseAuth — аутентификация командой AUTH
+	PhaseAuth Phase = "auth"
+	// PhaseMail — команда MAIL FROM
+	PhaseMail Phase = "mail"
+	// PhaseRcpt — команды RCPT TO
+	PhaseRcpt Phase = "rcpt"
+	// PhaseData — передача письма командой DATA
+	PhaseData Phase = "data"
+)
+
+// ErrDeliveryUnknown означает, что соединение оборвалось после передачи письма, но до ответа сервера:
+// сервер мог уже принять письмо, поэтому повтор грозит доставить его дважды
+var ErrDeliveryUnknown = errors.New("connection lost after the message was sent, delivery is unknown")
+
+// enhancedCodeRe выделяет расширенный код статуса (RFC 3463) в начале текста ответа
+var enhancedCodeRe = regexp.MustCompile(`^([245]\.\d{1,3}\.\d{1,3})\s*`)
+
+// SMTPError описывает ошибку SMTP-сессии: этап, код ответа сервера и расширенный код статуса
+type SMTPError struct {
+	Phase Phase
+	// Code — трехзначный код ответа сервера; 0, если ответа не было (например, оборвалось соединение)
+	Code int
+	// EnhancedCode — расширенный код статуса вида 5.1.1, если сервер его прислал
+	EnhancedCode string
+	// Message — текст ответа сервера без кодов
+	Message string
+	// Recipient — адрес получателя для ошибок этапа rcpt
+	Recipient string
+	Err       error
+}
+
+// newSMTPError оборачивает ошибку этапа phase, извлекая код ответа сервера
+func newSMTPError(phase Phase, err error) *SMTPError {
+	e := &SMTPError{Phase: phase, Err: err}
+
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		e.Code = protoErr.Code
+		e.Message = protoErr.Msg
+		if m := enhancedCodeRe.FindStringSubmatch(protoErr.Msg); m != nil {
+			e.EnhancedCode = m[1]
+			e.Message = strings.TrimPrefix(protoErr.Msg, m[0])
+		}
+	}
+
+	return e
+}
+
+func (e *SMTPError) Error() string {
+	target := ""
+	if e.Recipient != "" {
+		target = " " + e.Recipient
+	}
+	return fmt.Sprintf("smtp %s%s failed: %v", e.Phase, target, e.Err)
+}
+
+func (e *SMTPError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary сообщает, что повтор позже может быть успешным:
+// ответ 4xx, обрыв соединения или тайм-аут сервера
+func (e *SMTPError) Temporary() bool {
+	if e.Code != 0 {
+		return e.Code >= 400 && e.Code < 500
+	}
+	if errors.Is(e.Err, context.Canceled) || errors.Is(e.Err, context.DeadlineExceeded) || errors.Is(e.Err, ErrDeliveryUnknown) {
+		return false
+	}
+	return isConnectionError(e.Err)
+}
+
+// Permanent сообщает, что повтор не поможет: ответ 5xx или ошибка конфигурации.
+// Обрыв после передачи письма (ErrDeliveryUnknown) не временная и не постоянная ошибка: письмо могло дойти.
+func (e *SMTPError) Permanent() bool {
+	return !e.Temporary() && !errors.Is(e.Err, ErrDeliveryUnknown)
+}
+
+// isConnectionError распознает обрыв соединения или тайм-аут сервера. Ошибки DNS временными
+// считаются, только если так говорит резолвер: «no such host» повтором не исправить.
+func isConnectionError(err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && (opErr.Op == "dial" || opErr.Op == "read")
+}
+
+// IsTemporary сообщает, что err — временная SMTP-ошибка и отправку имеет смысл повторить
+func IsTemporary(err error) bool {
+	var smtpErr *SMTPError
+	return errors.As(err, &smtpErr) && smtpErr.Temporary()
+}
+
+// IsPermanent сообщает, что err — постоянная SMTP-ошибка (например, 550 5.1.1: ящик не существует)
+func IsPermanent(err error) bool {
+	var smtpErr *SMTPError
+	return errors.As(err, &smtpErr) && smtpErr.Permanent()
+}
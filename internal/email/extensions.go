@@ -0,0 +1,189 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// ErrMessageTooLarge возвращается, когда письмо больше предела SIZE, объявленного сервером;
+// письмо отклоняется до MAIL FROM, а не обрывается сервером посреди DATA
+var ErrMessageTooLarge = errors.New("message exceeds the SMTP server size limit")
+
+// ErrSMTPUTF8NotSupported возвращается для адреса с не-ASCII символами до @, если сервер не объявил SMTPUTF8
+var ErrSMTPUTF8NotSupported = errors.New("SMTP server does not support internationalized addresses")
+
+// extensions — расширения ESMTP из ответа сервера на EHLO, которые влияют на отправку письма
+type extensions struct {
+	// size — предел размера письма в байтах (RFC 1870); 0 — сервер предел не объявил
+	size int64
+	// eightBitMIME — сервер принимает 8-битные тела (RFC 6152)
+	eightBitMIME bool
+	// smtpUTF8 — сервер принимает адреса и заголовки в UTF-8 (RFC 6531)
+	smtpUTF8 bool
+	// pipelining — MAIL и RCPT можно отправлять пачкой, не дожидаясь ответов (RFC 2920)
+	pipelining bool
+	// dsn — сервер принимает запросы уведомлений о доставке (RFC 3461)
+	dsn bool
+}
+
+// readExtensions разбирает расширения, объявленные сервером; EHLO к этому моменту уже отправлен
+func readExtensions(client *smtp.Client) extensions {
+	var ext extensions
+	if ok, param := client.Extension("SIZE"); ok {
+		// Предел без значения или с некорректным значением считаем необъявленным
+		if size, err := strconv.ParseInt(strings.TrimSpace(param), 10, 64); err == nil && size > 0 {
+			ext.size = size
+		}
+	}
+	ext.eightBitMIME, _ = client.Extension("8BITMIME")
+	ext.smtpUTF8, _ = client.Extension("SMTPUTF8")
+	ext.pipelining, _ = client.Extension("PIPELINING")
+	ext.dsn, _ = client.Extension("DSN")
+	return ext
+}
+
+// internationalize готовит адреса письма к серверу. Если в адресах есть не-ASCII символы, а сервер
+// поддерживает SMTPUTF8, письмо уходит как есть и второе значение равно true: команде MAIL нужен
+// параметр SMTPUTF8. Иначе домены переводятся в punycode, а адрес с не-ASCII символами до @
+// передать нельзя — возвращается ErrSMTPUTF8NotSupported.
+func internationalize(msg *Message, smtpUTF8 bool) (*Message, bool, error) {
+	if !msg.hasNonASCIIAddress() {
+		return msg, false, nil
+	}
+	if smtpUTF8 {
+		return msg, true, nil
+	}
+
+	converted := *msg
+	var err error
+	if converted.ReturnPath, err = asciiAddress(msg.ReturnPath); err != nil {
+		return nil, false, err
+	}
+	if converted.From.Address, err = asciiAddress(msg.From.Address); err != nil {
+		return nil, false, err
+	}
+	if converted.Sender.Address, err = asciiAddress(msg.Sender.Address); err != nil {
+		return nil, false, err
+	}
+	for _, list := range []*[]Address{&converted.To, &converted.Cc, &converted.Bcc, &converted.ReplyTo} {
+		addrs := make([]Address, len(*list))
+		for i, addr := range *list {
+			if addr.Address, err = asciiAddress(addr.Address); err != nil {
+				return nil, false, err
+			}
+			addrs[i] = addr
+		}
+		*list = addrs
+	}
+	return &converted, false, nil
+}
+
+// hasNonASCIIAddress сообщает, есть ли не-ASCII символы в адресах конверта или заголовков.
+// Отображаемые имена не учитываются: они кодируются по RFC 2047.
+func (m *Message) hasNonASCIIAddress() bool {
+	addresses := []string{m.ReturnPath, m.From.Address, m.Sender.Address}
+	for _, list := range [][]Address{m.To, m.Cc, m.Bcc, m.ReplyTo} {
+		for _, addr := range list {
+			addresses = append(addresses, addr.Address)
+		}
+	}
+	for _, address := range addresses {
+		if !isASCII(address) {
+			return true
+		}
+	}
+	return false
+}
+
+// asciiAddress переводит домен адреса в punycode, например ivan@пример.рф → ivan@xn--e1afmkfd.xn--p1ai
+func asciiAddress(address string) (string, error) {
+	if isASCII(address) {
+		return address, nil
+	}
+
+	at := strings.LastIndex(address, "@")
+	if at < 0 || !isASCII(address[:at]) {
+		return "", fmt.Errorf("%w: %s", ErrSMTPUTF8NotSupported, address)
+	}
+	domain, err := idna.Lookup.ToASCII(address[at+1:])
+	if err != nil {
+		return "", fmt.Errorf("bad domain in address %s: %w", address, err)
+	}
+	return address[:at+1] + domain, nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// pathCommand формирует команду MAIL FROM или RCPT TO с параметрами ESMTP
+func pathCommand(verb, address string, params []string) string {
+	cmd := verb + ":<" + address + ">"
+	if len(params) > 0 {
+		cmd += " " + strings.Join(params, " ")
+	}
+	return cmd
+}
+
+// sendEnvelope отправляет команду MAIL FROM и команды RCPT TO и возвращает ответы на RCPT по порядку.
+// С PIPELINING все команды уходят одной пачкой, а ответы читаются после; без него каждая команда
+// ждет своего ответа. Отказ в MAIL или обрыв соединения возвращаются ошибкой.
+func sendEnvelope(text *textproto.Conn, pipelining bool, mail string, rcpts []string) ([]error, error) {
+	commands := append([]string{mail}, rcpts...)
+	for _, cmd := range commands {
+		// Как и net/smtp, не допускаем перевода строки внутри команды
+		if strings.ContainsAny(cmd, "\r\n") {
+			return nil, newSMTPError(PhaseMail, errors.New("smtp: A line must not contain CR or LF"))
+		}
+	}
+
+	replies := make([]error, 0, len(commands))
+	for i, cmd := range commands {
+		if _, err := text.W.WriteString(cmd + "\r\n"); err != nil {
+			return nil, newSMTPError(PhaseMail, err)
+		}
+		if pipelining && i < len(commands)-1 {
+			continue
+		}
+		if err := text.W.Flush(); err != nil {
+			return nil, newSMTPError(PhaseMail, err)
+		}
+
+		// Читаем ответы на все отправленные, но еще не прочитанные команды
+		for len(replies) <= i {
+			phase, expectCode := PhaseRcpt, 25
+			if len(replies) == 0 {
+				phase, expectCode = PhaseMail, 250
+			}
+			_, _, err := text.ReadResponse(expectCode)
+			var protoErr *textproto.Error
+			if err != nil && !errors.As(err, &protoErr) {
+				return nil, newSMTPError(phase, err)
+			}
+			replies = append(replies, err)
+		}
+
+		// Без PIPELINING после отказа в MAIL получателей не отправляем
+		if replies[0] != nil && !pipelining {
+			break
+		}
+	}
+
+	// При отказе в MAIL сервер отвечает на пакетные RCPT ошибкой 503; они уже прочитаны и не нужны
+	if replies[0] != nil {
+		return nil, newSMTPError(PhaseMail, replies[0])
+	}
+	return replies[1:], nil
+}
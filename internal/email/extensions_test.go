@@ -0,0 +1,213 @@
+package email_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mclyashko/IPORPIS/internal/email"
+	"github.com/mclyashko/IPORPIS/internal/email/emailtest"
+)
+
+func TestPipelining(t *testing.T) {
+	tests := map[string]struct {
+		opts      []emailtest.Option
+		pipelined bool
+	}{
+		"advertised":     {pipelined: true},
+		"not advertised": {opts: []emailtest.Option{emailtest.WithoutExtensions("PIPELINING")}},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			server := emailtest.NewServer(t, tt.opts...)
+			server.RejectRecipient("missing@example.com", emailtest.Reply{Code: 550, Message: "5.1.1 User unknown"})
+
+			msg := &email.Message{
+				To:    []email.Address{{Address: "a@example.com"}, {Address: "missing@example.com"}, {Address: "b@example.com"}},
+				Parts: []email.Part{email.TextPart("body")},
+			}
+			result, err := server.Sender().SendMessage(context.Background(), msg)
+			if !errors.Is(err, email.ErrRecipientsRejected) {
+				t.Fatalf("err = %v, want ErrRecipientsRejected", err)
+			}
+			if got := result.Accepted(); len(got) != 2 || got[0] != "a@example.com" || got[1] != "b@example.com" {
+				t.Errorf("Accepted() = %v", got)
+			}
+			if rejected := result.Rejected(); len(rejected) != 1 || !email.IsPermanent(rejected[0].Err) {
+				t.Errorf("Rejected() = %v", rejected)
+			}
+
+			got := server.Messages()[0]
+			if got.Pipelined != tt.pipelined {
+				t.Errorf("Pipelined = %v, want %v", got.Pipelined, tt.pipelined)
+			}
+		})
+	}
+}
+
+func TestPipeliningMailRejected(t *testing.T) {
+	server := emailtest.NewServer(t)
+	server.Script(email.PhaseMail, emailtest.Reply{Code: 451, Message: "4.3.0 Try again later"})
+	sender := server.Sender()
+
+	_, err := sender.SendMessage(context.Background(), &email.Message{
+		To:    []email.Address{{Address: "a@example.com"}, {Address: "b@example.com"}},
+		Parts: []email.Part{email.TextPart("body")},
+	})
+	var smtpErr *email.SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Phase != email.PhaseMail || !smtpErr.Temporary() {
+		t.Fatalf("err = %v, want temporary MAIL error", err)
+	}
+
+	// Ответы на пакетные RCPT прочитаны, и следующее письмо через пул уходит по тому же соединению
+	pool := email.NewPooledSender(sender, email.WithMaxConns(1))
+	defer pool.Close()
+	server.Script(email.PhaseMail, emailtest.Reply{Code: 451, Message: "4.3.0 Try again later"})
+	for range 2 {
+		_, err = pool.SendMessage(context.Background(), &email.Message{
+			To:    []email.Address{{Address: "a@example.com"}, {Address: "b@example.com"}},
+			Parts: []email.Part{email.TextPart("body")},
+		})
+	}
+	if err != nil {
+		t.Fatalf("SendMessage after rejected MAIL: %v", err)
+	}
+	if n := server.Connections(); n != 2 {
+		t.Errorf("server accepted %d connections, want 2", n)
+	}
+}
+
+func TestSizeLimit(t *testing.T) {
+	server := emailtest.NewServer(t, emailtest.WithSizeLimit(4096))
+	sender := server.Sender()
+
+	small := &email.Message{
+		To:    []email.Address{{Address: "rcpt@example.com"}},
+		Parts: []email.Part{email.TextPart("body")},
+	}
+	if _, err := sender.SendMessage(context.Background(), small); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	// Завершая DATA, клиент дописывает CRLF, если письмо им не кончается
+	got := server.Messages()[0]
+	if size, err := strconv.Atoi(got.Params["SIZE"]); err != nil || size < len(got.Data)-2 || size > len(got.Data) {
+		t.Errorf("SIZE = %q, message is %d bytes", got.Params["SIZE"], len(got.Data))
+	}
+
+	large := &email.Message{
+		To:          []email.Address{{Address: "rcpt@example.com"}},
+		Parts:       []email.Part{email.TextPart("body")},
+		Attachments: []email.Attachment{email.NewAttachment("big.bin", bytes.Repeat([]byte{0xff}, 8192))},
+	}
+	_, err := sender.SendMessage(context.Background(), large)
+	if !errors.Is(err, email.ErrMessageTooLarge) || !email.IsPermanent(err) {
+		t.Fatalf("err = %v, want permanent ErrMessageTooLarge", err)
+	}
+	if n := len(server.Messages()); n != 1 {
+		t.Errorf("server received %d messages, want 1", n)
+	}
+
+	// Размер вложения из io.Reader заранее не узнать: письмо уходит без SIZE, и его отклоняет сервер
+	large.Attachments = []email.Attachment{email.NewReaderAttachment("big.bin", bytes.NewReader(make([]byte, 8192)))}
+	_, err = sender.SendMessage(context.Background(), large)
+	var smtpErr *email.SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 552 {
+		t.Fatalf("err = %v, want 552 from server", err)
+	}
+}
+
+func TestEightBitMIME(t *testing.T) {
+	tests := map[string]struct {
+		opts     []emailtest.Option
+		encoding string
+		body     string
+	}{
+		"advertised":     {encoding: "8bit", body: "8BITMIME"},
+		"not advertised": {opts: []emailtest.Option{emailtest.WithoutExtensions("8BITMIME")}, encoding: "base64"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			server := emailtest.NewServer(t, tt.opts...)
+
+			text := "Привет!\nЭто письмо на русском языке."
+			if err := server.Sender().Send("rcpt@example.com", "Тема", text, nil); err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+
+			got := server.Messages()[0]
+			if got.Params["BODY"] != tt.body {
+				t.Errorf("BODY = %q, want %q", got.Params["BODY"], tt.body)
+			}
+			part := got.Parts[0]
+			if encoding := part.Header.Get("Content-Transfer-Encoding"); encoding != tt.encoding {
+				t.Errorf("Content-Transfer-Encoding = %q, want %q", encoding, tt.encoding)
+			}
+			if strings.TrimSuffix(got.Text(), "\r\n") != strings.ReplaceAll(text, "\n", "\r\n") {
+				t.Errorf("Text() = %q", got.Text())
+			}
+		})
+	}
+}
+
+func TestSMTPUTF8(t *testing.T) {
+	msg := func(to string) *email.Message {
+		return &email.Message{
+			To:    []email.Address{{Name: "Иван", Address: to}},
+			Parts: []email.Part{email.TextPart("body")},
+		}
+	}
+
+	t.Run("advertised", func(t *testing.T) {
+		server := emailtest.NewServer(t)
+		if _, err := server.Sender().SendMessage(context.Background(), msg("иван@пример.рф")); err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+
+		got := server.Messages()[0]
+		if _, ok := got.Params["SMTPUTF8"]; !ok {
+			t.Errorf("MAIL FROM params = %v, want SMTPUTF8", got.Params)
+		}
+		if len(got.Recipients) != 1 || got.Recipients[0] != "иван@пример.рф" {
+			t.Errorf("Recipients = %v", got.Recipients)
+		}
+		if to, err := got.Header.AddressList("To"); err != nil || to[0].Address != "иван@пример.рф" {
+			t.Errorf("To = %v (%v)", to, err)
+		}
+	})
+
+	t.Run("punycode domain", func(t *testing.T) {
+		server := emailtest.NewServer(t, emailtest.WithoutExtensions("SMTPUTF8"))
+		if _, err := server.Sender().SendMessage(context.Background(), msg("ivan@пример.рф")); err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+
+		got := server.Messages()[0]
+		if len(got.Recipients) != 1 || got.Recipients[0] != "ivan@xn--e1afmkfd.xn--p1ai" {
+			t.Errorf("Recipients = %v", got.Recipients)
+		}
+		if to := got.Header.Get("To"); !strings.Contains(to, "<ivan@xn--e1afmkfd.xn--p1ai>") {
+			t.Errorf("To = %q", to)
+		}
+	})
+
+	t.Run("not advertised", func(t *testing.T) {
+		server := emailtest.NewServer(t, emailtest.WithoutExtensions("SMTPUTF8"))
+		_, err := server.Sender().SendMessage(context.Background(), msg("иван@пример.рф"))
+		if !errors.Is(err, email.ErrSMTPUTF8NotSupported) {
+			t.Fatalf("err = %v, want ErrSMTPUTF8NotSupported", err)
+		}
+		// Ошибка локальная: сервер ничего не ответил, поэтому это не SMTPError и повторять ее незачем
+		var smtpErr *email.SMTPError
+		if errors.As(err, &smtpErr) || email.IsTemporary(err) {
+			t.Errorf("err = %#v, want a local error", err)
+		}
+		if n := len(server.Messages()); n != 0 {
+			t.Errorf("server received %d messages, want 0", n)
+		}
+	})
+}
@@ -0,0 +1,52 @@
+package email
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileSender сохраняет каждое письмо отдельным .eml-файлом в каталоге.
+// Файлы открываются любым почтовым клиентом, что удобно для проверки писем без SMTP-сервера.
+type FileSender struct {
+	localSender
+	dir string
+}
+
+// NewFileSender создает отправитель, пишущий письма в dir; каталог создается при необходимости
+func NewFileSender(dir, from string) (*FileSender, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating outbox directory: %w", err)
+	}
+
+	f := &FileSender{dir: dir}
+	f.localSender = localSender{from: from, store: f.store}
+	return f, nil
+}
+
+func (f *FileSender) store(_ *Message, _ string, _ []string, data []byte) error {
+	name, err := uniqueName()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(f.dir, name+".eml")
+
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("error writing message file: %w", err)
+	}
+	log.Println("Письмо сохранено в файл:", path)
+	return nil
+}
+
+// uniqueName возвращает имя файла, которое упорядочивается по времени и не повторяется
+func uniqueName() (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%s", time.Now().UTC().Format("20060102T150405.000000000"), hex.EncodeToString(suffix)), nil
+}
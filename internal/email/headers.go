@@ -0,0 +1,171 @@
+package email
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrInvalidHeader возвращается для заголовков с недопустимым именем или значением,
+// в том числе с переводом строки, через который можно подставить в письмо чужие заголовки
+var ErrInvalidHeader = errors.New("invalid message header")
+
+// ErrFromNotAligned возвращается, когда включен WithAlignedFrom, а From или MAIL FROM не совпадает с логином аккаунта
+var ErrFromNotAligned = errors.New("sender address does not match the SMTP account")
+
+// reservedHeaders формируются из полей Message и не могут задаваться через Headers
+var reservedHeaders = map[string]bool{
+	"From":                      true,
+	"To":                        true,
+	"Cc":                        true,
+	"Bcc":                       true,
+	"Sender":                    true,
+	"Reply-To":                  true,
+	"Subject":                   true,
+	"Date":                      true,
+	"Return-Path":               true,
+	"Message-Id":                true,
+	"Mime-Version":              true,
+	"Content-Type":              true,
+	"Content-Transfer-Encoding": true,
+	"Content-Disposition":       true,
+}
+
+// unsubscribeHeaders формируются из Message.Unsubscribe, если он задан; без него их можно задать через Headers
+var unsubscribeHeaders = map[string]bool{
+	"List-Unsubscribe":      true,
+	"List-Unsubscribe-Post": true,
+}
+
+// GenerateMessageID создает уникальный Message-ID вида <случайная часть@domain>
+func GenerateMessageID(domain string) string {
+	if domain == "" {
+		domain = localDomain()
+	}
+
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().Unix(), hex.EncodeToString(b[:]), domain)
+}
+
+// prepareMessage проверяет заголовки письма и возвращает его копию с заполненными From, Date и Message-ID.
+// Исходное письмо не изменяется, поэтому одно и то же письмо можно безопасно отправлять повторно.
+func prepareMessage(msg *Message, defaultFrom Address) (*Message, error) {
+	prepared := *msg
+
+	if prepared.From.Address == "" {
+		prepared.From.Address = defaultFrom.Address
+		if prepared.From.Name == "" {
+			prepared.From.Name = defaultFrom.Name
+		}
+	}
+	if prepared.Date.IsZero() {
+		prepared.Date = time.Now()
+	}
+	if prepared.MessageID == "" {
+		prepared.MessageID = GenerateMessageID(addressDomain(prepared.From.Address))
+	}
+
+	if err := validateHeaders(&prepared); err != nil {
+		return nil, err
+	}
+	return &prepared, nil
+}
+
+// validateHeaders отклоняет переводы строк в заголовках и попытки переопределить служебные заголовки через Headers
+func validateHeaders(msg *Message) error {
+	if err := checkHeaderValue("Subject", msg.Subject); err != nil {
+		return err
+	}
+	if err := checkHeaderValue("Message-ID", msg.MessageID); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(msg.MessageID, "<") || !strings.HasSuffix(msg.MessageID, ">") || !strings.Contains(msg.MessageID, "@") {
+		return fmt.Errorf("%w: Message-ID %q must look like <id@domain>", ErrInvalidHeader, msg.MessageID)
+	}
+
+	// Адрес конверта уходит в команду MAIL FROM как есть, поэтому в нем не должно быть ничего, кроме адреса
+	if strings.ContainsAny(msg.ReturnPath, "\r\n<> ") {
+		return fmt.Errorf("%w: bad return path %q", ErrInvalidHeader, msg.ReturnPath)
+	}
+
+	if msg.DSN != nil {
+		if err := msg.DSN.validate(); err != nil {
+			return err
+		}
+	}
+
+	if msg.Unsubscribe != nil {
+		if err := msg.Unsubscribe.validate(); err != nil {
+			return err
+		}
+	}
+
+	for key, list := range map[string][]Address{
+		"From": {msg.From}, "Sender": {msg.Sender}, "To": msg.To, "Cc": msg.Cc, "Bcc": msg.Bcc, "Reply-To": msg.ReplyTo,
+	} {
+		for _, addr := range list {
+			if err := checkHeaderValue(key, addr.Name+addr.Address); err != nil {
+				return err
+			}
+		}
+	}
+
+	for key, value := range msg.Headers {
+		if !validHeaderKey(key) {
+			return fmt.Errorf("%w: bad header name %q", ErrInvalidHeader, key)
+		}
+		if reservedHeaders[textproto.CanonicalMIMEHeaderKey(key)] {
+			return fmt.Errorf("%w: %s is set by the message builder", ErrInvalidHeader, key)
+		}
+		if msg.Unsubscribe != nil && unsubscribeHeaders[textproto.CanonicalMIMEHeaderKey(key)] {
+			return fmt.Errorf("%w: %s is set from Message.Unsubscribe", ErrInvalidHeader, key)
+		}
+		if err := checkHeaderValue(key, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func checkHeaderValue(key, value string) error {
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("%w: %s contains a line break", ErrInvalidHeader, key)
+	}
+	return nil
+}
+
+// validHeaderKey проверяет имя заголовка по RFC 5322: печатные символы ASCII без двоеточия
+func validHeaderKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, c := range key {
+		if c < '!' || c > '~' || c == ':' {
+			return false
+		}
+	}
+	return true
+}
+
+// addressDomain возвращает домен почтового адреса
+func addressDomain(address string) string {
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return ""
+	}
+	return address[at+1:]
+}
+
+func localDomain() string {
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		return hostname
+	}
+	return "localhost"
+}
@@ -0,0 +1,232 @@
+package email_test
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mclyashko/IPORPIS/internal/email"
+	"github.com/mclyashko/IPORPIS/internal/email/emailtest"
+)
+
+func TestStandardHeaders(t *testing.T) {
+	server := emailtest.NewServer(t)
+
+	msg := &email.Message{
+		From:    email.Address{Name: "Рассылка", Address: "news@mail.example.org"},
+		To:      []email.Address{{Address: "rcpt@example.com"}},
+		Subject: "subject",
+		Headers: map[string]string{
+			"X-Campaign-ID": "spring-2025",
+			"references":    "<a@example.org> <b@example.org>",
+		},
+		Parts: []email.Part{email.TextPart("body")},
+	}
+	result, err := server.Sender().SendMessage(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	header := server.Messages()[0].Header
+	if got := header.Get("Message-ID"); got == "" || got != result.MessageID {
+		t.Errorf("Message-ID header %q, result %q", got, result.MessageID)
+	}
+	if !strings.HasSuffix(result.MessageID, "@mail.example.org>") {
+		t.Errorf("Message-ID %q does not use the sender's domain", result.MessageID)
+	}
+	date, err := header.Date()
+	if err != nil || time.Since(date) > time.Minute {
+		t.Errorf("Date header %q: %v", header.Get("Date"), err)
+	}
+	if got := header.Get("X-Campaign-Id"); got != "spring-2025" {
+		t.Errorf("X-Campaign-ID = %q", got)
+	}
+	if got := header.Get("References"); got != "<a@example.org> <b@example.org>" {
+		t.Errorf("References = %q", got)
+	}
+	if msg.MessageID != "" || !msg.Date.IsZero() {
+		t.Errorf("sending modified the caller's message")
+	}
+}
+
+func TestExplicitMessageIDAndDate(t *testing.T) {
+	sender := email.NewMemorySender("sender@example.com")
+	date := time.Date(2024, 3, 8, 10, 0, 0, 0, time.UTC)
+
+	result, err := sender.SendMessage(context.Background(), &email.Message{
+		To:        []email.Address{{Address: "rcpt@example.com"}},
+		Date:      date,
+		MessageID: "<fixed@example.com>",
+		Parts:     []email.Part{email.TextPart("body")},
+	})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	if result.MessageID != "<fixed@example.com>" {
+		t.Errorf("MessageID = %q", result.MessageID)
+	}
+
+	sent, _ := sender.Last()
+	parsed, err := sent.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := parsed.Header.Date(); !got.Equal(date) {
+		t.Errorf("Date = %v, want %v", got, date)
+	}
+}
+
+func TestHeaderInjectionRejected(t *testing.T) {
+	tests := map[string]*email.Message{
+		"header value":    {Headers: map[string]string{"X-Campaign-ID": "x\r\nBcc: victim@example.com"}},
+		"header name":     {Headers: map[string]string{"X Campaign": "x"}},
+		"reserved":        {Headers: map[string]string{"bcc": "victim@example.com"}},
+		"subject":         {Subject: "hello\nBcc: victim@example.com"},
+		"address":         {Cc: []email.Address{{Address: "a@example.com\r\nBcc: victim@example.com"}}},
+		"message id":      {MessageID: "<id@example.com>\r\nX-Evil: 1"},
+		"bad message id":  {MessageID: "no-brackets"},
+		"sender":          {Sender: email.Address{Name: "x\r\nBcc: victim@example.com", Address: "a@example.com"}},
+		"return path":     {ReturnPath: "a@example.com> NOTIFY=NEVER"},
+		"reserved sender": {Headers: map[string]string{"Return-Path": "a@example.com"}},
+	}
+
+	server := emailtest.NewServer(t)
+	for name, msg := range tests {
+		msg.To = append(msg.To, email.Address{Address: "rcpt@example.com"})
+		msg.Parts = []email.Part{email.TextPart("body")}
+
+		_, err := server.Sender().SendMessage(context.Background(), msg)
+		if !errors.Is(err, email.ErrInvalidHeader) {
+			t.Errorf("%s: err = %v, want ErrInvalidHeader", name, err)
+		}
+	}
+	if n := server.Connections(); n != 0 {
+		t.Errorf("invalid messages opened %d connections", n)
+	}
+}
+
+func TestSenderIdentity(t *testing.T) {
+	server := emailtest.NewServer(t, emailtest.WithAuth("robot@example.com", "secret"))
+	sender := server.Sender(
+		email.WithFrom(email.Address{Name: "Отдел продаж", Address: "sales@example.com"}),
+		email.WithSenderHeader(email.Address{Address: "robot@example.com"}),
+		email.WithReturnPath("bounces@example.com"),
+	)
+
+	if err := sender.Send("rcpt@example.com", "subject", "body", nil); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	got := server.Messages()[0]
+	if got.From != "bounces@example.com" {
+		t.Errorf("envelope From = %q, want bounces@example.com", got.From)
+	}
+	from, err := got.Header.AddressList("From")
+	if err != nil || len(from) != 1 || from[0].Name != "Отдел продаж" || from[0].Address != "sales@example.com" {
+		t.Errorf("From = %v (%v)", from, err)
+	}
+	if got := got.Header.Get("Sender"); got != "<robot@example.com>" {
+		t.Errorf("Sender = %q", got)
+	}
+
+	// Адреса из письма важнее настроек отправителя
+	err = sender.SendContext(context.Background(), "rcpt@example.com", "subject", "body", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = sender.SendMessage(context.Background(), &email.Message{
+		From:       email.Address{Name: "Иван"},
+		ReturnPath: "ivan-bounces@example.com",
+		To:         []email.Address{{Address: "rcpt@example.com"}},
+		Parts:      []email.Part{email.TextPart("body")},
+	})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	last := server.Messages()[2]
+	from, _ = last.Header.AddressList("From")
+	if last.From != "ivan-bounces@example.com" || len(from) != 1 || from[0].Name != "Иван" || from[0].Address != "sales@example.com" {
+		t.Errorf("envelope %q, From %v", last.From, from)
+	}
+}
+
+func TestAlignedFrom(t *testing.T) {
+	server := emailtest.NewServer(t, emailtest.WithAuth("robot@example.com", "secret"))
+
+	tests := []struct {
+		name    string
+		opts    []email.Option
+		msg     email.Message
+		aligned bool
+	}{
+		{name: "account", aligned: true},
+		{name: "display name only", opts: []email.Option{email.WithFrom(email.Address{Name: "Робот"})}, aligned: true},
+		{name: "case", msg: email.Message{From: email.Address{Address: "Robot@Example.com"}}, aligned: true},
+		{name: "other From", opts: []email.Option{email.WithFrom(email.Address{Address: "sales@example.com"})}},
+		{name: "other return path", msg: email.Message{ReturnPath: "bounces@example.com"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server.Reset()
+			sender := server.Sender(append(tt.opts, email.WithAlignedFrom(true))...)
+
+			msg := tt.msg
+			msg.To = []email.Address{{Address: "rcpt@example.com"}}
+			msg.Parts = []email.Part{email.TextPart("body")}
+			_, err := sender.SendMessage(context.Background(), &msg)
+
+			if tt.aligned && err != nil {
+				t.Fatalf("SendMessage: %v", err)
+			}
+			if !tt.aligned && !errors.Is(err, email.ErrFromNotAligned) {
+				t.Fatalf("err = %v, want ErrFromNotAligned", err)
+			}
+		})
+	}
+}
+
+// flakySender сохраняет письмо, но первые failures раз сообщает о временной ошибке
+type flakySender struct {
+	*email.MemorySender
+	failures int
+}
+
+func (f *flakySender) SendMessage(ctx context.Context, msg *email.Message) (*email.Result, error) {
+	result, err := f.MemorySender.SendMessage(ctx, msg)
+	if err == nil && f.failures > 0 {
+		f.failures--
+		return result, &email.SMTPError{Phase: email.PhaseData, Code: 451, Message: "try again"}
+	}
+	return result, err
+}
+
+func TestRetryKeepsMessageID(t *testing.T) {
+	flaky := &flakySender{MemorySender: email.NewMemorySender("sender@example.com"), failures: 2}
+	sender := email.NewRetryingSender(flaky, email.WithBackoff(time.Millisecond, time.Millisecond))
+
+	result, err := sender.SendMessage(context.Background(), &email.Message{
+		To:    []email.Address{{Address: "rcpt@example.com"}},
+		Parts: []email.Part{email.TextPart("body")},
+	})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	sent := flaky.Messages()
+	if len(sent) != 3 {
+		t.Fatalf("got %d attempts, want 3", len(sent))
+	}
+	for i, s := range sent {
+		parsed, err := mail.ReadMessage(strings.NewReader(string(s.Data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := parsed.Header.Get("Message-ID"); got != result.MessageID {
+			t.Errorf("attempt %d Message-ID = %q, want %q", i+1, got, result.MessageID)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package email
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// htmlToText строит текстовую версию HTML-письма: заголовки выделяются,
+// списки превращаются в строки с маркерами, а ссылки выносятся в сноски в конце текста
+func htmlToText(source string) (string, error) {
+	doc, err := html.Parse(strings.NewReader(source))
+	if err != nil {
+		return "", fmt.Errorf("error parsing HTML body: %w", err)
+	}
+
+	c := &textConverter{}
+	c.walk(doc)
+
+	text := c.result()
+	if len(c.links) > 0 {
+		var b strings.Builder
+		b.WriteString(text)
+		b.WriteString("\n\n")
+		for i, link := range c.links {
+			fmt.Fprintf(&b, "[%d] %s\n", i+1, link)
+		}
+		text = strings.TrimRight(b.String(), "\n")
+	}
+
+	return text, nil
+}
+
+// textConverter накапливает текст при обходе HTML-дерева
+type textConverter struct {
+	b bytes.Buffer
+	// lineLen — длина текущей (последней) строки в b, чтобы не просматривать весь буфер
+	lineLen int
+	links   []string
+	// lists — стек открытых списков: для нумерованных хранится номер следующего пункта, для маркированных -1
+	lists []int
+	pre   int
+}
+
+func (c *textConverter) walk(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		c.text(n.Data)
+		return
+	case html.ElementNode:
+	default:
+		c.children(n)
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.Script, atom.Style, atom.Head, atom.Title:
+		return
+	case atom.Br:
+		c.trimTrailingSpaces()
+		c.write("\n")
+	case atom.Hr:
+		c.block()
+		c.write(strings.Repeat("-", 40))
+		c.block()
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		c.block()
+		start := c.b.Len()
+		c.children(n)
+		heading := strings.TrimSpace(string(c.b.Bytes()[start:]))
+		c.truncate(start)
+		c.write(strings.ToUpper(heading))
+		if n.DataAtom == atom.H1 || n.DataAtom == atom.H2 {
+			underline := "="
+			if n.DataAtom == atom.H2 {
+				underline = "-"
+			}
+			c.write("\n" + strings.Repeat(underline, len([]rune(heading))))
+		}
+		c.block()
+	case atom.Ul, atom.Ol:
+		c.line()
+		next := -1
+		if n.DataAtom == atom.Ol {
+			next = 1
+		}
+		c.lists = append(c.lists, next)
+		c.children(n)
+		c.lists = c.lists[:len(c.lists)-1]
+		c.line()
+		if len(c.lists) == 0 {
+			c.block()
+		}
+	case atom.Li:
+		c.line()
+		depth := len(c.lists)
+		if depth > 0 {
+			c.write(strings.Repeat("  ", depth-1))
+			if c.lists[depth-1] > 0 {
+				c.write(fmt.Sprintf("%d. ", c.lists[depth-1]))
+				c.lists[depth-1]++
+			} else {
+				c.write("- ")
+			}
+		}
+		c.children(n)
+		c.line()
+	case atom.A:
+		c.children(n)
+		href := attr(n, "href")
+		if href != "" && !strings.HasPrefix(href, "#") && !strings.HasPrefix(href, "javascript:") {
+			c.links = append(c.links, strings.TrimPrefix(href, "mailto:"))
+			c.write(fmt.Sprintf(" [%d]", len(c.links)))
+		}
+	case atom.Img:
+		if alt := attr(n, "alt"); alt != "" {
+			c.text(alt)
+		}
+	case atom.Pre:
+		c.block()
+		c.pre++
+		c.children(n)
+		c.pre--
+		c.block()
+	case atom.P, atom.Div, atom.Blockquote, atom.Table, atom.Section, atom.Article, atom.Header, atom.Footer:
+		c.block()
+		c.children(n)
+		c.block()
+	case atom.Tr:
+		c.line()
+		c.children(n)
+		c.line()
+	case atom.Td, atom.Th:
+		c.children(n)
+		c.write(" ")
+	default:
+		c.children(n)
+	}
+}
+
+func (c *textConverter) children(n *html.Node) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		c.walk(child)
+	}
+}
+
+// text добавляет текст, схлопывая пробельные символы вне <pre>
+func (c *textConverter) text(s string) {
+	if c.pre > 0 {
+		c.write(s)
+		return
+	}
+
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		if s != "" && !c.atLineStart() && !bytes.HasSuffix(c.b.Bytes(), []byte(" ")) {
+			c.write(" ")
+		}
+		return
+	}
+
+	if (s[0] == ' ' || s[0] == '\n' || s[0] == '\t') && !c.atLineStart() && !bytes.HasSuffix(c.b.Bytes(), []byte(" ")) {
+		c.write(" ")
+	}
+	c.write(strings.Join(fields, " "))
+	last := s[len(s)-1]
+	if last == ' ' || last == '\n' || last == '\t' {
+		c.write(" ")
+	}
+}
+
+// line начинает новую строку, если текущая не пуста
+func (c *textConverter) line() {
+	c.trimTrailingSpaces()
+	if !c.atLineStart() {
+		c.write("\n")
+	}
+}
+
+// block отделяет блочный элемент пустой строкой
+func (c *textConverter) block() {
+	c.line()
+	if c.b.Len() > 0 && !bytes.HasSuffix(c.b.Bytes(), []byte("\n\n")) {
+		c.write("\n")
+	}
+}
+
+// write добавляет s в буфер и обновляет длину текущей строки
+func (c *textConverter) write(s string) {
+	c.b.WriteString(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		c.lineLen = len(s) - i - 1
+	} else {
+		c.lineLen += len(s)
+	}
+}
+
+func (c *textConverter) atLineStart() bool {
+	return c.lineLen == 0
+}
+
+// trimTrailingSpaces удаляет пробелы в конце текущей строки
+func (c *textConverter) trimTrailingSpaces() {
+	data := c.b.Bytes()
+	n := len(data)
+	for n > len(data)-c.lineLen && data[n-1] == ' ' {
+		n--
+	}
+	c.b.Truncate(n)
+	c.lineLen -= len(data) - n
+}
+
+// truncate оставляет в буфере первые n байт
+func (c *textConverter) truncate(n int) {
+	c.b.Truncate(n)
+	c.lineLen = n - (bytes.LastIndexByte(c.b.Bytes(), '\n') + 1)
+}
+
+func (c *textConverter) result() string {
+	return strings.TrimSpace(c.b.String())
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,81 @@
+package email
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHTMLToText(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{
+			name: "links",
+			html: `<p>See <a href="https://example.com/a">our site</a> and <a href="mailto:help@example.com">write</a>, <a href="#top">top</a>.</p>`,
+			want: "See our site [1] and write [2], top.\n\n[1] https://example.com/a\n[2] help@example.com",
+		},
+		{
+			name: "javascript link",
+			html: `<a href="javascript:void(0)">click</a>`,
+			want: "click",
+		},
+		{
+			name: "nested lists",
+			html: `<ul><li>one</li><li>two<ol><li>a</li><li>b</li></ol></li></ul><p>after</p>`,
+			want: "- one\n- two\n  1. a\n  2. b\n\nafter",
+		},
+		{
+			name: "whitespace collapsing",
+			html: "<p>  many    spaces\n\tand   newlines  </p><p>second</p>text <b>bold</b>  <i>it</i>",
+			want: "many spaces and newlines\n\nsecond\n\ntext bold it",
+		},
+		{
+			name: "whitespace before line break",
+			html: "<div>trailing   <br>next</div>",
+			want: "trailing\nnext",
+		},
+		{
+			name: "preformatted",
+			html: "<p>before</p><pre>keep\n    this   spacing</pre>",
+			want: "before\n\nkeep\n    this   spacing",
+		},
+		{
+			name: "headings",
+			html: "<h1>Title</h1><h2>Sub</h2><h3>Small</h3><p>x<br>y</p>",
+			want: "TITLE\n=====\n\nSUB\n---\n\nSMALL\n\nx\ny",
+		},
+		{
+			name: "table, image and script",
+			html: `<table><tr><td>a</td><td>b</td></tr><tr><td>c</td><td>d</td></tr></table><img alt="Logo"><script>x</script>`,
+			want: "a b\nc d\n\nLogo",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := htmlToText(tt.html)
+			if err != nil {
+				t.Fatalf("htmlToText: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("htmlToText =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTMLToTextLargeDocument(t *testing.T) {
+	const items = 50000
+	got, err := htmlToText("<ul>" + strings.Repeat("<li>item  </li>", items) + "</ul>")
+	if err != nil {
+		t.Fatalf("htmlToText: %v", err)
+	}
+	if lines := strings.Count(got, "\n") + 1; lines != items {
+		t.Errorf("got %d lines, want %d", lines, items)
+	}
+	if strings.Contains(got, " \n") {
+		t.Error("trailing spaces left at line ends")
+	}
+}
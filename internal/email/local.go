@@ -0,0 +1,123 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Transport определяет, куда отправитель доставляет письма
+type Transport string
+
+const (
+	// TransportSMTP — настоящая отправка через SMTP-сервер
+	TransportSMTP Transport = "smtp"
+	// TransportFile — каждое письмо сохраняется отдельным .eml-файлом в каталоге
+	TransportFile Transport = "file"
+	// TransportMbox — письма дописываются в один mbox-файл
+	TransportMbox Transport = "mbox"
+	// TransportMaildir — письма доставляются в каталог Maildir
+	TransportMaildir Transport = "maildir"
+	// TransportMemory — письма хранятся в памяти процесса
+	TransportMemory Transport = "memory"
+)
+
+// ParseTransport разбирает название транспорта; пустая строка означает SMTP
+func ParseTransport(s string) (Transport, error) {
+	switch t := Transport(strings.ToLower(strings.TrimSpace(s))); t {
+	case "":
+		return TransportSMTP, nil
+	case TransportSMTP, TransportFile, TransportMbox, TransportMaildir, TransportMemory:
+		return t, nil
+	default:
+		return "", fmt.Errorf("unknown email transport %q", s)
+	}
+}
+
+// NewLocalSender создает Sender для транспорта, которому не нужен SMTP-сервер.
+// path — каталог или файл назначения; если он пуст, используется путь по умолчанию для транспорта.
+// from подставляется в письма без отправителя.
+func NewLocalSender(transport Transport, path, from string) (Sender, error) {
+	switch transport {
+	case TransportFile:
+		return NewFileSender(defaultPath(path, "outbox"), from)
+	case TransportMbox:
+		return NewMboxSender(defaultPath(path, "outbox.mbox"), from)
+	case TransportMaildir:
+		return NewMaildirSender(defaultPath(path, "Maildir"), from)
+	case TransportMemory:
+		return NewMemorySender(from), nil
+	default:
+		return nil, fmt.Errorf("email transport %q requires an SMTP server", transport)
+	}
+}
+
+func defaultPath(path, fallback string) string {
+	if path == "" {
+		return fallback
+	}
+	return path
+}
+
+// localSender — общая часть отправителей, которые сохраняют письмо локально вместо SMTP-доставки
+type localSender struct {
+	from string
+	// store сохраняет готовое письмо в формате RFC 5322 с окончаниями строк CRLF
+	store func(msg *Message, envelopeFrom string, recipients []string, data []byte) error
+}
+
+// Send отправляет электронное письмо одному получателю
+func (l *localSender) Send(to, subject, body string, attachmentFilePaths []string) error {
+	return l.SendContext(context.Background(), to, subject, body, attachmentFilePaths)
+}
+
+// SendContext отправляет электронное письмо одному получателю с учетом отмены ctx
+func (l *localSender) SendContext(ctx context.Context, to, subject, body string, attachmentFilePaths []string) error {
+	_, err := l.SendMessage(ctx, newSimpleMessage(to, subject, body, attachmentFilePaths))
+	return err
+}
+
+// SendMessage собирает письмо и сохраняет его; все получатели считаются принятыми
+func (l *localSender) SendMessage(ctx context.Context, msg *Message) (*Result, error) {
+	recipients := msg.Recipients()
+	if len(recipients) == 0 {
+		return nil, ErrNoRecipients
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	prepared, err := prepareMessage(msg, Address{Address: l.from})
+	if err != nil {
+		return nil, err
+	}
+	root, err := buildMIMETree(prepared, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := writeMessage(&buf, prepared, root); err != nil {
+		return nil, err
+	}
+
+	envelopeFrom := prepared.ReturnPath
+	if envelopeFrom == "" {
+		envelopeFrom = prepared.From.Address
+	}
+	if err := l.store(prepared, envelopeFrom, recipients, buf.Bytes()); err != nil {
+		return nil, err
+	}
+
+	result := &Result{MessageID: prepared.MessageID, Attempts: 1}
+	for _, rcpt := range recipients {
+		result.Recipients = append(result.Recipients, RecipientStatus{Address: rcpt})
+	}
+	return result, nil
+}
+
+// toLF заменяет окончания строк CRLF на LF, принятые в mbox и Maildir
+func toLF(data []byte) []byte {
+	return bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+}
@@ -0,0 +1,239 @@
+package email_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mclyashko/IPORPIS/internal/email"
+)
+
+func TestFileSenderWritesEML(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+	sender, err := email.NewFileSender(dir, "robot@example.com")
+	if err != nil {
+		t.Fatalf("NewFileSender: %v", err)
+	}
+
+	for _, subject := range []string{"Первое", "Второе"} {
+		if err := sender.Send("rcpt@example.com", subject, "Привет!", nil); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("outbox contains %d .eml files, want 2: %v", len(files), files)
+	}
+
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte("\r\n")) || bytes.Contains(bytes.ReplaceAll(data, []byte("\r\n"), nil), []byte("\n")) {
+		t.Error(".eml file does not use CRLF line endings throughout")
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("parsing .eml file: %v", err)
+	}
+	if got := msg.Header.Get("From"); got != "<robot@example.com>" {
+		t.Errorf("From = %q, want <robot@example.com>", got)
+	}
+	if got := msg.Header.Get("To"); got != "<rcpt@example.com>" {
+		t.Errorf("To = %q, want <rcpt@example.com>", got)
+	}
+	if msg.Header.Get("Message-ID") == "" || msg.Header.Get("Date") == "" {
+		t.Error("Message-ID or Date header missing")
+	}
+}
+
+func TestMboxSenderEscapesFromLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.mbox")
+	sender, err := email.NewMboxSender(path, "robot@example.com")
+	if err != nil {
+		t.Fatalf("NewMboxSender: %v", err)
+	}
+
+	bodies := []string{
+		"From here on\n>From quoted\n>>From twice\nFromage\n From indented",
+		"Second message\nFrom the end",
+	}
+	for _, body := range bodies {
+		if err := sender.Send("rcpt@example.com", "Тема", body, nil); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("\r\n")) {
+		t.Error("mbox file contains CRLF line endings")
+	}
+	for _, want := range []string{"\n>From here on\n", "\n>>From quoted\n", "\n>>>From twice\n", "\nFromage\n", "\n From indented", "\n>From the end\n"} {
+		if !bytes.Contains(data, []byte(want)) {
+			t.Errorf("mbox file does not contain %q:\n%s", want, data)
+		}
+	}
+
+	messages := readMboxrd(t, data)
+	if len(messages) != len(bodies) {
+		t.Fatalf("mbox contains %d messages, want %d", len(messages), len(bodies))
+	}
+	for i, raw := range messages {
+		if !strings.HasPrefix(raw.separator, "From robot@example.com ") {
+			t.Errorf("message %d separator = %q", i, raw.separator)
+		}
+		msg, err := mail.ReadMessage(strings.NewReader(raw.data))
+		if err != nil {
+			t.Fatalf("parsing message %d: %v", i, err)
+		}
+		body, err := io.ReadAll(msg.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimRight(string(body), "\n"); got != bodies[i] {
+			t.Errorf("message %d body after unescaping = %q, want %q", i, got, bodies[i])
+		}
+	}
+}
+
+type mboxMessage struct {
+	separator string
+	data      string
+}
+
+// readMboxrd разбирает mbox-файл в формате mboxrd, снимая по одному ">" со строк вида ">*From "
+func readMboxrd(t *testing.T, data []byte) []mboxMessage {
+	t.Helper()
+
+	var messages []mboxMessage
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if strings.HasPrefix(line, "From ") {
+			messages = append(messages, mboxMessage{separator: strings.TrimSuffix(line, "\n")})
+			continue
+		}
+		if len(messages) == 0 {
+			t.Fatalf("mbox does not start with a From line: %q", line)
+		}
+		if strings.HasPrefix(strings.TrimLeft(line, ">"), "From ") {
+			line = line[1:]
+		}
+		messages[len(messages)-1].data += line
+	}
+	// Каждое письмо завершается пустой строкой, которая не относится к телу
+	for i := range messages {
+		messages[i].data = strings.TrimSuffix(messages[i].data, "\n")
+	}
+	return messages
+}
+
+func TestMaildirSenderDeliversToNew(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "Maildir")
+	sender, err := email.NewMaildirSender(dir, "robot@example.com")
+	if err != nil {
+		t.Fatalf("NewMaildirSender: %v", err)
+	}
+
+	const count = 5
+	for range count {
+		if err := sender.Send("rcpt@example.com", "Тема", "Привет!", nil); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+
+	for _, sub := range []string{"tmp", "cur"} {
+		entries, err := os.ReadDir(filepath.Join(dir, sub))
+		if err != nil {
+			t.Fatalf("reading %s: %v", sub, err)
+		}
+		if len(entries) != 0 {
+			t.Errorf("%s contains %d files, want none", sub, len(entries))
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, "new"))
+	if err != nil {
+		t.Fatalf("reading new: %v", err)
+	}
+	if len(entries) != count {
+		t.Fatalf("new contains %d files, want %d (file names must be unique)", len(entries), count)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "new", entries[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("\r\n")) {
+		t.Error("Maildir message contains CRLF line endings")
+	}
+	if _, err := mail.ReadMessage(bytes.NewReader(data)); err != nil {
+		t.Errorf("parsing Maildir message: %v", err)
+	}
+}
+
+func TestMemorySenderQueries(t *testing.T) {
+	sender := email.NewMemorySender("robot@example.com")
+
+	messages := []*email.Message{
+		{To: []email.Address{{Address: "alice@example.com"}}, Subject: "Счет", Parts: []email.Part{email.TextPart("1")}},
+		{To: []email.Address{{Address: "bob@example.com"}}, Bcc: []email.Address{{Address: "Alice@Example.com"}}, Subject: "Отчет", Parts: []email.Part{email.TextPart("2")}},
+		{To: []email.Address{{Address: "bob@example.com"}}, Subject: "Счет", Parts: []email.Part{email.TextPart("3")}},
+	}
+	for _, msg := range messages {
+		if _, err := sender.SendMessage(context.Background(), msg); err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+	}
+
+	subjects := func(found []email.SentMessage) string {
+		var s []string
+		for _, msg := range found {
+			s = append(s, msg.Message.Subject+"/"+strings.Join(msg.Recipients, ","))
+		}
+		return strings.Join(s, " ")
+	}
+
+	if got, want := subjects(sender.To("ALICE@example.com")), "Счет/alice@example.com Отчет/bob@example.com,Alice@Example.com"; got != want {
+		t.Errorf("To(alice) = %q, want %q", got, want)
+	}
+	if got, want := subjects(sender.WithSubject("Счет")), "Счет/alice@example.com Счет/bob@example.com"; got != want {
+		t.Errorf("WithSubject = %q, want %q", got, want)
+	}
+	if got := sender.WithSubject("Нет такой"); got != nil {
+		t.Errorf("WithSubject(unknown) = %v, want nil", got)
+	}
+	onlyBob := func(msg email.SentMessage) bool {
+		return len(msg.Recipients) == 1 && msg.Recipients[0] == "bob@example.com"
+	}
+	if got, want := subjects(sender.Filter(onlyBob)), "Счет/bob@example.com"; got != want {
+		t.Errorf("Filter = %q, want %q", got, want)
+	}
+
+	last, ok := sender.Last()
+	if !ok || last.From != "robot@example.com" {
+		t.Errorf("Last() = %+v, %v", last, ok)
+	}
+	parsed, err := last.Parse()
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if parsed.Header.Get("Message-ID") != last.Message.MessageID {
+		t.Errorf("Message-ID header %q does not match Message.MessageID %q", parsed.Header.Get("Message-ID"), last.Message.MessageID)
+	}
+
+	sender.Reset()
+	if sender.Len() != 0 || sender.To("alice@example.com") != nil {
+		t.Error("Reset did not remove messages")
+	}
+}
@@ -0,0 +1,72 @@
+package email
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// MaildirSender доставляет письма в каталог Maildir: письмо пишется в tmp и атомарно переносится в new,
+// поэтому почтовый клиент никогда не увидит его недописанным
+type MaildirSender struct {
+	localSender
+	dir      string
+	hostname string
+	counter  atomic.Uint64
+}
+
+// NewMaildirSender создает отправитель для Maildir в dir; подкаталоги tmp, new и cur создаются при необходимости
+func NewMaildirSender(dir, from string) (*MaildirSender, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			return nil, fmt.Errorf("error creating Maildir: %w", err)
+		}
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+
+	m := &MaildirSender{dir: dir, hostname: hostname}
+	m.localSender = localSender{from: from, store: m.store}
+	return m, nil
+}
+
+func (m *MaildirSender) store(_ *Message, _ string, _ []string, data []byte) error {
+	// Имя по соглашению Maildir: время, уникальная для процесса часть и имя хоста
+	now := time.Now()
+	name := fmt.Sprintf("%d.M%dP%dQ%d.%s", now.Unix(), now.Nanosecond()/1000, os.Getpid(), m.counter.Add(1), m.hostname)
+
+	tmp := filepath.Join(m.dir, "tmp", name)
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("error creating Maildir message: %w", err)
+	}
+	if _, err := f.Write(toLF(data)); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("error writing Maildir message: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("error writing Maildir message: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error writing Maildir message: %w", err)
+	}
+
+	dest := filepath.Join(m.dir, "new", name)
+	if err := os.Rename(tmp, dest); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error delivering Maildir message: %w", err)
+	}
+
+	log.Println("Письмо доставлено в Maildir:", dest)
+	return nil
+}
@@ -0,0 +1,70 @@
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// MboxSender дописывает письма в mbox-файл в формате mboxrd.
+// Безопасен для одновременного использования в пределах одного процесса.
+type MboxSender struct {
+	localSender
+	path string
+	mu   sync.Mutex
+}
+
+// NewMboxSender создает отправитель, дописывающий письма в файл path
+func NewMboxSender(path, from string) (*MboxSender, error) {
+	m := &MboxSender{path: path}
+	m.localSender = localSender{from: from, store: m.store}
+	return m, nil
+}
+
+func (m *MboxSender) store(_ *Message, envelopeFrom string, _ []string, data []byte) error {
+	if envelopeFrom == "" {
+		envelopeFrom = "MAILER-DAEMON"
+	}
+
+	var buf bytes.Buffer
+	// Разделитель писем: "From " с адресом конверта и датой в формате asctime
+	fmt.Fprintf(&buf, "From %s %s\n", envelopeFrom, time.Now().UTC().Format(time.ANSIC))
+
+	// mboxrd: строки вида ">*From " экранируются еще одним ">", чтобы не стать разделителем
+	scanner := bufio.NewScanner(bytes.NewReader(toLF(data)))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From ")) {
+			buf.WriteByte('>')
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := os.OpenFile(m.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("error opening mbox file: %w", err)
+	}
+	if _, err := buf.WriteTo(f); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing mbox file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error writing mbox file: %w", err)
+	}
+
+	log.Println("Письмо добавлено в mbox:", m.path)
+	return nil
+}
@@ -0,0 +1,125 @@
+package email
+
+import (
+	"bytes"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SentMessage — письмо, сохраненное MemorySender
+type SentMessage struct {
+	// From — адрес отправителя конверта
+	From string
+	// Recipients — адреса получателей конверта, включая Bcc
+	Recipients []string
+	// Message — письмо с заполненными From, Date и MessageID
+	Message *Message
+	// Data — письмо в формате RFC 5322 в том виде, в котором оно ушло бы на сервер
+	Data   []byte
+	SentAt time.Time
+}
+
+// Parse разбирает сохраненное письмо, чтобы проверить его заголовки и тело
+func (m SentMessage) Parse() (*mail.Message, error) {
+	return mail.ReadMessage(bytes.NewReader(m.Data))
+}
+
+// MemorySender хранит отправленные письма в памяти; предназначен для тестов и локальной разработки.
+// Безопасен для одновременного использования.
+type MemorySender struct {
+	localSender
+
+	mu       sync.Mutex
+	messages []SentMessage
+}
+
+// NewMemorySender создает пустой MemorySender
+func NewMemorySender(from string) *MemorySender {
+	m := &MemorySender{}
+	m.localSender = localSender{from: from, store: m.store}
+	return m
+}
+
+func (m *MemorySender) store(msg *Message, envelopeFrom string, recipients []string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, SentMessage{
+		From:       envelopeFrom,
+		Recipients: recipients,
+		Message:    msg,
+		Data:       bytes.Clone(data),
+		SentAt:     time.Now(),
+	})
+	return nil
+}
+
+// Messages возвращает копию списка всех сохраненных писем в порядке отправки
+func (m *MemorySender) Messages() []SentMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]SentMessage(nil), m.messages...)
+}
+
+// Len возвращает число сохраненных писем
+func (m *MemorySender) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.messages)
+}
+
+// Last возвращает последнее сохраненное письмо; ok равно false, если писем нет
+func (m *MemorySender) Last() (msg SentMessage, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.messages) == 0 {
+		return SentMessage{}, false
+	}
+	return m.messages[len(m.messages)-1], true
+}
+
+// To возвращает письма, среди получателей конверта которых есть address (без учета регистра)
+func (m *MemorySender) To(address string) []SentMessage {
+	return m.Filter(func(msg SentMessage) bool {
+		for _, rcpt := range msg.Recipients {
+			if strings.EqualFold(rcpt, address) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// WithSubject возвращает письма с темой subject
+func (m *MemorySender) WithSubject(subject string) []SentMessage {
+	return m.Filter(func(msg SentMessage) bool {
+		return msg.Message.Subject == subject
+	})
+}
+
+// Filter возвращает письма, для которых match возвращает true
+func (m *MemorySender) Filter(match func(SentMessage) bool) []SentMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var found []SentMessage
+	for _, msg := range m.messages {
+		if match(msg) {
+			found = append(found, msg)
+		}
+	}
+	return found
+}
+
+// Reset удаляет все сохраненные письма
+func (m *MemorySender) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = nil
+}
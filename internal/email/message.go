@@ -0,0 +1,285 @@
+package email
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Типы содержимого тела письма
+const (
+	ContentTypePlain = "text/plain"
+	ContentTypeHTML  = "text/html"
+)
+
+// ErrRecipientsRejected возвращается, когда сервер отклонил часть получателей, но письмо отправлено остальным
+var ErrRecipientsRejected = errors.New("some recipients were rejected")
+
+// ErrNoRecipients возвращается, когда в письме не указан ни один получатель
+var ErrNoRecipients = errors.New("message has no recipients")
+
+// Address — почтовый адрес с необязательным отображаемым именем
+type Address = mail.Address
+
+// Part — одно из альтернативных представлений тела письма
+type Part struct {
+	// ContentType — тип содержимого без параметров, например text/plain
+	ContentType string
+	Body        string
+}
+
+// TextPart возвращает текстовую часть тела
+func TextPart(body string) Part {
+	return Part{ContentType: ContentTypePlain, Body: body}
+}
+
+// HTMLPart возвращает HTML-часть тела; текстовая альтернатива будет построена автоматически,
+// если в письме нет явной TextPart
+func HTMLPart(body string) Part {
+	return Part{ContentType: ContentTypeHTML, Body: body}
+}
+
+// AttachmentSource — источник содержимого вложения
+type AttachmentSource interface {
+	// Open открывает содержимое для чтения; вызывающий закрывает результат
+	Open() (io.ReadCloser, error)
+}
+
+// FileSource читает вложение из файла при отправке
+type FileSource string
+
+// Open открывает файл
+func (f FileSource) Open() (io.ReadCloser, error) {
+	file, err := os.Open(string(f))
+	if err != nil {
+		return nil, fmt.Errorf("error opening attachment file %s: %w", string(f), err)
+	}
+	return file, nil
+}
+
+// BytesSource отдает вложение из памяти
+type BytesSource []byte
+
+// Open возвращает reader по срезу байт; срез можно читать многократно
+func (b BytesSource) Open() (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+// readerSource отдает содержимое произвольного io.Reader; прочитать его можно только один раз
+type readerSource struct {
+	r    io.Reader
+	used bool
+}
+
+// ReaderSource возвращает источник на основе io.Reader.
+// Такое вложение можно отправить только один раз: повторная попытка вернет ошибку.
+func ReaderSource(r io.Reader) AttachmentSource {
+	return &readerSource{r: r}
+}
+
+func (s *readerSource) Open() (io.ReadCloser, error) {
+	if s.used {
+		return nil, errors.New("attachment reader has already been consumed")
+	}
+	s.used = true
+	if rc, ok := s.r.(io.ReadCloser); ok {
+		return rc, nil
+	}
+	return io.NopCloser(s.r), nil
+}
+
+// Attachment — вложение письма
+type Attachment struct {
+	// Path — путь к файлу; используется, если Source не задан
+	Path string
+	// Source — источник содержимого вложения: файл, срез байт или io.Reader
+	Source AttachmentSource
+	// Name — имя вложения в письме; по умолчанию имя файла из Path
+	Name string
+	// ContentType — тип содержимого; по умолчанию определяется по расширению имени
+	ContentType string
+	// Inline — встроенное вложение (например, логотип), на которое HTML ссылается через cid:
+	Inline bool
+	// ContentID — идентификатор встроенного вложения без угловых скобок
+	ContentID string
+}
+
+// NewAttachment создает вложение из среза байт
+func NewAttachment(name string, data []byte) Attachment {
+	return Attachment{Name: name, Source: BytesSource(data)}
+}
+
+// NewReaderAttachment создает вложение, содержимое которого читается из r в момент отправки
+func NewReaderAttachment(name string, r io.Reader) Attachment {
+	return Attachment{Name: name, Source: ReaderSource(r)}
+}
+
+// source возвращает источник содержимого вложения
+func (a Attachment) source() AttachmentSource {
+	if a.Source != nil {
+		return a.Source
+	}
+	return FileSource(a.Path)
+}
+
+// filename возвращает имя вложения в письме
+func (a Attachment) filename() string {
+	if a.Name != "" {
+		return a.Name
+	}
+	return filepath.Base(a.Path)
+}
+
+// Embed добавляет файл как встроенное вложение и возвращает URL вида cid:..., пригодный для <img src>
+func (m *Message) Embed(path string) string {
+	contentID := generateContentID()
+	m.Attachments = append(m.Attachments, Attachment{Path: path, Inline: true, ContentID: contentID})
+	return "cid:" + contentID
+}
+
+// generateContentID создает уникальный Content-ID
+func generateContentID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:]) + "@inline"
+}
+
+// Message описывает письмо целиком: адресатов, заголовки, тело и вложения
+type Message struct {
+	// From — автор письма с необязательным отображаемым именем, например "Отдел продаж <sales@example.com>";
+	// если адрес пустой, используется адрес отправителя по умолчанию (WithFrom или логин SMTP-аккаунта)
+	From Address
+	To   []Address
+	Cc   []Address
+	// Bcc — скрытые получатели: попадают только в конверт, но не в заголовки
+	Bcc     []Address
+	ReplyTo []Address
+	// Sender — фактический отправитель, если он отличается от автора в From, например общий ящик,
+	// который отправляет письмо от имени отдела (RFC 5322, раздел 3.6.2)
+	Sender Address
+	// ReturnPath — адрес конверта MAIL FROM, на который приходят уведомления о недоставке;
+	// если не задан, используется WithReturnPath или логин SMTP-аккаунта
+	ReturnPath string
+	Subject    string
+	// Date — дата письма; если не задана, подставляется время отправки
+	Date time.Time
+	// MessageID — идентификатор письма вида <id@domain>; если не задан, создается из домена отправителя
+	MessageID string
+	// Headers — дополнительные заголовки письма, например References или X-Campaign-ID.
+	// Значения не должны содержать переводов строк, а служебные заголовки задаются полями Message.
+	Headers     map[string]string
+	Parts       []Part
+	Attachments []Attachment
+	// Protection подписывает и (или) шифрует тело письма; nil — письмо отправляется открытым
+	Protection Protection
+	// DSN запрашивает уведомления о доставке; nil — уведомления на усмотрение сервера
+	DSN *DSN
+	// Unsubscribe добавляет заголовки List-Unsubscribe и List-Unsubscribe-Post; nil — письмо без них
+	Unsubscribe *ListUnsubscribe
+}
+
+// Recipients возвращает всех получателей конверта: To, Cc и Bcc без повторов
+func (m *Message) Recipients() []string {
+	seen := make(map[string]bool)
+	var recipients []string
+	for _, list := range [][]Address{m.To, m.Cc, m.Bcc} {
+		for _, addr := range list {
+			key := strings.ToLower(addr.Address)
+			if addr.Address == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			recipients = append(recipients, addr.Address)
+		}
+	}
+	return recipients
+}
+
+// bodyParts возвращает части тела в порядке multipart/alternative: от простой к богатой.
+// Для HTML без текстовой версии текст строится из HTML.
+func (m *Message) bodyParts() ([]Part, error) {
+	var plain, rich []Part
+	var html *Part
+	for i, part := range m.Parts {
+		switch part.ContentType {
+		case ContentTypePlain:
+			plain = append(plain, part)
+		case ContentTypeHTML:
+			html = &m.Parts[i]
+			rich = append(rich, part)
+		default:
+			rich = append(rich, part)
+		}
+	}
+
+	if len(plain) == 0 && html != nil {
+		text, err := htmlToText(html.Body)
+		if err != nil {
+			return nil, err
+		}
+		plain = append(plain, TextPart(text))
+	}
+
+	return append(plain, rich...), nil
+}
+
+// RecipientStatus — результат RCPT TO для одного получателя
+type RecipientStatus struct {
+	Address string
+	// Err — ошибка сервера; nil означает, что получатель принят
+	Err error
+}
+
+// Result описывает итог отправки письма по каждому получателю
+type Result struct {
+	// MessageID — идентификатор отправленного письма для отслеживания
+	MessageID  string
+	Recipients []RecipientStatus
+	// Attempts — число попыток отправки, включая первую
+	Attempts int
+	// DSN сообщает, что сервер поддерживает DSN и получил запрос уведомлений из Message.DSN
+	DSN bool
+}
+
+// Accepted возвращает адреса, принятые сервером
+func (r *Result) Accepted() []string {
+	var accepted []string
+	for _, rcpt := range r.Recipients {
+		if rcpt.Err == nil {
+			accepted = append(accepted, rcpt.Address)
+		}
+	}
+	return accepted
+}
+
+// Rejected возвращает получателей, отклоненных сервером
+func (r *Result) Rejected() []RecipientStatus {
+	var rejected []RecipientStatus
+	for _, rcpt := range r.Recipients {
+		if rcpt.Err != nil {
+			rejected = append(rejected, rcpt)
+		}
+	}
+	return rejected
+}
+
+// newSimpleMessage собирает Message из аргументов Sender.Send
+func newSimpleMessage(to, subject, body string, attachmentFilePaths []string) *Message {
+	msg := &Message{
+		To:      []Address{{Address: to}},
+		Subject: subject,
+		Parts:   []Part{TextPart(body)},
+	}
+	for _, path := range attachmentFilePaths {
+		msg.Attachments = append(msg.Attachments, Attachment{Path: path})
+	}
+	return msg
+}
@@ -0,0 +1,221 @@
+package email
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// mimePart — узел MIME-дерева письма: либо multipart-контейнер с дочерними частями,
+// либо лист, содержимое которого кодируется в момент записи
+type mimePart struct {
+	header   textproto.MIMEHeader
+	boundary string
+	children []*mimePart
+	body     func(w io.Writer) error
+}
+
+// newMultipart создает контейнер multipart/* из дочерних частей
+func newMultipart(mediaType string, children ...*mimePart) *mimePart {
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+	params := map[string]string{"boundary": boundary}
+
+	// RFC 2387 требует указать в multipart/related тип корневой (первой) части
+	if mediaType == "multipart/related" && len(children) > 0 {
+		if rootType, _, err := mime.ParseMediaType(children[0].header.Get("Content-Type")); err == nil {
+			params["type"] = rootType
+		}
+	}
+
+	return &mimePart{
+		header: textproto.MIMEHeader{
+			"Content-Type": {formatContentType(mediaType, params)},
+		},
+		boundary: boundary,
+		children: children,
+	}
+}
+
+// newTextPart создает лист с текстом, выбирая Content-Transfer-Encoding по содержимому;
+// allow8bit разрешает 8bit, если сервер поддерживает 8BITMIME
+func newTextPart(p Part, allow8bit bool) *mimePart {
+	// Текстовые части передаются в каноническом виде с CRLF в конце строк
+	body := strings.ReplaceAll(strings.ReplaceAll(p.Body, "\r\n", "\n"), "\n", "\r\n")
+	transferEncoding := chooseTransferEncoding(body, allow8bit)
+
+	return &mimePart{
+		header: textproto.MIMEHeader{
+			"Content-Type":              {formatContentType(p.ContentType, map[string]string{"charset": "UTF-8"})},
+			"Content-Transfer-Encoding": {transferEncoding},
+		},
+		body: func(w io.Writer) error {
+			encoder := newBodyEncoder(w, transferEncoding)
+			if _, err := io.WriteString(encoder, body); err != nil {
+				return fmt.Errorf("error encoding text part: %w", err)
+			}
+			return encoder.Close()
+		},
+	}
+}
+
+// newAttachmentPart создает лист с вложением в base64; встроенные вложения получают Content-ID
+func newAttachmentPart(a Attachment) *mimePart {
+	name := a.filename()
+	contentType := a.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(name))
+	}
+	mimeType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mimeType = "application/octet-stream"
+	}
+
+	disposition := "attachment"
+	if a.Inline {
+		disposition = "inline"
+	}
+
+	// Не-ASCII имя файла кодируется по RFC 2231
+	header := textproto.MIMEHeader{
+		"Content-Disposition":       {formatDisposition(disposition, name)},
+		"Content-Type":              {formatContentType(mimeType, map[string]string{"name": name})},
+		"Content-Transfer-Encoding": {encodingBase64},
+	}
+	if a.ContentID != "" {
+		header.Set("Content-ID", "<"+a.ContentID+">")
+	}
+
+	return &mimePart{
+		header: header,
+		body: func(w io.Writer) error {
+			content, err := a.source().Open()
+			if err != nil {
+				return err
+			}
+			defer content.Close()
+
+			// Кодируем содержимое в base64 строками по 76 символов, не загружая его в память целиком
+			encoder := newBase64Encoder(w)
+			if _, err = io.Copy(encoder, content); err != nil {
+				return fmt.Errorf("error encoding file content: %w", err)
+			}
+			return encoder.Close()
+		},
+	}
+}
+
+// buildMIMETree раскладывает письмо по контейнерам:
+//
+//	multipart/mixed
+//	├── multipart/alternative
+//	│   ├── text/plain
+//	│   └── multipart/related
+//	│       ├── text/html
+//	│       └── встроенные изображения
+//	└── вложения
+//
+// Контейнеры с единственной частью опускаются. Если задан Message.Protection,
+// дерево целиком подписывается и (или) шифруется. allow8bit разрешает текстовым частям
+// кодирование 8bit; для писем с Protection оно игнорируется, так как подписанное
+// содержимое должно оставаться 7-битным (RFC 1847).
+func buildMIMETree(msg *Message, allow8bit bool) (*mimePart, error) {
+	if msg.Protection != nil {
+		allow8bit = false
+	}
+
+	parts, err := msg.bodyParts()
+	if err != nil {
+		return nil, err
+	}
+
+	var inline, attachments []*mimePart
+	for _, a := range msg.Attachments {
+		if a.Inline {
+			inline = append(inline, newAttachmentPart(a))
+		} else {
+			attachments = append(attachments, newAttachmentPart(a))
+		}
+	}
+
+	// Встроенные изображения привязываются к HTML-части, которая на них ссылается
+	var alternatives []*mimePart
+	relatedAttached := false
+	for _, p := range parts {
+		part := newTextPart(p, allow8bit)
+		if p.ContentType == ContentTypeHTML && len(inline) > 0 && !relatedAttached {
+			part = newMultipart("multipart/related", append([]*mimePart{part}, inline...)...)
+			relatedAttached = true
+		}
+		alternatives = append(alternatives, part)
+	}
+
+	var body *mimePart
+	switch len(alternatives) {
+	case 0:
+		body = newTextPart(TextPart(""), allow8bit)
+	case 1:
+		body = alternatives[0]
+	default:
+		body = newMultipart("multipart/alternative", alternatives...)
+	}
+
+	// Без HTML встроенные части все равно должны остаться рядом с телом
+	if len(inline) > 0 && !relatedAttached {
+		body = newMultipart("multipart/related", append([]*mimePart{body}, inline...)...)
+	}
+
+	root := body
+	if len(attachments) > 0 {
+		root = newMultipart("multipart/mixed", append([]*mimePart{body}, attachments...)...)
+	}
+
+	if msg.Protection != nil {
+		return msg.Protection.protect(msg, root)
+	}
+	return root, nil
+}
+
+// writeHeader записывает заголовки части в детерминированном порядке
+func (p *mimePart) writeHeader(w io.Writer) error {
+	keys := make([]string, 0, len(p.header))
+	for key := range p.header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		for _, value := range p.header[key] {
+			if _, err := io.WriteString(w, formatHeader(key, value)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeBody записывает содержимое части без ее заголовков
+func (p *mimePart) writeBody(w io.Writer) error {
+	if p.body != nil {
+		return p.body(w)
+	}
+
+	writer := multipart.NewWriter(w)
+	if err := writer.SetBoundary(p.boundary); err != nil {
+		return fmt.Errorf("error setting boundary: %w", err)
+	}
+	for _, child := range p.children {
+		part, err := writer.CreatePart(child.header)
+		if err != nil {
+			return fmt.Errorf("error creating MIME part: %w", err)
+		}
+		if err := child.writeBody(part); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
@@ -0,0 +1,156 @@
+package email_test
+
+import (
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/mclyashko/IPORPIS/internal/email"
+)
+
+func TestMIMETreeStructure(t *testing.T) {
+	logo := email.Attachment{Name: "logo.png", Source: email.BytesSource([]byte("\x89PNG")), Inline: true, ContentID: "logo@example.com"}
+	report := email.NewAttachment("отчет.pdf", []byte("%PDF-1.4"))
+
+	tests := []struct {
+		name        string
+		parts       []email.Part
+		attachments []email.Attachment
+		want        string
+	}{
+		{
+			name:        "text, HTML, inline image and attachment",
+			parts:       []email.Part{email.TextPart("Привет!"), email.HTMLPart(`<p>Привет!</p><img src="cid:logo@example.com">`)},
+			attachments: []email.Attachment{logo, report},
+			want:        "multipart/mixed[multipart/alternative[text/plain multipart/related(text/html)[text/html image/png<logo@example.com>]] application/pdf]",
+		},
+		{
+			name:  "text only",
+			parts: []email.Part{email.TextPart("Привет!")},
+			want:  "text/plain",
+		},
+		{
+			name:  "HTML only",
+			parts: []email.Part{email.HTMLPart("<p>Привет!</p>")},
+			want:  "multipart/alternative[text/plain text/html]",
+		},
+		{
+			name:        "text and attachment",
+			parts:       []email.Part{email.TextPart("Привет!")},
+			attachments: []email.Attachment{report},
+			want:        "multipart/mixed[text/plain application/pdf]",
+		},
+		{
+			name:        "text and inline image",
+			parts:       []email.Part{email.TextPart("Привет!")},
+			attachments: []email.Attachment{logo},
+			want:        "multipart/related(text/plain)[text/plain image/png<logo@example.com>]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender := email.NewMemorySender("robot@example.com")
+			msg := &email.Message{
+				To:          []email.Address{{Address: "rcpt@example.com"}},
+				Subject:     "Тема",
+				Parts:       tt.parts,
+				Attachments: tt.attachments,
+			}
+			if _, err := sender.SendMessage(context.Background(), msg); err != nil {
+				t.Fatalf("SendMessage: %v", err)
+			}
+
+			sent, _ := sender.Last()
+			parsed, err := sent.Parse()
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if got := parsed.Header.Get("MIME-Version"); got != "1.0" {
+				t.Errorf("MIME-Version = %q, want 1.0", got)
+			}
+
+			got := describeMIME(t, textproto.MIMEHeader(parsed.Header), parsed.Body)
+			if got != tt.want {
+				t.Errorf("MIME structure =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// describeMIME разбирает часть письма через mime/multipart и описывает ее вложенность строкой:
+// тип содержимого, для multipart/related — тип корня в скобках, для встроенных частей — Content-ID
+func describeMIME(t *testing.T, header textproto.MIMEHeader, body io.Reader) string {
+	t.Helper()
+
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("parsing Content-Type %q: %v", header.Get("Content-Type"), err)
+	}
+
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		if _, err := io.Copy(io.Discard, body); err != nil {
+			t.Fatalf("reading %s body: %v", mediaType, err)
+		}
+		return describeLeaf(t, mediaType, header)
+	}
+
+	description := mediaType
+	if mediaType == "multipart/related" {
+		description += "(" + params["type"] + ")"
+	}
+
+	var children []string
+	reader := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("reading %s: %v", mediaType, err)
+		}
+		children = append(children, describeMIME(t, part.Header, part))
+	}
+	return description + "[" + strings.Join(children, " ") + "]"
+}
+
+func describeLeaf(t *testing.T, mediaType string, header textproto.MIMEHeader) string {
+	t.Helper()
+
+	if strings.HasPrefix(mediaType, "text/") {
+		if _, params, _ := mime.ParseMediaType(header.Get("Content-Type")); params["charset"] != "UTF-8" {
+			t.Errorf("%s part charset = %q, want UTF-8", mediaType, params["charset"])
+		}
+		return mediaType
+	}
+
+	disposition, params, err := mime.ParseMediaType(header.Get("Content-Disposition"))
+	if err != nil {
+		t.Fatalf("parsing Content-Disposition of %s: %v", mediaType, err)
+	}
+	if params["filename"] == "" {
+		t.Errorf("%s part has no file name", mediaType)
+	}
+	if header.Get("Content-Transfer-Encoding") != "base64" {
+		t.Errorf("%s part Content-Transfer-Encoding = %q, want base64", mediaType, header.Get("Content-Transfer-Encoding"))
+	}
+
+	contentID := header.Get("Content-ID")
+	switch {
+	case disposition == "inline" && contentID == "":
+		t.Errorf("inline %s part has no Content-ID", mediaType)
+	case disposition == "attachment" && contentID != "":
+		t.Errorf("attachment %s has Content-ID %s", mediaType, contentID)
+	case disposition != "inline" && disposition != "attachment":
+		t.Errorf("%s part disposition = %q", mediaType, disposition)
+	}
+	if contentID != "" {
+		return mediaType + contentID
+	}
+	return mediaType
+}
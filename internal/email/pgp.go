@@ -0,0 +1,243 @@
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// PGPOptions описывает ключи OpenPGP в том виде, в котором они задаются в конфигурации
+type PGPOptions struct {
+	// KeyFile — закрытый ключ отправителя для подписи, в ASCII-armor или двоичном виде
+	KeyFile string
+	// Passphrase — пароль закрытого ключа, если он зашифрован
+	Passphrase string
+	// KeyringFile — связка открытых ключей получателей для шифрования; ключ ищется по адресу в User ID
+	KeyringFile string
+}
+
+// PGP подписывает и шифрует письма по PGP/MIME (RFC 3156)
+type PGP struct {
+	signer  *openpgp.Entity
+	keyring openpgp.EntityList
+	now     func() time.Time
+}
+
+// NewPGP загружает ключ отправителя и связку ключей получателей, если они заданы
+func NewPGP(opts PGPOptions) (*PGP, error) {
+	p := &PGP{now: time.Now}
+
+	if opts.KeyFile != "" {
+		entities, err := readKeyRingFile(opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading PGP private key: %w", err)
+		}
+		p.signer = entities[0]
+		if p.signer.PrivateKey == nil {
+			return nil, fmt.Errorf("PGP key file %s contains no private key", opts.KeyFile)
+		}
+		if opts.Passphrase != "" {
+			if err := p.signer.DecryptPrivateKeys([]byte(opts.Passphrase)); err != nil {
+				return nil, fmt.Errorf("error decrypting PGP private key: %w", err)
+			}
+		}
+		if p.signer.PrivateKey.Encrypted {
+			return nil, errors.New("PGP private key is protected by a passphrase")
+		}
+	}
+
+	if opts.KeyringFile != "" {
+		keyring, err := readKeyRingFile(opts.KeyringFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading PGP keyring: %w", err)
+		}
+		p.keyring = keyring
+	}
+
+	return p, nil
+}
+
+// readKeyRingFile читает ключи OpenPGP из файла в ASCII-armor или двоичном виде
+func readKeyRingFile(path string) (openpgp.EntityList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entities openpgp.EntityList
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN PGP")) {
+		entities, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		entities, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(entities) == 0 {
+		return nil, fmt.Errorf("no keys found in %s", path)
+	}
+	return entities, nil
+}
+
+// Protection возвращает защиту писем: подпись ключом отправителя, шифрование для получателей или и то и другое
+func (p *PGP) Protection(sign, encrypt bool) (Protection, error) {
+	if !sign && !encrypt {
+		return nil, errors.New("PGP protection must sign or encrypt")
+	}
+	if sign && p.signer == nil {
+		return nil, errors.New("PGP signing requires a private key")
+	}
+	if encrypt && len(p.keyring) == 0 {
+		return nil, errors.New("PGP encryption requires a recipient keyring")
+	}
+	return &pgpProtection{pgp: p, sign: sign, encrypt: encrypt}, nil
+}
+
+// RecipientKey ищет в связке действующий ключ шифрования получателя address
+func (p *PGP) RecipientKey(address string) (*openpgp.Entity, error) {
+	now := p.now()
+	for _, entity := range p.keyring {
+		for _, identity := range entity.Identities {
+			if identity.UserId == nil || !strings.EqualFold(identity.UserId.Email, strings.TrimSpace(address)) {
+				continue
+			}
+			if _, ok := entity.EncryptionKey(now); ok {
+				return entity, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: no valid PGP key for %s in keyring", ErrRecipientKeyNotFound, address)
+}
+
+// config возвращает настройки OpenPGP: SHA-256 для подписи, чтобы micalg всегда был pgp-sha256
+func (p *PGP) config() *packet.Config {
+	return &packet.Config{DefaultHash: crypto.SHA256, Time: p.now}
+}
+
+// pgpProtection — защита писем, возвращаемая PGP.Protection
+type pgpProtection struct {
+	pgp     *PGP
+	sign    bool
+	encrypt bool
+}
+
+// protect подписывает тело отсоединенной подписью или шифрует его; при шифровании подпись
+// помещается внутрь зашифрованного сообщения OpenPGP (RFC 3156, раздел 6.2)
+func (p *pgpProtection) protect(msg *Message, root *mimePart) (*mimePart, error) {
+	if p.encrypt {
+		var signer *openpgp.Entity
+		if p.sign {
+			signer = p.pgp.signer
+		}
+		return p.pgp.encryptPart(root, msg.Recipients(), signer)
+	}
+	return p.pgp.signPart(root)
+}
+
+// signPart оборачивает часть в multipart/signed с отсоединенной подписью OpenPGP
+func (p *PGP) signPart(part *mimePart) (*mimePart, error) {
+	content, err := renderPart(part)
+	if err != nil {
+		return nil, err
+	}
+
+	var signature bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&signature, p.signer, bytes.NewReader(content), p.config()); err != nil {
+		return nil, fmt.Errorf("error signing message with PGP: %w", err)
+	}
+
+	params := map[string]string{"protocol": "application/pgp-signature", "micalg": "pgp-sha256"}
+	return newSignedMultipart(params, content, newArmoredPart("application/pgp-signature", "signature.asc", signature.Bytes())), nil
+}
+
+// encryptPart шифрует часть для всех получателей и для самого отправителя и, если signer задан, подписывает ее
+func (p *PGP) encryptPart(part *mimePart, recipients []string, signer *openpgp.Entity) (*mimePart, error) {
+	var keys []*openpgp.Entity
+	for _, rcpt := range recipients {
+		key, err := p.RecipientKey(rcpt)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if p.signer != nil {
+		if _, ok := p.signer.EncryptionKey(p.now()); ok {
+			keys = append(keys, p.signer)
+		}
+	}
+
+	content, err := renderPart(part)
+	if err != nil {
+		return nil, err
+	}
+
+	var encrypted bytes.Buffer
+	armored, err := armor.Encode(&encrypted, "PGP MESSAGE", nil)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := openpgp.Encrypt(armored, keys, signer, nil, p.config())
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting message with PGP: %w", err)
+	}
+	if _, err := plaintext.Write(content); err != nil {
+		return nil, fmt.Errorf("error encrypting message with PGP: %w", err)
+	}
+	if err := plaintext.Close(); err != nil {
+		return nil, fmt.Errorf("error encrypting message with PGP: %w", err)
+	}
+	if err := armored.Close(); err != nil {
+		return nil, err
+	}
+
+	// multipart/encrypted: управляющая часть с версией и само зашифрованное сообщение
+	control := &mimePart{
+		header: textproto.MIMEHeader{
+			"Content-Type": {"application/pgp-encrypted"},
+		},
+		body: func(w io.Writer) error {
+			_, err := io.WriteString(w, "Version: 1\r\n")
+			return err
+		},
+	}
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+	params := map[string]string{"protocol": "application/pgp-encrypted", "boundary": boundary}
+	return &mimePart{
+		header: textproto.MIMEHeader{
+			"Content-Type": {formatContentType("multipart/encrypted", params)},
+		},
+		boundary: boundary,
+		children: []*mimePart{control, newArmoredPart("application/octet-stream", "encrypted.asc", encrypted.Bytes())},
+	}, nil
+}
+
+// newArmoredPart создает лист с данными OpenPGP в ASCII-armor; строки переводятся в CRLF
+func newArmoredPart(mediaType, name string, armored []byte) *mimePart {
+	body := bytes.ReplaceAll(bytes.ReplaceAll(armored, []byte("\r\n"), []byte("\n")), []byte("\n"), []byte("\r\n"))
+	if !bytes.HasSuffix(body, []byte("\r\n")) {
+		body = append(body, '\r', '\n')
+	}
+
+	return &mimePart{
+		header: textproto.MIMEHeader{
+			"Content-Type":              {formatContentType(mediaType, map[string]string{"name": name})},
+			"Content-Transfer-Encoding": {encoding7bit},
+			"Content-Disposition":       {formatDisposition("inline", name)},
+		},
+		body: func(w io.Writer) error {
+			_, err := w.Write(body)
+			return err
+		},
+	}
+}
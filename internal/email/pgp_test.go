@@ -0,0 +1,200 @@
+package email_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+
+	"github.com/mclyashko/IPORPIS/internal/email"
+)
+
+// newPGPEntity создает ключ OpenPGP для address и записывает закрытый ключ в dir/<address>.asc
+func newPGPEntity(t *testing.T, dir, address string) *openpgp.Entity {
+	t.Helper()
+
+	entity, err := openpgp.NewEntity("", "", address, &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.SerializePrivate(w, nil); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if err := os.WriteFile(filepath.Join(dir, address+".asc"), buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return entity
+}
+
+// writeKeyring записывает открытые ключи entities в одну связку
+func writeKeyring(t *testing.T, path string, entities ...*openpgp.Entity) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entity := range entities {
+		if err := entity.Serialize(w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// multipartSections разбивает тело multipart на части без разбора, сохраняя их байты
+func multipartSections(t *testing.T, params map[string]string, body []byte) []string {
+	t.Helper()
+
+	delimiter := "--" + params["boundary"]
+	sections := strings.Split(string(body), "\r\n"+delimiter)
+	first, ok := strings.CutPrefix(sections[0], delimiter+"\r\n")
+	if !ok || len(sections) < 3 {
+		t.Fatalf("unexpected multipart layout:\n%s", body)
+	}
+	sections[0] = first
+	for i := 1; i < len(sections)-1; i++ {
+		sections[i] = strings.TrimPrefix(sections[i], "\r\n")
+	}
+	return sections[:len(sections)-1]
+}
+
+func TestPGPSign(t *testing.T) {
+	dir := t.TempDir()
+	sender := newPGPEntity(t, dir, "sender@example.com")
+
+	pgp, err := email.NewPGP(email.PGPOptions{KeyFile: filepath.Join(dir, "sender@example.com.asc")})
+	if err != nil {
+		t.Fatalf("NewPGP: %v", err)
+	}
+	protection, err := pgp.Protection(true, false)
+	if err != nil {
+		t.Fatalf("Protection: %v", err)
+	}
+
+	mediaType, params, body := messageBody(t, sendProtected(t, protection, "rcpt@example.com"))
+	if mediaType != "multipart/signed" || params["protocol"] != "application/pgp-signature" || params["micalg"] != "pgp-sha256" {
+		t.Fatalf("Content-Type = %s %v", mediaType, params)
+	}
+
+	sections := multipartSections(t, params, body)
+	if len(sections) != 2 {
+		t.Fatalf("got %d parts, want 2", len(sections))
+	}
+	_, signature, _ := strings.Cut(sections[1], "\r\n\r\n")
+	signer, err := openpgp.CheckArmoredDetachedSignature(openpgp.EntityList{sender}, strings.NewReader(sections[0]), strings.NewReader(signature), nil)
+	if err != nil {
+		t.Fatalf("signature does not verify: %v", err)
+	}
+	if signer.PrimaryKey.KeyId != sender.PrimaryKey.KeyId {
+		t.Errorf("signed by key %X", signer.PrimaryKey.KeyId)
+	}
+	if !strings.HasPrefix(sections[0], "Content-Type: multipart/mixed") {
+		t.Errorf("signed part is not the original body:\n%s", sections[0])
+	}
+}
+
+func TestPGPSignAndEncrypt(t *testing.T) {
+	dir := t.TempDir()
+	sender := newPGPEntity(t, dir, "sender@example.com")
+	rcpt := newPGPEntity(t, dir, "rcpt@example.com")
+	keyring := filepath.Join(dir, "keyring.asc")
+	writeKeyring(t, keyring, rcpt)
+
+	pgp, err := email.NewPGP(email.PGPOptions{KeyFile: filepath.Join(dir, "sender@example.com.asc"), KeyringFile: keyring})
+	if err != nil {
+		t.Fatalf("NewPGP: %v", err)
+	}
+	protection, err := pgp.Protection(true, true)
+	if err != nil {
+		t.Fatalf("Protection: %v", err)
+	}
+
+	data := sendProtected(t, protection, "Rcpt@Example.com")
+	if bytes.Contains(data, []byte("условия")) {
+		t.Fatalf("message is not encrypted:\n%s", data)
+	}
+
+	mediaType, params, body := messageBody(t, data)
+	if mediaType != "multipart/encrypted" || params["protocol"] != "application/pgp-encrypted" {
+		t.Fatalf("Content-Type = %s %v", mediaType, params)
+	}
+	sections := multipartSections(t, params, body)
+	if len(sections) != 2 || !strings.Contains(sections[0], "Version: 1") {
+		t.Fatalf("unexpected multipart/encrypted parts:\n%s", body)
+	}
+
+	_, ciphertext, _ := strings.Cut(sections[1], "\r\n\r\n")
+	for _, reader := range []*openpgp.Entity{rcpt, sender} {
+		block, err := armor.Decode(strings.NewReader(ciphertext))
+		if err != nil {
+			t.Fatalf("decoding armor: %v", err)
+		}
+		md, err := openpgp.ReadMessage(block.Body, openpgp.EntityList{reader, sender}, nil, nil)
+		if err != nil {
+			t.Fatalf("decrypting: %v", err)
+		}
+		content, err := io.ReadAll(md.UnverifiedBody)
+		if err != nil {
+			t.Fatalf("reading decrypted body: %v", err)
+		}
+		if !md.IsSigned || md.SignatureError != nil || md.SignedByKeyId != sender.PrimaryKey.KeyId {
+			t.Errorf("signature: signed %v, error %v", md.IsSigned, md.SignatureError)
+		}
+		if !bytes.HasPrefix(content, []byte("Content-Type: multipart/mixed")) {
+			t.Errorf("decrypted content is not the original body:\n%s", content)
+		}
+	}
+}
+
+func TestPGPEncryptMissingKey(t *testing.T) {
+	dir := t.TempDir()
+	other := newPGPEntity(t, dir, "other@example.com")
+	keyring := filepath.Join(dir, "keyring.asc")
+	writeKeyring(t, keyring, other)
+
+	pgp, err := email.NewPGP(email.PGPOptions{KeyringFile: keyring})
+	if err != nil {
+		t.Fatalf("NewPGP: %v", err)
+	}
+	if _, err := pgp.Protection(true, false); err == nil {
+		t.Error("signing without a private key must be rejected up front")
+	}
+	protection, err := pgp.Protection(false, true)
+	if err != nil {
+		t.Fatalf("Protection: %v", err)
+	}
+
+	sender := email.NewMemorySender("sender@example.com")
+	msg := &email.Message{
+		To:         []email.Address{{Address: "other@example.com"}, {Address: "unknown@example.com"}},
+		Parts:      []email.Part{email.TextPart("секрет")},
+		Protection: protection,
+	}
+	_, err = sender.SendMessage(context.Background(), msg)
+	if !errors.Is(err, email.ErrRecipientKeyNotFound) || !strings.Contains(err.Error(), "unknown@example.com") {
+		t.Fatalf("err = %v, want ErrRecipientKeyNotFound for unknown@example.com", err)
+	}
+	if sender.Len() != 0 {
+		t.Error("message without a recipient key must not be sent")
+	}
+}
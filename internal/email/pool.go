@@ -0,0 +1,213 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/smtp"
+	"sync"
+)
+
+// Значения PooledSender по умолчанию
+const (
+	DefaultMaxConns           = 2
+	DefaultMaxMessagesPerConn = 100
+)
+
+// ErrPoolClosed возвращается при отправке через закрытый PooledSender
+var ErrPoolClosed = errors.New("SMTP connection pool is closed")
+
+// PooledSender держит открытыми аутентифицированные SMTP-сессии и переиспользует их между письмами.
+// Безопасен для одновременного использования из нескольких горутин.
+type PooledSender struct {
+	sender             *SMTPSender
+	maxMessagesPerConn int
+
+	// slots ограничивает число одновременно открытых соединений
+	slots chan struct{}
+
+	mu     sync.Mutex
+	idle   []*pooledConn
+	closed bool
+}
+
+// pooledConn — открытая SMTP-сессия из пула
+type pooledConn struct {
+	client   *smtp.Client
+	conn     *ctxConn
+	messages int
+}
+
+// PoolOption настраивает PooledSender
+type PoolOption func(*PooledSender)
+
+// WithMaxConns ограничивает число одновременно открытых соединений
+func WithMaxConns(n int) PoolOption {
+	return func(p *PooledSender) {
+		p.slots = make(chan struct{}, max(n, 1))
+	}
+}
+
+// WithMaxMessagesPerConn задает, сколько писем отправить через одно соединение перед переподключением
+func WithMaxMessagesPerConn(n int) PoolOption {
+	return func(p *PooledSender) {
+		p.maxMessagesPerConn = max(n, 1)
+	}
+}
+
+// NewPooledSender создает пул соединений поверх настроек sender
+func NewPooledSender(sender *SMTPSender, opts ...PoolOption) *PooledSender {
+	p := &PooledSender{
+		sender:             sender,
+		maxMessagesPerConn: DefaultMaxMessagesPerConn,
+		slots:              make(chan struct{}, DefaultMaxConns),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Send отправляет электронное письмо одному получателю
+func (p *PooledSender) Send(to, subject, body string, attachmentFilePaths []string) error {
+	return p.SendContext(context.Background(), to, subject, body, attachmentFilePaths)
+}
+
+// SendContext отправляет электронное письмо одному получателю с учетом отмены и дедлайна ctx
+func (p *PooledSender) SendContext(ctx context.Context, to, subject, body string, attachmentFilePaths []string) error {
+	_, err := p.SendMessage(ctx, newSimpleMessage(to, subject, body, attachmentFilePaths))
+	return err
+}
+
+// SendMessage отправляет письмо через свободное соединение пула, при необходимости открывая новое.
+// Если все соединения заняты, ждет освобождения одного из них или отмены ctx.
+func (p *PooledSender) SendMessage(ctx context.Context, msg *Message) (*Result, error) {
+	recipients := msg.Recipients()
+	if len(recipients) == 0 {
+		return nil, ErrNoRecipients
+	}
+
+	msg, err := p.sender.prepare(msg)
+	if err != nil {
+		return nil, err
+	}
+	root, err := buildMIMETree(msg, false)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-p.slots }()
+
+	pc, err := p.acquire(ctx)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+
+	result, err := p.sender.deliver(pc.client, msg, root)
+	pc.messages++
+	p.release(pc, err)
+
+	return result, contextError(ctx, err)
+}
+
+// Close завершает все свободные соединения; соединения, занятые отправкой, закрываются по ее окончании
+func (p *PooledSender) Close() error {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.mu.Unlock()
+
+	for _, pc := range idle {
+		pc.quit()
+	}
+	return nil
+}
+
+// acquire выдает свободное живое соединение или открывает новое
+func (p *PooledSender) acquire(ctx context.Context) (*pooledConn, error) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		pc := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mu.Unlock()
+
+		// Сервер мог закрыть простаивающее соединение: проверяем его через NOOP
+		pc.conn.bind(ctx)
+		if err := pc.client.Noop(); err != nil {
+			log.Printf("Соединение из пула больше не активно: %v", err)
+			pc.client.Close()
+			continue
+		}
+		return pc, nil
+	}
+
+	client, conn, err := p.sender.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &pooledConn{client: client, conn: conn}, nil
+}
+
+// release возвращает соединение в пул или закрывает его, если оно исчерпало лимит писем
+// или после ошибки протокол мог рассинхронизироваться
+func (p *PooledSender) release(pc *pooledConn, sendErr error) {
+	pc.conn.bind(context.Background())
+
+	// После обрыва или прерванного DATA QUIT попал бы в тело письма, поэтому соединение просто закрываем
+	if !reusableAfter(sendErr) {
+		pc.client.Close()
+		return
+	}
+	if pc.messages >= p.maxMessagesPerConn {
+		pc.quit()
+		return
+	}
+
+	// RSET сбрасывает состояние транзакции перед следующим письмом
+	if err := pc.client.Reset(); err != nil {
+		pc.client.Close()
+		return
+	}
+
+	p.mu.Lock()
+	if !p.closed {
+		p.idle = append(p.idle, pc)
+		p.mu.Unlock()
+		return
+	}
+	p.mu.Unlock()
+
+	pc.quit()
+}
+
+// reusableAfter сообщает, осталось ли соединение в согласованном состоянии после ошибки:
+// это так, только если сервер ответил кодом, а не оборвалась связь или запись DATA
+func reusableAfter(err error) bool {
+	// Слишком большое письмо и неподдерживаемые адреса отклоняются до первой команды транзакции
+	if err == nil || errors.Is(err, ErrRecipientsRejected) || errors.Is(err, ErrMessageTooLarge) || errors.Is(err, ErrSMTPUTF8NotSupported) {
+		return true
+	}
+	var smtpErr *SMTPError
+	return errors.As(err, &smtpErr) && smtpErr.Code != 0
+}
+
+// quit вежливо завершает сессию и закрывает соединение
+func (pc *pooledConn) quit() {
+	if err := pc.client.Quit(); err != nil {
+		pc.client.Close()
+	}
+}
@@ -0,0 +1,109 @@
+package email_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/mclyashko/IPORPIS/internal/email"
+	"github.com/mclyashko/IPORPIS/internal/email/emailtest"
+)
+
+func TestPooledSenderReusesConnections(t *testing.T) {
+	server := emailtest.NewServer(t, emailtest.WithMode(emailtest.ModeStartTLS), emailtest.WithAuth("user@example.com", "secret"))
+	pool := email.NewPooledSender(server.Sender(), email.WithMaxConns(1))
+	defer pool.Close()
+
+	for i := range 5 {
+		if err := pool.Send(fmt.Sprintf("rcpt%d@example.com", i), "subject", "body", nil); err != nil {
+			t.Fatalf("Send %d: %v", i, err)
+		}
+	}
+
+	if n := server.Connections(); n != 1 {
+		t.Errorf("server saw %d connections, want 1", n)
+	}
+	if n := len(server.Messages()); n != 5 {
+		t.Errorf("server received %d messages, want 5", n)
+	}
+}
+
+func TestPooledSenderReconnectsAfterMessageLimit(t *testing.T) {
+	server := emailtest.NewServer(t)
+	pool := email.NewPooledSender(server.Sender(), email.WithMaxConns(1), email.WithMaxMessagesPerConn(2))
+	defer pool.Close()
+
+	for i := range 5 {
+		if err := pool.Send("rcpt@example.com", "subject", "body", nil); err != nil {
+			t.Fatalf("Send %d: %v", i, err)
+		}
+	}
+
+	if n := server.Connections(); n != 3 {
+		t.Errorf("server saw %d connections, want 3", n)
+	}
+}
+
+func TestPooledSenderKeepsConnectionAfterRejection(t *testing.T) {
+	server := emailtest.NewServer(t)
+	server.RejectRecipient("unknown@example.com", emailtest.Reply{Code: 550, Message: "5.1.1 User unknown"})
+	pool := email.NewPooledSender(server.Sender(), email.WithMaxConns(1))
+	defer pool.Close()
+
+	if err := pool.Send("unknown@example.com", "subject", "body", nil); err == nil {
+		t.Fatal("Send to unknown recipient succeeded")
+	}
+	if err := pool.Send("known@example.com", "subject", "body", nil); err != nil {
+		t.Fatalf("Send after rejection: %v", err)
+	}
+
+	if n := server.Connections(); n != 1 {
+		t.Errorf("server saw %d connections, want 1", n)
+	}
+}
+
+func TestPooledSenderConcurrent(t *testing.T) {
+	server := emailtest.NewServer(t)
+	pool := email.NewPooledSender(server.Sender(), email.WithMaxConns(3))
+	defer pool.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- pool.Send(fmt.Sprintf("rcpt%d@example.com", i), "subject", "body", nil)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Send: %v", err)
+		}
+	}
+	if n := len(server.Messages()); n != 20 {
+		t.Errorf("server received %d messages, want 20", n)
+	}
+	if n := server.Connections(); n > 3 {
+		t.Errorf("server saw %d connections, want at most 3", n)
+	}
+}
+
+func TestPooledSenderClosed(t *testing.T) {
+	server := emailtest.NewServer(t)
+	pool := email.NewPooledSender(server.Sender())
+	pool.Close()
+
+	_, err := pool.SendMessage(context.Background(), &email.Message{
+		To:    []email.Address{{Address: "rcpt@example.com"}},
+		Parts: []email.Part{email.TextPart("body")},
+	})
+	if !errors.Is(err, email.ErrPoolClosed) {
+		t.Fatalf("err = %v, want ErrPoolClosed", err)
+	}
+}
@@ -0,0 +1,104 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+)
+
+// ErrRecipientKeyNotFound возвращается, когда письмо нужно зашифровать, а для получателя нет сертификата или ключа
+var ErrRecipientKeyNotFound = errors.New("no encryption key for recipient")
+
+// Protection подписывает и (или) шифрует тело письма целиком: S/MIME или PGP/MIME.
+// Заголовки письма (From, To, Subject и другие) остаются открытыми.
+type Protection interface {
+	// protect получает MIME-дерево тела письма и возвращает защищенное дерево, которое займет его место
+	protect(msg *Message, root *mimePart) (*mimePart, error)
+}
+
+// ProtectedSender защищает письма, для которых не задан Message.Protection, и передает их дальше
+type ProtectedSender struct {
+	next       Sender
+	protection Protection
+}
+
+// NewProtectedSender оборачивает next защитой protection
+func NewProtectedSender(next Sender, protection Protection) *ProtectedSender {
+	return &ProtectedSender{next: next, protection: protection}
+}
+
+// Send отправляет электронное письмо одному получателю
+func (p *ProtectedSender) Send(to, subject, body string, attachmentFilePaths []string) error {
+	return p.SendContext(context.Background(), to, subject, body, attachmentFilePaths)
+}
+
+// SendContext отправляет электронное письмо одному получателю с учетом отмены и дедлайна ctx
+func (p *ProtectedSender) SendContext(ctx context.Context, to, subject, body string, attachmentFilePaths []string) error {
+	_, err := p.SendMessage(ctx, newSimpleMessage(to, subject, body, attachmentFilePaths))
+	return err
+}
+
+// SendMessage отправляет письмо с защитой; защиту, заданную в самом письме, не переопределяет
+func (p *ProtectedSender) SendMessage(ctx context.Context, msg *Message) (*Result, error) {
+	if msg.Protection == nil {
+		protected := *msg
+		protected.Protection = p.protection
+		msg = &protected
+	}
+	return p.next.SendMessage(ctx, msg)
+}
+
+// renderPart записывает часть целиком, с заголовками и телом, как она будет выглядеть внутри письма.
+// Подпись вычисляется именно по этим байтам, поэтому часть дальше передается только в этом виде.
+func renderPart(p *mimePart) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := p.writeHeader(&buf); err != nil {
+		return nil, err
+	}
+	buf.WriteString("\r\n")
+	if err := p.writeBody(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// newSignedMultipart собирает multipart/signed (RFC 1847) из уже записанной подписанной части и части с подписью
+func newSignedMultipart(params map[string]string, signed []byte, signature *mimePart) *mimePart {
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+	params["boundary"] = boundary
+
+	part := &mimePart{
+		header: textproto.MIMEHeader{
+			"Content-Type": {formatContentType("multipart/signed", params)},
+		},
+		boundary: boundary,
+	}
+	// Подписанная часть пишется побайтно: любое изменение переносов или заголовков сломает подпись
+	part.body = func(w io.Writer) error {
+		if _, err := fmt.Fprintf(w, "--%s\r\n", part.boundary); err != nil {
+			return err
+		}
+		if _, err := w.Write(signed); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "\r\n--%s\r\n", part.boundary); err != nil {
+			return err
+		}
+		if err := signature.writeHeader(w); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, "\r\n"); err != nil {
+			return err
+		}
+		if err := signature.writeBody(w); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintf(w, "\r\n--%s--\r\n", part.boundary)
+		return err
+	}
+	return part
+}
@@ -0,0 +1,281 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Quota — не больше Limit писем за любой отрезок времени длиной Period
+type Quota struct {
+	Limit  int
+	Period time.Duration
+}
+
+// PerMinute возвращает квоту n писем в минуту
+func PerMinute(n int) Quota {
+	return Quota{Limit: n, Period: time.Minute}
+}
+
+// PerHour возвращает квоту n писем в час
+func PerHour(n int) Quota {
+	return Quota{Limit: n, Period: time.Hour}
+}
+
+// PerDay возвращает квоту n писем в сутки
+func PerDay(n int) Quota {
+	return Quota{Limit: n, Period: 24 * time.Hour}
+}
+
+// ParseQuotas разбирает список квот через запятую, например "20/m,500/h,2000/d".
+// Период задается суффиксом s, m, h или d либо длительностью в формате time.ParseDuration: "1/5s".
+func ParseQuotas(s string) ([]Quota, error) {
+	var quotas []Quota
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		limitStr, periodStr, ok := strings.Cut(item, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid quota %q: expected LIMIT/PERIOD", item)
+		}
+		limit, err := strconv.Atoi(strings.TrimSpace(limitStr))
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("invalid quota limit in %q", item)
+		}
+
+		var period time.Duration
+		switch periodStr = strings.TrimSpace(periodStr); periodStr {
+		case "s":
+			period = time.Second
+		case "m":
+			period = time.Minute
+		case "h":
+			period = time.Hour
+		case "d":
+			period = 24 * time.Hour
+		default:
+			period, err = time.ParseDuration(periodStr)
+			if err != nil || period <= 0 {
+				return nil, fmt.Errorf("invalid quota period in %q", item)
+			}
+		}
+
+		quotas = append(quotas, Quota{Limit: limit, Period: period})
+	}
+	return quotas, nil
+}
+
+// RateLimitedSender придерживает письма, чтобы не превысить квоты SMTP-аккаунта
+// и квоты на каждый домен получателей. Безопасен для одновременного использования.
+type RateLimitedSender struct {
+	next          Sender
+	accountQuotas []Quota
+	domainQuotas  []Quota
+	jitter        time.Duration
+
+	mu      sync.Mutex
+	account []*slidingWindow
+	domains map[string][]*slidingWindow
+	// domainPeriod — самый длинный период доменных квот: окна, простаивающие дольше, удаляются
+	domainPeriod time.Duration
+	lastEviction time.Time
+
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// RateLimitOption настраивает RateLimitedSender
+type RateLimitOption func(*RateLimitedSender)
+
+// WithAccountQuota добавляет квоты на все письма SMTP-аккаунта
+func WithAccountQuota(quotas ...Quota) RateLimitOption {
+	return func(r *RateLimitedSender) {
+		r.accountQuotas = append(r.accountQuotas, quotas...)
+	}
+}
+
+// WithDomainQuota добавляет квоты, которые действуют отдельно для каждого домена получателей
+func WithDomainQuota(quotas ...Quota) RateLimitOption {
+	return func(r *RateLimitedSender) {
+		r.domainQuotas = append(r.domainQuotas, quotas...)
+	}
+}
+
+// WithRateJitter добавляет перед каждым письмом случайную паузу до jitter,
+// чтобы рассылка не выглядела для провайдера механической
+func WithRateJitter(jitter time.Duration) RateLimitOption {
+	return func(r *RateLimitedSender) {
+		r.jitter = jitter
+	}
+}
+
+// NewRateLimitedSender оборачивает next ограничением скорости отправки
+func NewRateLimitedSender(next Sender, opts ...RateLimitOption) *RateLimitedSender {
+	r := &RateLimitedSender{
+		next:    next,
+		domains: make(map[string][]*slidingWindow),
+		now:     time.Now,
+		sleep:   sleepContext,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	r.account = newWindows(r.accountQuotas)
+	for _, q := range r.domainQuotas {
+		r.domainPeriod = max(r.domainPeriod, q.Period)
+	}
+
+	return r
+}
+
+// Send отправляет электронное письмо одному получателю
+func (r *RateLimitedSender) Send(to, subject, body string, attachmentFilePaths []string) error {
+	return r.SendContext(context.Background(), to, subject, body, attachmentFilePaths)
+}
+
+// SendContext отправляет электронное письмо одному получателю с учетом отмены и дедлайна ctx
+func (r *RateLimitedSender) SendContext(ctx context.Context, to, subject, body string, attachmentFilePaths []string) error {
+	_, err := r.SendMessage(ctx, newSimpleMessage(to, subject, body, attachmentFilePaths))
+	return err
+}
+
+// SendMessage ждет, пока квоты позволят отправить письмо, и передает его дальше
+func (r *RateLimitedSender) SendMessage(ctx context.Context, msg *Message) (*Result, error) {
+	if err := r.wait(ctx, recipientDomains(msg.Recipients())); err != nil {
+		return nil, err
+	}
+	return r.next.SendMessage(ctx, msg)
+}
+
+// wait блокируется до момента, когда письмо укладывается во все квоты, и резервирует его в них
+func (r *RateLimitedSender) wait(ctx context.Context, domains []string) error {
+	if r.jitter > 0 {
+		if err := r.sleep(ctx, rand.N(r.jitter)); err != nil {
+			return err
+		}
+	}
+
+	for {
+		r.mu.Lock()
+		now := r.now()
+		r.evictIdle(now)
+		windows := append([]*slidingWindow(nil), r.account...)
+		// Без доменных квот окна доменов не заводятся вовсе
+		if r.domainPeriod > 0 {
+			for _, domain := range domains {
+				if _, ok := r.domains[domain]; !ok {
+					r.domains[domain] = newWindows(r.domainQuotas)
+				}
+				windows = append(windows, r.domains[domain]...)
+			}
+		}
+
+		allowedAt := now
+		for _, w := range windows {
+			if t := w.nextAllowed(now); t.After(allowedAt) {
+				allowedAt = t
+			}
+		}
+
+		if !allowedAt.After(now) {
+			for _, w := range windows {
+				w.record(now)
+			}
+			r.mu.Unlock()
+			return nil
+		}
+		r.mu.Unlock()
+
+		delay := allowedAt.Sub(now)
+		log.Printf("Достигнут лимит отправки, ждем %s", delay.Round(time.Second))
+		if err := r.sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// evictIdle удаляет окна доменов, в которые ничего не отправлялось дольше самой длинной квоты.
+// Такие окна пусты, и новое окно для домена ведет себя так же, поэтому в долго работающем сервисе
+// карта доменов не растет без предела. Проверка выполняется не чаще раза за этот период.
+func (r *RateLimitedSender) evictIdle(now time.Time) {
+	if r.domainPeriod == 0 || now.Sub(r.lastEviction) < r.domainPeriod {
+		return
+	}
+	r.lastEviction = now
+
+	for domain, windows := range r.domains {
+		idle := true
+		for _, w := range windows {
+			if len(w.sent) > 0 && w.sent[len(w.sent)-1].After(now.Add(-w.quota.Period)) {
+				idle = false
+				break
+			}
+		}
+		if idle {
+			delete(r.domains, domain)
+		}
+	}
+}
+
+// recipientDomains возвращает домены получателей без повторов
+func recipientDomains(recipients []string) []string {
+	seen := make(map[string]bool)
+	var domains []string
+	for _, rcpt := range recipients {
+		at := strings.LastIndex(rcpt, "@")
+		if at < 0 {
+			continue
+		}
+		domain := strings.ToLower(rcpt[at+1:])
+		if !seen[domain] {
+			seen[domain] = true
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+// slidingWindow хранит время последних отправок в пределах одной квоты
+type slidingWindow struct {
+	quota Quota
+	sent  []time.Time
+}
+
+func newWindows(quotas []Quota) []*slidingWindow {
+	windows := make([]*slidingWindow, 0, len(quotas))
+	for _, q := range quotas {
+		if q.Limit > 0 && q.Period > 0 {
+			windows = append(windows, &slidingWindow{quota: q})
+		}
+	}
+	return windows
+}
+
+// nextAllowed возвращает ближайший момент, когда квота позволит еще одну отправку
+func (w *slidingWindow) nextAllowed(now time.Time) time.Time {
+	// Отбрасываем отправки, вышедшие за пределы окна
+	cutoff := now.Add(-w.quota.Period)
+	drop := 0
+	for drop < len(w.sent) && !w.sent[drop].After(cutoff) {
+		drop++
+	}
+	w.sent = w.sent[drop:]
+
+	if len(w.sent) < w.quota.Limit {
+		return now
+	}
+	return w.sent[len(w.sent)-w.quota.Limit].Add(w.quota.Period)
+}
+
+func (w *slidingWindow) record(now time.Time) {
+	w.sent = append(w.sent, now)
+}
@@ -0,0 +1,101 @@
+package email
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// fakeClock — время, которое сдвигается только ожиданием ограничителя
+type fakeClock struct {
+	now   time.Time
+	slept time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Sleep(_ context.Context, d time.Duration) error {
+	c.now = c.now.Add(d)
+	c.slept += d
+	return nil
+}
+
+func newTestRateLimitedSender(clock *fakeClock, opts ...RateLimitOption) *RateLimitedSender {
+	r := NewRateLimitedSender(NewMemorySender("sender@example.com"), opts...)
+	r.now = clock.Now
+	r.sleep = clock.Sleep
+	return r
+}
+
+func TestParseQuotas(t *testing.T) {
+	quotas, err := ParseQuotas("20/m, 500/h,2000/d,1/5s")
+	if err != nil {
+		t.Fatalf("ParseQuotas: %v", err)
+	}
+	want := []Quota{PerMinute(20), PerHour(500), PerDay(2000), {Limit: 1, Period: 5 * time.Second}}
+	if len(quotas) != len(want) {
+		t.Fatalf("quotas = %v, want %v", quotas, want)
+	}
+	for i := range want {
+		if quotas[i] != want[i] {
+			t.Errorf("quotas[%d] = %v, want %v", i, quotas[i], want[i])
+		}
+	}
+
+	for _, s := range []string{"20", "0/m", "20/week"} {
+		if _, err := ParseQuotas(s); err == nil {
+			t.Errorf("ParseQuotas(%q) succeeded", s)
+		}
+	}
+}
+
+func TestRateLimitedSenderWaits(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2023, 11, 13, 7, 0, 0, 0, time.UTC)}
+	sender := newTestRateLimitedSender(clock, WithAccountQuota(Quota{Limit: 2, Period: time.Minute}))
+
+	for range 3 {
+		if err := sender.Send("rcpt@example.com", "subject", "body", nil); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	if clock.slept != time.Minute {
+		t.Errorf("slept %s, want a minute before the third message", clock.slept)
+	}
+}
+
+func TestRateLimitedSenderEvictsIdleDomains(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2023, 11, 13, 7, 0, 0, 0, time.UTC)}
+	sender := newTestRateLimitedSender(clock, WithDomainQuota(PerMinute(10), PerHour(100)))
+
+	for _, rcpt := range []string{"a@one.example", "b@two.example", "c@three.example"} {
+		if err := sender.Send(rcpt, "subject", "body", nil); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	if n := len(sender.domains); n != 3 {
+		t.Fatalf("tracking %d domains, want 3", n)
+	}
+
+	// Через час после последней отправки окна простаивающих доменов удаляются
+	clock.now = clock.now.Add(time.Hour + time.Second)
+	if err := sender.Send("d@four.example", "subject", "body", nil); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if _, ok := sender.domains["four.example"]; !ok || len(sender.domains) != 1 {
+		t.Errorf("domains after an idle hour = %v, want only four.example", sender.domains)
+	}
+}
+
+func TestRateLimitedSenderWithoutDomainQuotas(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2023, 11, 13, 7, 0, 0, 0, time.UTC)}
+	sender := newTestRateLimitedSender(clock, WithAccountQuota(PerMinute(100)))
+
+	if err := sender.Send("rcpt@example.com", "subject", "body", nil); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if n := len(sender.domains); n != 0 {
+		t.Errorf("tracking %d domains without domain quotas", n)
+	}
+}
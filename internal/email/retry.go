@@ -0,0 +1,175 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"time"
+)
+
+// Значения RetryingSender по умолчанию
+const (
+	DefaultMaxAttempts = 3
+	DefaultBaseDelay   = 5 * time.Second
+	DefaultMaxDelay    = 5 * time.Minute
+	DefaultJitter      = 0.5
+)
+
+// RetryPolicy решает, стоит ли повторять отправку после ошибки
+type RetryPolicy func(err error) bool
+
+// RetryTemporary — политика по умолчанию: повторяются только временные ошибки (4xx, обрыв соединения).
+// Частичный отказ получателей не повторяется: письмо уже доставлено остальным. Обрыв после
+// передачи письма (ErrDeliveryUnknown) тоже не повторяется: сервер мог его принять.
+func RetryTemporary(err error) bool {
+	if errors.Is(err, ErrRecipientsRejected) {
+		return false
+	}
+	return IsTemporary(err)
+}
+
+// RetryingSender повторяет отправку через Sender с экспоненциальной задержкой
+type RetryingSender struct {
+	next        Sender
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	jitter      float64
+	policy      RetryPolicy
+	sleep       func(ctx context.Context, d time.Duration) error
+}
+
+// RetryOption настраивает RetryingSender
+type RetryOption func(*RetryingSender)
+
+// WithMaxAttempts задает максимальное число попыток, включая первую
+func WithMaxAttempts(attempts int) RetryOption {
+	return func(r *RetryingSender) {
+		r.maxAttempts = attempts
+	}
+}
+
+// WithBackoff задает задержку перед второй попыткой и верхнюю границу задержки;
+// каждая следующая задержка вдвое больше предыдущей
+func WithBackoff(base, maxDelay time.Duration) RetryOption {
+	return func(r *RetryingSender) {
+		r.baseDelay = base
+		r.maxDelay = maxDelay
+	}
+}
+
+// WithJitter задает долю случайного уменьшения задержки от 0 до 1,
+// чтобы параллельные отправители не повторяли попытки одновременно
+func WithJitter(jitter float64) RetryOption {
+	return func(r *RetryingSender) {
+		r.jitter = min(max(jitter, 0), 1)
+	}
+}
+
+// WithRetryPolicy задает, какие ошибки повторять
+func WithRetryPolicy(policy RetryPolicy) RetryOption {
+	return func(r *RetryingSender) {
+		r.policy = policy
+	}
+}
+
+// NewRetryingSender оборачивает next повторными попытками
+func NewRetryingSender(next Sender, opts ...RetryOption) *RetryingSender {
+	r := &RetryingSender{
+		next:        next,
+		maxAttempts: DefaultMaxAttempts,
+		baseDelay:   DefaultBaseDelay,
+		maxDelay:    DefaultMaxDelay,
+		jitter:      DefaultJitter,
+		policy:      RetryTemporary,
+		sleep:       sleepContext,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	if r.maxAttempts < 1 {
+		r.maxAttempts = 1
+	}
+
+	return r
+}
+
+// Send отправляет электронное письмо одному получателю
+func (r *RetryingSender) Send(to, subject, body string, attachmentFilePaths []string) error {
+	return r.SendContext(context.Background(), to, subject, body, attachmentFilePaths)
+}
+
+// SendContext отправляет электронное письмо одному получателю с учетом отмены и дедлайна ctx
+func (r *RetryingSender) SendContext(ctx context.Context, to, subject, body string, attachmentFilePaths []string) error {
+	_, err := r.SendMessage(ctx, newSimpleMessage(to, subject, body, attachmentFilePaths))
+	return err
+}
+
+// SendMessage отправляет письмо, повторяя попытки по политике; число попыток возвращается в Result.Attempts
+func (r *RetryingSender) SendMessage(ctx context.Context, msg *Message) (*Result, error) {
+	var result *Result
+	var err error
+
+	attempt := 1
+	for ; ; attempt++ {
+		result, err = r.next.SendMessage(ctx, msg)
+		if err == nil || attempt >= r.maxAttempts || !r.policy(err) {
+			break
+		}
+
+		// Повторы уходят с тем же Message-ID: по нему в журналах и уведомлениях о доставке
+		// все попытки относятся к одному письму
+		if msg.MessageID == "" && result != nil && result.MessageID != "" {
+			pinned := *msg
+			pinned.MessageID = result.MessageID
+			msg = &pinned
+		}
+
+		delay := r.delay(attempt)
+		log.Printf("Попытка %d из %d не удалась: %v; повтор через %s", attempt, r.maxAttempts, err, delay)
+		if sleepErr := r.sleep(ctx, delay); sleepErr != nil {
+			err = fmt.Errorf("%w: %w", sleepErr, err)
+			break
+		}
+	}
+
+	if result == nil {
+		result = &Result{}
+	}
+	result.Attempts = attempt
+
+	if err != nil && attempt > 1 {
+		return result, fmt.Errorf("after %d attempts: %w", attempt, err)
+	}
+	return result, err
+}
+
+// delay возвращает задержку после неудачной попытки attempt (начиная с 1)
+func (r *RetryingSender) delay(attempt int) time.Duration {
+	d := r.baseDelay
+	for i := 1; i < attempt && d < r.maxDelay; i++ {
+		d *= 2
+	}
+	d = min(d, r.maxDelay)
+
+	if r.jitter > 0 {
+		d -= time.Duration(rand.Float64() * r.jitter * float64(d))
+	}
+	return d
+}
+
+// sleepContext ждет d или отмены ctx
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,97 @@
+package email_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mclyashko/IPORPIS/internal/email"
+	"github.com/mclyashko/IPORPIS/internal/email/emailtest"
+)
+
+func TestRetryingSenderRetriesTemporaryErrors(t *testing.T) {
+	server := emailtest.NewServer(t)
+	server.Script(email.PhaseDial, emailtest.Reply{Code: 421, Message: "4.3.2 Try again later"})
+	server.Script(email.PhaseData, emailtest.Reply{Code: 451, Message: "4.3.0 Local error"})
+
+	sender := email.NewRetryingSender(server.Sender(), email.WithBackoff(time.Millisecond, time.Millisecond))
+	result, err := sender.SendMessage(context.Background(), &email.Message{
+		To:    []email.Address{{Address: "rcpt@example.com"}},
+		Parts: []email.Part{email.TextPart("body")},
+	})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	if result.Attempts != 3 {
+		t.Errorf("Attempts = %d, want 3", result.Attempts)
+	}
+	if n := len(server.Messages()); n != 1 {
+		t.Errorf("server received %d messages, want 1", n)
+	}
+}
+
+func TestRetryingSenderStopsOnPermanentError(t *testing.T) {
+	server := emailtest.NewServer(t)
+	server.Script(email.PhaseMail, emailtest.Reply{Code: 550, Message: "5.7.1 Rejected"})
+
+	sender := email.NewRetryingSender(server.Sender(), email.WithBackoff(time.Millisecond, time.Millisecond))
+	result, err := sender.SendMessage(context.Background(), &email.Message{
+		To:    []email.Address{{Address: "rcpt@example.com"}},
+		Parts: []email.Part{email.TextPart("body")},
+	})
+	if !email.IsPermanent(err) {
+		t.Fatalf("err = %v, want permanent error", err)
+	}
+	if result.Attempts != 1 {
+		t.Errorf("Attempts = %d, want 1", result.Attempts)
+	}
+	if n := server.Connections(); n != 1 {
+		t.Errorf("server saw %d connections, want 1", n)
+	}
+}
+
+func TestRetryingSenderGivesUp(t *testing.T) {
+	server := emailtest.NewServer(t)
+	for range 3 {
+		server.Script(email.PhaseRcpt, emailtest.Reply{Code: 450, Message: "4.2.0 Mailbox busy"})
+	}
+
+	sender := email.NewRetryingSender(server.Sender(),
+		email.WithMaxAttempts(3),
+		email.WithBackoff(time.Millisecond, time.Millisecond),
+	)
+	err := sender.Send("rcpt@example.com", "subject", "body", nil)
+
+	var smtpErr *email.SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 450 {
+		t.Fatalf("err = %v, want the last 450 reply", err)
+	}
+	if n := server.Connections(); n != 3 {
+		t.Errorf("server saw %d connections, want 3", n)
+	}
+}
+
+func TestRetryingSenderConnectionLostAfterData(t *testing.T) {
+	server := emailtest.NewServer(t)
+	// Сервер принимает письмо и обрывает соединение, не ответив на завершающую точку
+	server.Script(email.PhaseData, emailtest.Reply{Drop: true})
+
+	sender := email.NewRetryingSender(server.Sender(), email.WithBackoff(time.Millisecond, time.Millisecond))
+	result, err := sender.SendMessage(context.Background(), &email.Message{
+		To:    []email.Address{{Address: "rcpt@example.com"}},
+		Parts: []email.Part{email.TextPart("body")},
+	})
+	if !errors.Is(err, email.ErrDeliveryUnknown) {
+		t.Fatalf("err = %v, want ErrDeliveryUnknown", err)
+	}
+	if email.IsTemporary(err) || email.IsPermanent(err) {
+		t.Errorf("unknown delivery classified as temporary = %v, permanent = %v", email.IsTemporary(err), email.IsPermanent(err))
+	}
+	if result.Attempts != 1 {
+		t.Errorf("Attempts = %d, want 1", result.Attempts)
+	}
+	if n := len(server.Messages()); n != 1 {
+		t.Errorf("server received %d copies of the message, want 1", n)
+	}
+}
@@ -0,0 +1,73 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Security определяет режим защиты соединения с SMTP-сервером
+type Security int
+
+const (
+	// SecurityTLS — неявный TLS: соединение шифруется сразу после подключения (обычно порт 465)
+	SecurityTLS Security = iota
+	// SecurityStartTLS — обязательный STARTTLS: без поддержки расширения сервером письмо не отправляется (обычно порт 587)
+	SecurityStartTLS
+	// SecurityStartTLSOpportunistic — STARTTLS, если сервер его предлагает, иначе открытое соединение
+	SecurityStartTLSOpportunistic
+	// SecurityNone — открытое соединение без шифрования (локальные релеи и тестовые серверы)
+	SecurityNone
+)
+
+// ErrStartTLSNotSupported возвращается, когда STARTTLS обязателен, но сервер его не предлагает
+var ErrStartTLSNotSupported = errors.New("SMTP server does not support STARTTLS")
+
+// String возвращает название режима в том же виде, в котором он задается в конфигурации
+func (s Security) String() string {
+	switch s {
+	case SecurityTLS:
+		return "tls"
+	case SecurityStartTLS:
+		return "starttls"
+	case SecurityStartTLSOpportunistic:
+		return "starttls-opportunistic"
+	case SecurityNone:
+		return "none"
+	default:
+		return fmt.Sprintf("Security(%d)", int(s))
+	}
+}
+
+// DefaultPort возвращает стандартный порт для режима защиты
+func (s Security) DefaultPort() string {
+	switch s {
+	case SecurityTLS:
+		return "465"
+	case SecurityStartTLS, SecurityStartTLSOpportunistic:
+		return "587"
+	default:
+		return "25"
+	}
+}
+
+// Securities возвращает все поддерживаемые режимы защиты
+func Securities() []Security {
+	return []Security{SecurityTLS, SecurityStartTLS, SecurityStartTLSOpportunistic, SecurityNone}
+}
+
+// ParseSecurity разбирает название режима защиты; пустая строка означает неявный TLS
+func ParseSecurity(s string) (Security, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "tls", "ssl":
+		return SecurityTLS, nil
+	case "starttls":
+		return SecurityStartTLS, nil
+	case "starttls-opportunistic", "opportunistic":
+		return SecurityStartTLSOpportunistic, nil
+	case "none", "plain":
+		return SecurityNone, nil
+	default:
+		return 0, fmt.Errorf("unknown SMTP security mode %q", s)
+	}
+}
@@ -2,23 +2,26 @@ package email
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"mime"
-	"mime/multipart"
+	"net"
 	"net/smtp"
 	"net/textproto"
-	"os"
-	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 )
 
 // Sender определяет интерфейс для отправки электронной почты
 type Sender interface {
 	Send(to, subject, body string, attachmentFilePaths []string) error
+	// SendContext — то же, что Send, но с отменой и дедлайном из ctx
+	SendContext(ctx context.Context, to, subject, body string, attachmentFilePaths []string) error
+	SendMessage(ctx context.Context, msg *Message) (*Result, error)
 }
 
 // SMTPSender реализует интерфейс Sender и отправляет почту через SMTP
@@ -27,154 +30,592 @@ type SMTPSender struct {
 	port     string
 	username string
 	password string
+	security Security
+	auth     Authenticator
+	timeout  time.Duration
+	tls      *tls.Config
+	dkim     *DKIMSigner
+	// from, sender и returnPath — адреса по умолчанию для From, Sender и MAIL FROM
+	from        Address
+	sender      Address
+	returnPath  string
+	alignedFrom bool
+}
+
+// Option настраивает SMTPSender при создании
+type Option func(*SMTPSender)
+
+// WithSecurity задает режим защиты соединения (по умолчанию неявный TLS)
+func WithSecurity(security Security) Option {
+	return func(s *SMTPSender) {
+		s.security = security
+	}
+}
+
+// WithAuth задает способ аутентификации (по умолчанию логин и пароль через PLAIN, LOGIN или CRAM-MD5)
+func WithAuth(auth Authenticator) Option {
+	return func(s *SMTPSender) {
+		s.auth = auth
+	}
+}
+
+// WithTimeout задает тайм-аут подключения и каждой операции с сервером (по умолчанию DefaultTimeout)
+func WithTimeout(timeout time.Duration) Option {
+	return func(s *SMTPSender) {
+		s.timeout = timeout
+	}
+}
+
+// WithTLSConfig задает настройки TLS для неявного TLS и STARTTLS; если ServerName не указан, берется адрес сервера
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(s *SMTPSender) {
+		s.tls = cfg
+	}
+}
+
+// WithDKIM подписывает каждое письмо DKIM-подписью signer
+func WithDKIM(signer *DKIMSigner) Option {
+	return func(s *SMTPSender) {
+		s.dkim = signer
+	}
+}
+
+// WithFrom задает автора писем по умолчанию, например Address{Name: "Отдел продаж", Address: "sales@example.com"}.
+// Если адрес пустой, используется логин SMTP-аккаунта с указанным именем.
+func WithFrom(from Address) Option {
+	return func(s *SMTPSender) {
+		s.from = from
+	}
+}
+
+// WithSenderHeader задает заголовок Sender для писем, в которых он не указан
+func WithSenderHeader(sender Address) Option {
+	return func(s *SMTPSender) {
+		s.sender = sender
+	}
+}
+
+// WithReturnPath задает адрес конверта MAIL FROM для уведомлений о недоставке вместо логина SMTP-аккаунта
+func WithReturnPath(address string) Option {
+	return func(s *SMTPSender) {
+		s.returnPath = address
+	}
+}
+
+// WithAlignedFrom требует, чтобы адреса From и MAIL FROM совпадали с логином SMTP-аккаунта.
+// Многие провайдеры отклоняют письма от чужого имени уже после DATA; с этой настройкой
+// такое письмо отклоняется до подключения с ErrFromNotAligned.
+func WithAlignedFrom(required bool) Option {
+	return func(s *SMTPSender) {
+		s.alignedFrom = required
+	}
 }
 
 // NewSmtpEmailSender создает новый экземпляр SmtpEmailSender
-func NewSMTPSender(host, port, username, password string) (*SMTPSender, error) {
-	return &SMTPSender{
+func NewSMTPSender(host, port, username, password string, opts ...Option) (*SMTPSender, error) {
+	s := &SMTPSender{
 		host:     host,
 		port:     port,
 		username: username,
 		password: password,
-	}, nil
+		security: SecurityTLS,
+		auth:     PasswordAuth(username, password),
+		timeout:  DefaultTimeout,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.port == "" {
+		s.port = s.security.DefaultPort()
+	}
+
+	return s, nil
 }
 
-// Send отправляет электронное письмо
+// Send отправляет электронное письмо одному получателю
 func (s *SMTPSender) Send(to, subject, body string, attachmentFilePaths []string) error {
+	return s.SendContext(context.Background(), to, subject, body, attachmentFilePaths)
+}
+
+// SendContext отправляет электронное письмо одному получателю с учетом отмены и дедлайна ctx
+func (s *SMTPSender) SendContext(ctx context.Context, to, subject, body string, attachmentFilePaths []string) error {
+	_, err := s.SendMessage(ctx, newSimpleMessage(to, subject, body, attachmentFilePaths))
+	return err
+}
+
+// SendMessage отправляет письмо всем получателям из To, Cc и Bcc.
+// Если сервер отклонил часть получателей, письмо уходит остальным, а ошибка оборачивает ErrRecipientsRejected.
+// Отмена ctx прерывает отправку на любом этапе: подключение, TLS, AUTH, RCPT или DATA.
+func (s *SMTPSender) SendMessage(ctx context.Context, msg *Message) (*Result, error) {
+	result, err := s.sendMessage(ctx, msg)
+	return result, contextError(ctx, err)
+}
+
+func (s *SMTPSender) sendMessage(ctx context.Context, msg *Message) (*Result, error) {
 	log.Println("Начинаем отправку письма...")
 
-	conn, err := tls.Dial("tcp", fmt.Sprintf("%s:%s", s.host, s.port), nil)
+	recipients := msg.Recipients()
+	if len(recipients) == 0 {
+		return nil, ErrNoRecipients
+	}
+
+	// Заголовки и структуру письма проверяем до подключения, чтобы ошибки в письме не тратили SMTP-сессию
+	msg, err := s.prepare(msg)
+	if err != nil {
+		return nil, err
+	}
+	root, err := buildMIMETree(msg, false)
+	if err != nil {
+		return nil, err
+	}
+
+	client, _, err := s.connect(ctx)
 	if err != nil {
-		return fmt.Errorf("error connecting to SMTP server: %v", err)
+		return nil, err
 	}
+	defer client.Close()
 
-	client, err := smtp.NewClient(conn, s.host)
+	result, err := s.deliver(client, msg, root)
 	if err != nil {
-		return fmt.Errorf("error creating SMTP client: %v", err)
+		return result, err
 	}
 
-	auth := smtp.PlainAuth("", s.username, s.password, s.host)
-	if err := client.Auth(auth); err != nil {
-		return fmt.Errorf("error authenticating SMTP: %v", err)
+	if err := client.Quit(); err != nil {
+		log.Printf("Ошибка при закрытии SMTP-соединения: %v", err)
+	}
+
+	return result, nil
+}
+
+// connect подключается к серверу, проходит аутентификацию и проверяет соединение
+func (s *SMTPSender) connect(ctx context.Context) (*smtp.Client, *ctxConn, error) {
+	client, conn, err := s.dial(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := s.authenticate(client); err != nil {
+		client.Close()
+		return nil, nil, err
 	}
 
 	// Проверяем соединение
 	if err := client.Noop(); err != nil {
-		return fmt.Errorf("error checking SMTP connection: %v", err)
+		client.Close()
+		return nil, nil, newSMTPError(PhaseDial, err)
 	}
 	log.Println("Соединение с SMTP активно")
 
-	// Указываем отправителя
-	if err := client.Mail(s.username); err != nil {
-		return fmt.Errorf("error setting sender in SMTP client: %v", err)
+	return client, conn, nil
+}
+
+// deliver выполняет одну почтовую транзакцию MAIL, RCPT и DATA на уже подключенном клиенте.
+// root — MIME-дерево письма в 7-битном виде; если сервер объявил 8BITMIME, текст пересобирается в 8bit.
+// Если сервер отклонил часть получателей, письмо уходит остальным, а ошибка оборачивает ErrRecipientsRejected.
+func (s *SMTPSender) deliver(client *smtp.Client, msg *Message, root *mimePart) (*Result, error) {
+	ext := readExtensions(client)
+
+	msg, smtpUTF8, err := internationalize(msg, ext.smtpUTF8)
+	if err != nil {
+		return nil, err
+	}
+	eightBit := ext.eightBitMIME && msg.Protection == nil
+	if eightBit {
+		if root, err = buildMIMETree(msg, true); err != nil {
+			return nil, err
+		}
+	}
+	payload, err := s.payload(msg, root)
+	if err != nil {
+		return nil, err
+	}
+
+	var params []string
+	if ext.size > 0 && s.measurable(msg) {
+		// Размер узнаем, записав письмо вхолостую: так слишком большое письмо отклоняется до MAIL,
+		// а не обрывается сервером после передачи всего DATA
+		counter := &countingWriter{}
+		if err := payload(counter); err != nil {
+			return nil, err
+		}
+		if counter.n > ext.size {
+			return nil, newSMTPError(PhaseMail, fmt.Errorf("%w: message is %d bytes, server accepts %d", ErrMessageTooLarge, counter.n, ext.size))
+		}
+		params = append(params, fmt.Sprintf("SIZE=%d", counter.n))
+	}
+	if eightBit {
+		params = append(params, "BODY=8BITMIME")
+	}
+	if smtpUTF8 {
+		params = append(params, "SMTPUTF8")
+	}
+
+	// Запрос уведомлений о доставке передаем, только если сервер его понимает: иначе он отклонит команды
+	dsn := msg.DSN != nil && ext.dsn
+	if msg.DSN != nil && !ext.dsn {
+		log.Println("Сервер не поддерживает DSN, уведомления о доставке не запрошены")
+	}
+	if dsn {
+		params = append(params, msg.DSN.mailParams(msg.MessageID)...)
+	}
+
+	// Указываем отправителя конверта и получателей: каждому свой RCPT TO
+	recipients := msg.Recipients()
+	rcpts := make([]string, 0, len(recipients))
+	for _, rcpt := range recipients {
+		var rcptParams []string
+		if dsn {
+			rcptParams = msg.DSN.rcptParams(rcpt)
+		}
+		rcpts = append(rcpts, pathCommand("RCPT TO", rcpt, rcptParams))
+	}
+	replies, err := sendEnvelope(client.Text, ext.pipelining, pathCommand("MAIL FROM", msg.ReturnPath, params), rcpts)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Отправитель установлен:", msg.ReturnPath)
+
+	result := &Result{MessageID: msg.MessageID, Attempts: 1, DSN: dsn}
+	for i, rcpt := range recipients {
+		status := RecipientStatus{Address: rcpt}
+		if replies[i] != nil {
+			rcptErr := newSMTPError(PhaseRcpt, replies[i])
+			rcptErr.Recipient = rcpt
+			status.Err = rcptErr
+		}
+		result.Recipients = append(result.Recipients, status)
+		if status.Err != nil {
+			log.Printf("Получатель %s отклонен: %v", rcpt, status.Err)
+			continue
+		}
+		log.Println("Получатель установлен:", rcpt)
 	}
-	log.Println("Отправитель установлен:", s.username)
 
-	// Указываем получателя
-	if err := client.Rcpt(to); err != nil {
-		return fmt.Errorf("error setting recipient in SMTP client: %v", err)
+	rejected := result.Rejected()
+	if len(rejected) == len(recipients) {
+		return result, rejectedError(rejected)
 	}
-	log.Println("Получатель установлен:", to)
 
 	// Получаем writer для сообщения
 	w, err := client.Data()
 	if err != nil {
-		return fmt.Errorf("error getting SMTP writer: %v", err)
+		return result, newSMTPError(PhaseData, err)
 	}
-	defer w.Close() // Закрываем writer после завершения функции
 
-	// Формируем сообщение
-	message, err := s.createMessage(to, subject, body, attachmentFilePaths)
+	// Пишем письмо прямо в поток DATA, кодируя вложения на лету.
+	// При ошибке writer не закрываем: незавершенный DATA вместе с закрытым соединением
+	// заставит сервер отбросить письмо, а не доставить его обрезанным.
+	dw := &dataWriter{w: w}
+	if err := payload(dw); err != nil {
+		if dw.err == nil {
+			// Письмо не удалось подготовить, например прочитать вложение: с соединением все в порядке,
+			// и повтор той же отправки ничего не исправит
+			return result, fmt.Errorf("write message: %w", err)
+		}
+		return result, newSMTPError(PhaseData, err)
+	}
+	log.Println("Сообщение записано")
+
+	// Закрытие writer завершает DATA, и сервер отвечает, принял ли он письмо
+	if err := w.Close(); err != nil {
+		smtpErr := newSMTPError(PhaseData, err)
+		if smtpErr.Code == 0 {
+			// Завершающая точка могла дойти до сервера, и он принял письмо, не успев ответить
+			smtpErr.Err = fmt.Errorf("%w: %w", ErrDeliveryUnknown, err)
+		}
+		return result, smtpErr
+	}
+
+	log.Println("Письмо отправлено!")
+
+	if len(rejected) > 0 {
+		return result, fmt.Errorf("%w: %w", ErrRecipientsRejected, rejectedError(rejected))
+	}
+
+	return result, nil
+}
+
+// rejectedError объединяет ошибки отклоненных получателей
+func rejectedError(rejected []RecipientStatus) error {
+	errs := make([]error, 0, len(rejected))
+	for _, rcpt := range rejected {
+		errs = append(errs, rcpt.Err)
+	}
+	return errors.Join(errs...)
+}
+
+// dial подключается к серверу в соответствии с режимом защиты и возвращает готовый к AUTH клиент.
+// Соединение остается привязанным к ctx, пока его не перепривяжут через bind или не закроют.
+func (s *SMTPSender) dial(ctx context.Context) (*smtp.Client, *ctxConn, error) {
+	addr := net.JoinHostPort(s.host, s.port)
+
+	dialer := &net.Dialer{Timeout: s.timeout}
+	raw, err := dialer.DialContext(ctx, "tcp", addr)
 	if err != nil {
-		return err
+		return nil, nil, newSMTPError(PhaseDial, err)
+	}
+
+	// TLS должен быть внешним слоем: smtp.Client определяет шифрование по типу *tls.Conn
+	cc := newCtxConn(ctx, raw, s.timeout)
+	var conn net.Conn = cc
+	if s.security == SecurityTLS {
+		tlsConn := tls.Client(conn, s.tlsConfig())
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, nil, newSMTPError(PhaseTLS, err)
+		}
+		conn = tlsConn
 	}
-	log.Println("Сообщение создано")
 
-	// Записываем сообщение
-	_, err = w.Write([]byte(message))
+	client, err := smtp.NewClient(conn, s.host)
 	if err != nil {
-		return fmt.Errorf("error writing data to SMTP writer: %v", err)
+		conn.Close()
+		return nil, nil, newSMTPError(PhaseDial, err)
 	}
 
-	if quitErr := client.Quit(); quitErr != nil && !strings.Contains(quitErr.Error(), "250") {
-		return fmt.Errorf("error closing SMTP client: %v", quitErr)
+	if err := s.startTLS(client); err != nil {
+		client.Close()
+		return nil, nil, err
 	}
 
-	log.Println("Письмо отправлено!")
+	return client, cc, nil
+}
+
+// startTLS переводит открытое соединение в TLS, если этого требует режим защиты
+func (s *SMTPSender) startTLS(client *smtp.Client) error {
+	if s.security != SecurityStartTLS && s.security != SecurityStartTLSOpportunistic {
+		return nil
+	}
+
+	// Extension сам отправляет EHLO и разбирает список расширений сервера
+	ok, _ := client.Extension("STARTTLS")
+	if !ok {
+		if s.security == SecurityStartTLS {
+			return newSMTPError(PhaseTLS, ErrStartTLSNotSupported)
+		}
+		log.Println("Сервер не поддерживает STARTTLS, продолжаем без шифрования")
+		return nil
+	}
+
+	if err := client.StartTLS(s.tlsConfig()); err != nil {
+		return newSMTPError(PhaseTLS, err)
+	}
+	log.Println("Соединение переведено в TLS через STARTTLS")
 
 	return nil
 }
 
-func (s *SMTPSender) createMessage(to, subject, body string, attachmentFilePaths []string) (string, error) {
-	var msg bytes.Buffer
-	writer := multipart.NewWriter(&msg)
+// tlsConfig возвращает настройки TLS для подключения к серверу
+func (s *SMTPSender) tlsConfig() *tls.Config {
+	if s.tls == nil {
+		return &tls.Config{ServerName: s.host}
+	}
 
-	// Заголовки письма
-	headers := fmt.Sprintf(
-		"From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=%q\r\n\r\n",
-		s.username, to, subject, writer.Boundary(),
-	)
-	msg.WriteString(headers)
+	cfg := s.tls.Clone()
+	if cfg.ServerName == "" {
+		cfg.ServerName = s.host
+	}
+	return cfg
+}
 
-	// Основное тело письма
-	if err := addTextPart(writer, body); err != nil {
-		return "", err
+// authenticate выполняет AUTH механизмом, выбранным по списку, который объявил сервер
+func (s *SMTPSender) authenticate(client *smtp.Client) error {
+	ok, params := client.Extension("AUTH")
+	var advertised []Mechanism
+	if ok {
+		advertised = parseMechanisms(params)
 	}
 
-	// Вложения
-	for _, attachment := range attachmentFilePaths {
-		if err := addFileAttachment(writer, attachment); err != nil {
-			return "", err
-		}
+	// По контракту Authenticator nil означает, что AUTH не нужен: тогда сервер без AUTH подходит
+	auth, err := s.auth.Authenticate(s.host, advertised)
+	switch {
+	case !ok && (err != nil || auth != nil):
+		return newSMTPError(PhaseAuth, ErrAuthNotSupported)
+	case err != nil:
+		return newSMTPError(PhaseAuth, err)
+	case auth == nil:
+		return nil
 	}
 
-	// Завершаем сообщение
-	writer.Close()
+	if err := client.Auth(auth); err != nil {
+		return newSMTPError(PhaseAuth, err)
+	}
 
-	return msg.String(), nil
+	return nil
 }
 
-func addTextPart(w *multipart.Writer, body string) error {
-	part, err := w.CreatePart(textproto.MIMEHeader{
-		"Content-Type":              {"text/plain; charset=UTF-8"},
-		"Content-Transfer-Encoding": {"7bit"},
-	})
+// WriteMessage записывает письмо в формате RFC 5322 в произвольный writer, не собирая его в памяти
+func (s *SMTPSender) WriteMessage(w io.Writer, msg *Message) error {
+	msg, err := s.prepare(msg)
+	if err != nil {
+		return err
+	}
+	root, err := buildMIMETree(msg, false)
 	if err != nil {
-		return fmt.Errorf("error creating text part: %v", err)
+		return err
 	}
-	_, err = part.Write([]byte(body))
-	return err
+	payload, err := s.payload(msg, root)
+	if err != nil {
+		return err
+	}
+	return payload(w)
 }
 
-func addFileAttachment(w *multipart.Writer, filename string) error {
-	file, err := os.Open(filename)
+// prepare подставляет в письмо отправителя по умолчанию, проверяет заголовки
+// и, если включен WithAlignedFrom, совпадение From и MAIL FROM с логином аккаунта
+func (s *SMTPSender) prepare(msg *Message) (*Message, error) {
+	from := s.from
+	if from.Address == "" {
+		from.Address = s.username
+	}
+	// Настройки отправителя подставляются в копию, чтобы проверяться вместе с остальными заголовками
+	withDefaults := *msg
+	if withDefaults.Sender.Address == "" {
+		withDefaults.Sender = s.sender
+	}
+	if withDefaults.ReturnPath == "" {
+		withDefaults.ReturnPath = s.returnPath
+	}
+	if withDefaults.ReturnPath == "" {
+		withDefaults.ReturnPath = s.username
+	}
+
+	prepared, err := prepareMessage(&withDefaults, from)
 	if err != nil {
-		return fmt.Errorf("error opening attachment file %s: %v", filename, err)
+		return nil, err
+	}
+
+	if s.alignedFrom {
+		if !strings.EqualFold(prepared.From.Address, s.username) {
+			return nil, fmt.Errorf("%w: From is %s, account is %s", ErrFromNotAligned, prepared.From.Address, s.username)
+		}
+		if !strings.EqualFold(prepared.ReturnPath, s.username) {
+			return nil, fmt.Errorf("%w: return path is %s, account is %s", ErrFromNotAligned, prepared.ReturnPath, s.username)
+		}
 	}
-	defer file.Close()
+	return prepared, nil
+}
 
-	mimeType := mime.TypeByExtension(filepath.Ext(filename))
-	if mimeType == "" {
-		mimeType = "application/octet-stream"
+// measurable сообщает, можно ли записать письмо вхолостую, чтобы узнать его размер:
+// с DKIM письмо уже собрано в памяти, а вложение из io.Reader можно прочитать только один раз
+func (s *SMTPSender) measurable(msg *Message) bool {
+	if s.dkim != nil || msg.Protection != nil {
+		return true
 	}
+	for _, a := range msg.Attachments {
+		if _, ok := a.source().(*readerSource); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// countingWriter считает записанные байты, отбрасывая сами данные
+type countingWriter struct {
+	n int64
+}
 
-	// Создаем заголовки для вложения
-	part, err := w.CreatePart(textproto.MIMEHeader{
-		"Content-Disposition":       {fmt.Sprintf("attachment; filename=\"%s\"", filepath.Base(filename))},
-		"Content-Type":              {fmt.Sprintf("%s; name=\"%s\"", mimeType, filepath.Base(filename))},
-		"Content-Transfer-Encoding": {"base64"},
-	})
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// dataWriter запоминает ошибку записи в поток DATA, чтобы отличить обрыв соединения от ошибки подготовки письма
+type dataWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (d *dataWriter) Write(p []byte) (int, error) {
+	n, err := d.w.Write(p)
 	if err != nil {
-		return fmt.Errorf("error creating attachment part: %v", err)
+		d.err = err
 	}
+	return n, err
+}
 
-	// Кодируем файл в base64
-	encoder := base64.NewEncoder(base64.StdEncoding, part)
-	defer encoder.Close()
+// payload возвращает функцию, которая пишет письмо в поток DATA.
+// Без DKIM письмо пишется потоково. С DKIM оно собирается в памяти и подписывается заранее:
+// подпись охватывает письмо целиком, а ошибка подписи не должна обрывать уже начатый DATA.
+func (s *SMTPSender) payload(msg *Message, root *mimePart) (func(io.Writer) error, error) {
+	if s.dkim == nil {
+		return func(w io.Writer) error {
+			return writeMessage(w, msg, root)
+		}, nil
+	}
 
-	if _, err = io.Copy(encoder, file); err != nil {
-		return fmt.Errorf("error encoding file content: %v", err)
+	var buf bytes.Buffer
+	if err := writeMessage(&buf, msg, root); err != nil {
+		return nil, err
+	}
+	signature, err := s.dkim.Sign(buf.Bytes())
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	return func(w io.Writer) error {
+		if _, err := io.WriteString(w, signature); err != nil {
+			return err
+		}
+		_, err := w.Write(buf.Bytes())
+		return err
+	}, nil
+}
+
+// writeMessage записывает заголовки письма, подготовленного prepareMessage, и MIME-дерево root в w
+func writeMessage(w io.Writer, msg *Message, root *mimePart) error {
+	// Заголовки письма; Bcc намеренно не попадает в заголовки
+	header := &bytes.Buffer{}
+	header.WriteString(formatHeader("Date", msg.Date.Format(time.RFC1123Z)))
+	header.WriteString(formatHeader("Message-ID", msg.MessageID))
+	header.WriteString(formatHeader("From", msg.From.String()))
+	if msg.Sender.Address != "" {
+		header.WriteString(formatHeader("Sender", msg.Sender.String()))
+	}
+	writeAddressHeader(header, "To", msg.To)
+	writeAddressHeader(header, "Cc", msg.Cc)
+	writeAddressHeader(header, "Reply-To", msg.ReplyTo)
+	header.WriteString(formatHeader("Subject", encodeHeaderValue(msg.Subject)))
+	if msg.Unsubscribe != nil {
+		header.WriteString(formatHeader("List-Unsubscribe", msg.Unsubscribe.headerValue()))
+		if msg.Unsubscribe.OneClick {
+			header.WriteString("List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n")
+		}
+	}
+
+	keys := make([]string, 0, len(msg.Headers))
+	for key := range msg.Headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		header.WriteString(formatHeader(textproto.CanonicalMIMEHeaderKey(key), encodeHeaderValue(msg.Headers[key])))
+	}
+
+	// Заголовки корневой MIME-части становятся заголовками письма
+	header.WriteString("MIME-Version: 1.0\r\n")
+	if err := root.writeHeader(header); err != nil {
+		return err
+	}
+	header.WriteString("\r\n")
+
+	if _, err := header.WriteTo(w); err != nil {
+		return err
+	}
+
+	return root.writeBody(w)
+}
+
+// writeAddressHeader записывает заголовок со списком адресов, если список не пуст
+func writeAddressHeader(buf *bytes.Buffer, key string, addrs []Address) {
+	if len(addrs) == 0 {
+		return
+	}
+
+	formatted := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		formatted = append(formatted, addr.String())
+	}
+	buf.WriteString(formatHeader(key, strings.Join(formatted, ", ")))
 }
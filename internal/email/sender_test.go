@@ -0,0 +1,312 @@
+package email_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"testing/iotest"
+
+	"github.com/mclyashko/IPORPIS/internal/email"
+	"github.com/mclyashko/IPORPIS/internal/email/emailtest"
+)
+
+func TestSMTPSenderModes(t *testing.T) {
+	modes := map[string]emailtest.Mode{
+		"plain":    emailtest.ModePlain,
+		"starttls": emailtest.ModeStartTLS,
+		"tls":      emailtest.ModeTLS,
+	}
+
+	for name, mode := range modes {
+		t.Run(name, func(t *testing.T) {
+			server := emailtest.NewServer(t, emailtest.WithMode(mode), emailtest.WithAuth("user@example.com", "secret"))
+			sender := server.Sender()
+
+			msg := &email.Message{
+				To:      []email.Address{{Name: "Получатель", Address: "rcpt@example.com"}},
+				Subject: "Тема письма",
+				Parts: []email.Part{
+					email.TextPart("Привет!"),
+					email.HTMLPart("<p>Привет!</p>"),
+				},
+				Attachments: []email.Attachment{email.NewAttachment("отчет.txt", []byte("содержимое"))},
+			}
+			result, err := sender.SendMessage(context.Background(), msg)
+			if err != nil {
+				t.Fatalf("SendMessage: %v", err)
+			}
+			if got := result.Accepted(); len(got) != 1 || got[0] != "rcpt@example.com" {
+				t.Errorf("Accepted() = %v, want [rcpt@example.com]", got)
+			}
+
+			messages := server.Messages()
+			if len(messages) != 1 {
+				t.Fatalf("server received %d messages, want 1", len(messages))
+			}
+			got := messages[0]
+			if got.ParseErr != nil {
+				t.Fatalf("parsing received message: %v", got.ParseErr)
+			}
+			if got.TLS != (mode != emailtest.ModePlain) {
+				t.Errorf("TLS = %v for mode %s", got.TLS, name)
+			}
+			if got.AuthUser != "user@example.com" {
+				t.Errorf("AuthUser = %q, want user@example.com", got.AuthUser)
+			}
+			if got.From != "user@example.com" {
+				t.Errorf("envelope From = %q, want user@example.com", got.From)
+			}
+			if got.Subject() != "Тема письма" {
+				t.Errorf("Subject() = %q", got.Subject())
+			}
+			if got.Text() != "Привет!" {
+				t.Errorf("Text() = %q", got.Text())
+			}
+			if got.HTML() != "<p>Привет!</p>" {
+				t.Errorf("HTML() = %q", got.HTML())
+			}
+
+			attachments := got.Attachments()
+			if len(attachments) != 1 {
+				t.Fatalf("got %d attachments, want 1", len(attachments))
+			}
+			if attachments[0].Filename != "отчет.txt" || string(attachments[0].Body) != "содержимое" {
+				t.Errorf("attachment = %q %q", attachments[0].Filename, attachments[0].Body)
+			}
+		})
+	}
+}
+
+func TestSMTPSenderBccNotInHeaders(t *testing.T) {
+	server := emailtest.NewServer(t)
+
+	msg := &email.Message{
+		To:      []email.Address{{Address: "to@example.com"}},
+		Cc:      []email.Address{{Address: "cc@example.com"}},
+		Bcc:     []email.Address{{Address: "bcc@example.com"}, {Address: "TO@example.com"}},
+		Subject: "bcc",
+		Parts:   []email.Part{email.TextPart("body")},
+	}
+	if _, err := server.Sender().SendMessage(context.Background(), msg); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	got := server.Messages()[0]
+	want := []string{"to@example.com", "cc@example.com", "bcc@example.com"}
+	if strings.Join(got.Recipients, ",") != strings.Join(want, ",") {
+		t.Errorf("envelope recipients = %v, want %v", got.Recipients, want)
+	}
+	if strings.Contains(string(got.Data), "bcc@example.com") {
+		t.Errorf("Bcc address leaked into message:\n%s", got.Data)
+	}
+}
+
+func TestSMTPSenderPartiallyRejected(t *testing.T) {
+	server := emailtest.NewServer(t)
+	server.RejectRecipient("unknown@example.com", emailtest.Reply{Code: 550, Message: "5.1.1 User unknown"})
+
+	msg := &email.Message{
+		To:    []email.Address{{Address: "unknown@example.com"}, {Address: "known@example.com"}},
+		Parts: []email.Part{email.TextPart("body")},
+	}
+	result, err := server.Sender().SendMessage(context.Background(), msg)
+	if !errors.Is(err, email.ErrRecipientsRejected) {
+		t.Fatalf("err = %v, want ErrRecipientsRejected", err)
+	}
+
+	rejected := result.Rejected()
+	if len(rejected) != 1 || rejected[0].Address != "unknown@example.com" {
+		t.Fatalf("Rejected() = %v", rejected)
+	}
+	var smtpErr *email.SMTPError
+	if !errors.As(rejected[0].Err, &smtpErr) {
+		t.Fatalf("rejection is %T, want *SMTPError", rejected[0].Err)
+	}
+	if smtpErr.Code != 550 || smtpErr.EnhancedCode != "5.1.1" || smtpErr.Phase != email.PhaseRcpt {
+		t.Errorf("rejection = %+v", smtpErr)
+	}
+
+	messages := server.Messages()
+	if len(messages) != 1 || strings.Join(messages[0].Recipients, ",") != "known@example.com" {
+		t.Errorf("delivered to %v, want only known@example.com", messages)
+	}
+}
+
+func TestSMTPSenderAllRejected(t *testing.T) {
+	server := emailtest.NewServer(t)
+	server.RejectRecipient("unknown@example.com", emailtest.Reply{Code: 550, Message: "5.1.1 User unknown"})
+
+	err := server.Sender().Send("unknown@example.com", "subject", "body", nil)
+	if err == nil {
+		t.Fatal("Send succeeded, want error")
+	}
+	if errors.Is(err, email.ErrRecipientsRejected) {
+		t.Errorf("err = %v: a message rejected for everyone is not a partial rejection", err)
+	}
+	if !email.IsPermanent(err) {
+		t.Errorf("IsPermanent(%v) = false", err)
+	}
+	if n := len(server.Messages()); n != 0 {
+		t.Errorf("server received %d messages, want 0", n)
+	}
+}
+
+func TestSMTPSenderReplyClassification(t *testing.T) {
+	tests := []struct {
+		phase     email.Phase
+		reply     emailtest.Reply
+		temporary bool
+	}{
+		{email.PhaseDial, emailtest.Reply{Code: 421, Message: "4.3.2 Service not available"}, true},
+		{email.PhaseMail, emailtest.Reply{Code: 451, Message: "4.7.1 Try again later"}, true},
+		{email.PhaseMail, emailtest.Reply{Code: 553, Message: "5.7.1 Sender not allowed"}, false},
+		{email.PhaseRcpt, emailtest.Reply{Code: 452, Message: "4.2.2 Mailbox full"}, true},
+		{email.PhaseData, emailtest.Reply{Code: 554, Message: "5.6.0 Message rejected"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.phase), func(t *testing.T) {
+			server := emailtest.NewServer(t)
+			server.Script(tt.phase, tt.reply)
+
+			err := server.Sender().Send("rcpt@example.com", "subject", "body", nil)
+
+			var smtpErr *email.SMTPError
+			if !errors.As(err, &smtpErr) {
+				t.Fatalf("err = %v, want *SMTPError", err)
+			}
+			if smtpErr.Code != tt.reply.Code {
+				t.Errorf("Code = %d, want %d", smtpErr.Code, tt.reply.Code)
+			}
+			if smtpErr.Temporary() != tt.temporary || smtpErr.Permanent() == tt.temporary {
+				t.Errorf("Temporary() = %v, Permanent() = %v for %d", smtpErr.Temporary(), smtpErr.Permanent(), tt.reply.Code)
+			}
+		})
+	}
+}
+
+func TestSMTPSenderAuthFailure(t *testing.T) {
+	server := emailtest.NewServer(t, emailtest.WithMode(emailtest.ModeStartTLS), emailtest.WithAuth("user@example.com", "secret"))
+	sender := server.Sender(email.WithAuth(email.PasswordAuth("user@example.com", "wrong", email.MechanismLogin)))
+
+	err := sender.Send("rcpt@example.com", "subject", "body", nil)
+
+	var smtpErr *email.SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Phase != email.PhaseAuth || smtpErr.Code != 535 {
+		t.Fatalf("err = %v, want 535 in auth phase", err)
+	}
+	if !smtpErr.Permanent() {
+		t.Errorf("authentication failure should be permanent")
+	}
+}
+
+func TestSMTPSenderStartTLSRequired(t *testing.T) {
+	server := emailtest.NewServer(t)
+	sender := server.Sender(email.WithSecurity(email.SecurityStartTLS))
+
+	err := sender.Send("rcpt@example.com", "subject", "body", nil)
+	if !errors.Is(err, email.ErrStartTLSNotSupported) {
+		t.Fatalf("err = %v, want ErrStartTLSNotSupported", err)
+	}
+	if n := len(server.Messages()); n != 0 {
+		t.Errorf("message was sent in cleartext")
+	}
+}
+
+func TestSMTPSenderOpportunisticStartTLS(t *testing.T) {
+	server := emailtest.NewServer(t)
+	sender := server.Sender(email.WithSecurity(email.SecurityStartTLSOpportunistic))
+
+	if err := sender.Send("rcpt@example.com", "subject", "body", nil); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if got := server.Messages(); len(got) != 1 || got[0].TLS {
+		t.Errorf("want one cleartext message, got %v", got)
+	}
+}
+
+func TestSMTPSenderCanceled(t *testing.T) {
+	server := emailtest.NewServer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := server.Sender().SendContext(ctx, "rcpt@example.com", "subject", "body", nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if email.IsTemporary(err) {
+		t.Errorf("cancellation must not be retried")
+	}
+}
+
+func TestSMTPErrorConnectionClassification(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		temporary bool
+	}{
+		{"eof", io.EOF, true},
+		{"unexpected eof", fmt.Errorf("read reply: %w", io.ErrUnexpectedEOF), true},
+		{"connection reset", &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.ECONNRESET)}, true},
+		{"connection refused", &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, true},
+		{"timeout", &net.OpError{Op: "write", Net: "tcp", Err: os.ErrDeadlineExceeded}, true},
+		{"no such host", &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "smtp.invalid", IsNotFound: true}}, false},
+		{"dns timeout", &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "i/o timeout", Name: "smtp.example.com", IsTimeout: true}}, true},
+		{"message mentions EOF", errors.New("template ends before EOF"), false},
+	}
+
+	for _, tt := range tests {
+		smtpErr := &email.SMTPError{Phase: email.PhaseDial, Err: tt.err}
+		if smtpErr.Temporary() != tt.temporary {
+			t.Errorf("%s: Temporary() = %v, want %v", tt.name, smtpErr.Temporary(), tt.temporary)
+		}
+	}
+}
+
+func TestSMTPSenderAttachmentReadError(t *testing.T) {
+	server := emailtest.NewServer(t)
+
+	_, err := server.Sender().SendMessage(context.Background(), &email.Message{
+		To:          []email.Address{{Address: "rcpt@example.com"}},
+		Parts:       []email.Part{email.TextPart("body")},
+		Attachments: []email.Attachment{email.NewReaderAttachment("report.pdf", iotest.ErrReader(io.ErrUnexpectedEOF))},
+	})
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("err = %v, want the attachment read error", err)
+	}
+	// Ошибка чтения вложения — не сбой SMTP, повторять отправку бессмысленно
+	if email.IsTemporary(err) {
+		t.Errorf("attachment read error is classified as temporary: %v", err)
+	}
+	if n := len(server.Messages()); n != 0 {
+		t.Errorf("server accepted %d truncated messages", n)
+	}
+}
+
+// optionalAuth — Authenticator, которому аутентификация не нужна, если сервер ее не объявляет
+type optionalAuth struct{}
+
+func (optionalAuth) Authenticate(string, []email.Mechanism) (smtp.Auth, error) {
+	return nil, nil
+}
+
+func TestSMTPSenderAuthenticatorWithoutAuth(t *testing.T) {
+	server := emailtest.NewServer(t)
+
+	if err := server.Sender(email.WithAuth(optionalAuth{})).Send("rcpt@example.com", "subject", "body", nil); err != nil {
+		t.Fatalf("Send with an authenticator returning nil: %v", err)
+	}
+
+	err := server.Sender(email.WithAuth(email.PasswordAuth("user@example.com", "secret"))).Send("rcpt@example.com", "subject", "body", nil)
+	if !errors.Is(err, email.ErrAuthNotSupported) {
+		t.Errorf("err = %v, want ErrAuthNotSupported", err)
+	}
+}
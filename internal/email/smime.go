@@ -0,0 +1,233 @@
+package email
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mozilla.org/pkcs7"
+)
+
+// SMIMEOptions описывает сертификаты S/MIME в том виде, в котором они задаются в конфигурации
+type SMIMEOptions struct {
+	// CertFile и KeyFile — сертификат отправителя и его закрытый ключ в PEM для подписи.
+	// После сертификата отправителя в CertFile может идти цепочка промежуточных сертификатов.
+	CertFile string
+	KeyFile  string
+	// CertsDir — каталог с сертификатами получателей для шифрования: <адрес>.pem, например ivan@example.com.pem
+	CertsDir string
+}
+
+// SMIME подписывает и шифрует письма по S/MIME (RFC 8551)
+type SMIME struct {
+	cert     *x509.Certificate
+	chain    []*x509.Certificate
+	key      crypto.PrivateKey
+	certsDir string
+	now      func() time.Time
+}
+
+// NewSMIME загружает сертификат отправителя, если он задан, и проверяет каталог сертификатов получателей
+func NewSMIME(opts SMIMEOptions) (*SMIME, error) {
+	s := &SMIME{certsDir: opts.CertsDir, now: time.Now}
+
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		pair, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading S/MIME certificate: %w", err)
+		}
+		s.key = pair.PrivateKey
+		if s.cert, err = x509.ParseCertificate(pair.Certificate[0]); err != nil {
+			return nil, fmt.Errorf("error parsing S/MIME certificate: %w", err)
+		}
+		for _, der := range pair.Certificate[1:] {
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing S/MIME certificate chain: %w", err)
+			}
+			s.chain = append(s.chain, cert)
+		}
+	}
+
+	if opts.CertsDir != "" {
+		info, err := os.Stat(opts.CertsDir)
+		if err != nil {
+			return nil, fmt.Errorf("error opening S/MIME certificates directory: %w", err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("S/MIME certificates path %s is not a directory", opts.CertsDir)
+		}
+	}
+
+	return s, nil
+}
+
+// Protection возвращает защиту писем: подпись сертификатом отправителя, шифрование для получателей или и то и другое
+func (s *SMIME) Protection(sign, encrypt bool) (Protection, error) {
+	if !sign && !encrypt {
+		return nil, errors.New("S/MIME protection must sign or encrypt")
+	}
+	if sign && s.cert == nil {
+		return nil, errors.New("S/MIME signing requires a certificate and a private key")
+	}
+	if encrypt && s.certsDir == "" {
+		return nil, errors.New("S/MIME encryption requires a recipient certificates directory")
+	}
+	return &smimeProtection{smime: s, sign: sign, encrypt: encrypt}, nil
+}
+
+// RecipientCertificate ищет сертификат получателя address в каталоге CertsDir
+func (s *SMIME) RecipientCertificate(address string) (*x509.Certificate, error) {
+	name := strings.ToLower(strings.TrimSpace(address))
+	if name == "" || strings.ContainsAny(name, `/\`) || name != filepath.Base(name) {
+		return nil, fmt.Errorf("%w: bad address %q", ErrRecipientKeyNotFound, address)
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.certsDir, name+".pem"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: no S/MIME certificate for %s", ErrRecipientKeyNotFound, address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading S/MIME certificate for %s: %w", address, err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("S/MIME certificate for %s is not a PEM certificate", address)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing S/MIME certificate for %s: %w", address, err)
+	}
+	if now := s.now(); now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return nil, fmt.Errorf("%w: S/MIME certificate for %s is not valid now (valid %s to %s)",
+			ErrRecipientKeyNotFound, address, cert.NotBefore.Format(time.DateOnly), cert.NotAfter.Format(time.DateOnly))
+	}
+	return cert, nil
+}
+
+// smimeProtection — защита писем, возвращаемая SMIME.Protection
+type smimeProtection struct {
+	smime   *SMIME
+	sign    bool
+	encrypt bool
+}
+
+// protect сначала подписывает тело, а затем шифрует его вместе с подписью,
+// чтобы получатель проверял подпись уже расшифрованного письма
+func (p *smimeProtection) protect(msg *Message, root *mimePart) (*mimePart, error) {
+	var err error
+	if p.sign {
+		if root, err = p.smime.signPart(root); err != nil {
+			return nil, err
+		}
+	}
+	if p.encrypt {
+		if root, err = p.smime.encryptPart(root, msg.Recipients()); err != nil {
+			return nil, err
+		}
+	}
+	return root, nil
+}
+
+// signPart оборачивает часть в multipart/signed с отсоединенной подписью PKCS#7
+func (s *SMIME) signPart(part *mimePart) (*mimePart, error) {
+	content, err := renderPart(part)
+	if err != nil {
+		return nil, err
+	}
+
+	signed, err := pkcs7.NewSignedData(content)
+	if err != nil {
+		return nil, fmt.Errorf("error signing message with S/MIME: %w", err)
+	}
+	signed.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	if err := signed.AddSignerChain(s.cert, s.key, s.chain, pkcs7.SignerInfoConfig{}); err != nil {
+		return nil, fmt.Errorf("error signing message with S/MIME: %w", err)
+	}
+	signed.Detach()
+	signature, err := signed.Finish()
+	if err != nil {
+		return nil, fmt.Errorf("error signing message with S/MIME: %w", err)
+	}
+
+	params := map[string]string{"protocol": "application/pkcs7-signature", "micalg": "sha-256"}
+	return newSignedMultipart(params, content, newPKCS7Part("application/pkcs7-signature", nil, "smime.p7s", signature)), nil
+}
+
+// encryptPart шифрует часть для всех получателей и для самого отправителя, чтобы он мог прочитать отправленное
+func (s *SMIME) encryptPart(part *mimePart, recipients []string) (*mimePart, error) {
+	var certs []*x509.Certificate
+	for _, rcpt := range recipients {
+		cert, err := s.RecipientCertificate(rcpt)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if s.cert != nil {
+		certs = append(certs, s.cert)
+	}
+
+	content, err := renderPart(part)
+	if err != nil {
+		return nil, err
+	}
+	enveloped, err := encryptAES256(content, certs)
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting message with S/MIME: %w", err)
+	}
+
+	params := map[string]string{"smime-type": "enveloped-data"}
+	return newPKCS7Part("application/pkcs7-mime", params, "smime.p7m", enveloped), nil
+}
+
+// pkcs7Mu защищает pkcs7.ContentEncryptionAlgorithm: библиотека берет алгоритм из глобальной переменной,
+// а не из параметров Encrypt
+var pkcs7Mu sync.Mutex
+
+// encryptAES256 шифрует content алгоритмом AES-256-CBC. По умолчанию pkcs7 шифрует устаревшим DES-CBC;
+// настройка меняется только на время вызова, чтобы не затронуть других пользователей библиотеки.
+func encryptAES256(content []byte, certs []*x509.Certificate) ([]byte, error) {
+	pkcs7Mu.Lock()
+	defer pkcs7Mu.Unlock()
+
+	saved := pkcs7.ContentEncryptionAlgorithm
+	pkcs7.ContentEncryptionAlgorithm = pkcs7.EncryptionAlgorithmAES256CBC
+	defer func() { pkcs7.ContentEncryptionAlgorithm = saved }()
+
+	return pkcs7.Encrypt(content, certs)
+}
+
+// newPKCS7Part создает лист с DER-структурой PKCS#7 в base64
+func newPKCS7Part(mediaType string, params map[string]string, name string, data []byte) *mimePart {
+	if params == nil {
+		params = make(map[string]string)
+	}
+	params["name"] = name
+
+	return &mimePart{
+		header: textproto.MIMEHeader{
+			"Content-Type":              {formatContentType(mediaType, params)},
+			"Content-Transfer-Encoding": {encodingBase64},
+			"Content-Disposition":       {formatDisposition("attachment", name)},
+		},
+		body: func(w io.Writer) error {
+			encoder := newBase64Encoder(w)
+			if _, err := encoder.Write(data); err != nil {
+				return err
+			}
+			return encoder.Close()
+		},
+	}
+}
@@ -0,0 +1,287 @@
+package email_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"mime"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mozilla.org/pkcs7"
+
+	"github.com/mclyashko/IPORPIS/internal/email"
+)
+
+// smimeIdentity — сертификат и ключ участника переписки
+type smimeIdentity struct {
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+}
+
+// newSMIMEIdentity создает самоподписанный сертификат для address и записывает его и ключ в dir
+func newSMIMEIdentity(t *testing.T, dir, address string) smimeIdentity {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(time.Now().UnixNano()),
+		Subject:        pkix.Name{CommonName: address},
+		EmailAddresses: []string{address},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		KeyUsage:       x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(filepath.Join(dir, address+".pem"), certPEM, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, address+".key"), keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return smimeIdentity{cert: cert, key: key}
+}
+
+// sendProtected отправляет письмо в MemorySender с защитой protection и возвращает его исходный текст
+func sendProtected(t *testing.T, protection email.Protection, to string) []byte {
+	t.Helper()
+
+	sender := email.NewMemorySender("sender@example.com")
+	msg := &email.Message{
+		To:          []email.Address{{Address: to}},
+		Subject:     "Договор",
+		Parts:       []email.Part{email.TextPart("Подписанный текст")},
+		Attachments: []email.Attachment{email.NewAttachment("договор.txt", []byte("условия"))},
+		Protection:  protection,
+	}
+	if _, err := sender.SendMessage(context.Background(), msg); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	sent, _ := sender.Last()
+	return sent.Data
+}
+
+// messageBody отделяет тело письма от заголовков и возвращает его вместе с Content-Type
+func messageBody(t *testing.T, data []byte) (mediaType string, params map[string]string, body []byte) {
+	t.Helper()
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("parsing message: %v", err)
+	}
+	mediaType, params, err = mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("parsing Content-Type: %v", err)
+	}
+	body, err = io.ReadAll(parsed.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mediaType, params, body
+}
+
+// verifySigned проверяет multipart/signed и возвращает подписанную часть
+func verifySigned(t *testing.T, mediaType string, params map[string]string, body []byte, signer *x509.Certificate) []byte {
+	t.Helper()
+
+	if mediaType != "multipart/signed" || params["protocol"] != "application/pkcs7-signature" || params["micalg"] != "sha-256" {
+		t.Fatalf("Content-Type = %s %v, want multipart/signed", mediaType, params)
+	}
+
+	delimiter := "--" + params["boundary"]
+	parts := strings.Split(string(body), "\r\n"+delimiter)
+	signed, ok := strings.CutPrefix(parts[0], delimiter+"\r\n")
+	if !ok || len(parts) != 3 {
+		t.Fatalf("unexpected multipart/signed layout:\n%s", body)
+	}
+
+	_, sigBase64, _ := strings.Cut(parts[1], "\r\n\r\n")
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(sigBase64), ""))
+	if err != nil {
+		t.Fatalf("decoding signature: %v", err)
+	}
+	p7, err := pkcs7.Parse(der)
+	if err != nil {
+		t.Fatalf("parsing signature: %v", err)
+	}
+	p7.Content = []byte(signed)
+	if err := p7.Verify(); err != nil {
+		t.Fatalf("signature does not verify: %v", err)
+	}
+	if !p7.GetOnlySigner().Equal(signer) {
+		t.Errorf("message signed by %s", p7.GetOnlySigner().Subject)
+	}
+	return []byte(signed)
+}
+
+// decryptEnveloped расшифровывает application/pkcs7-mime ключом получателя
+// aes256CBC — DER-кодировка OID алгоритма AES-256-CBC (2.16.840.1.101.3.4.1.42)
+var aes256CBC = []byte{0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x01, 0x2a}
+
+func decryptEnveloped(t *testing.T, mediaType string, params map[string]string, body []byte, rcpt smimeIdentity) []byte {
+	t.Helper()
+
+	if mediaType != "application/pkcs7-mime" || params["smime-type"] != "enveloped-data" {
+		t.Fatalf("Content-Type = %s %v, want application/pkcs7-mime", mediaType, params)
+	}
+	der, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, bytes.NewReader(bytes.ReplaceAll(body, []byte("\r\n"), nil))))
+	if err != nil {
+		t.Fatalf("decoding enveloped data: %v", err)
+	}
+	if !bytes.Contains(der, aes256CBC) {
+		t.Errorf("enveloped data is not encrypted with AES-256-CBC")
+	}
+	p7, err := pkcs7.Parse(der)
+	if err != nil {
+		t.Fatalf("parsing enveloped data: %v", err)
+	}
+	content, err := p7.Decrypt(rcpt.cert, rcpt.key)
+	if err != nil {
+		t.Fatalf("decrypting: %v", err)
+	}
+	return content
+}
+
+func TestSMIMESign(t *testing.T) {
+	dir := t.TempDir()
+	sender := newSMIMEIdentity(t, dir, "sender@example.com")
+
+	smime, err := email.NewSMIME(email.SMIMEOptions{
+		CertFile: filepath.Join(dir, "sender@example.com.pem"),
+		KeyFile:  filepath.Join(dir, "sender@example.com.key"),
+	})
+	if err != nil {
+		t.Fatalf("NewSMIME: %v", err)
+	}
+	protection, err := smime.Protection(true, false)
+	if err != nil {
+		t.Fatalf("Protection: %v", err)
+	}
+
+	data := sendProtected(t, protection, "rcpt@example.com")
+	if !bytes.Contains(data, []byte("\r\nSubject: ")) {
+		t.Errorf("message headers must stay readable:\n%s", data)
+	}
+
+	mediaType, params, body := messageBody(t, data)
+	signed := verifySigned(t, mediaType, params, body, sender.cert)
+	if !bytes.HasPrefix(signed, []byte("Content-Type: multipart/mixed")) {
+		t.Errorf("signed part is not the original body:\n%s", signed)
+	}
+}
+
+func TestSMIMESignAndEncrypt(t *testing.T) {
+	dir := t.TempDir()
+	sender := newSMIMEIdentity(t, dir, "sender@example.com")
+	rcpt := newSMIMEIdentity(t, dir, "rcpt@example.com")
+
+	smime, err := email.NewSMIME(email.SMIMEOptions{
+		CertFile: filepath.Join(dir, "sender@example.com.pem"),
+		KeyFile:  filepath.Join(dir, "sender@example.com.key"),
+		CertsDir: dir,
+	})
+	if err != nil {
+		t.Fatalf("NewSMIME: %v", err)
+	}
+	protection, err := smime.Protection(true, true)
+	if err != nil {
+		t.Fatalf("Protection: %v", err)
+	}
+
+	data := sendProtected(t, protection, "RCPT@example.com")
+	if bytes.Contains(data, []byte("условия")) || bytes.Contains(data, []byte("multipart/signed")) {
+		t.Fatalf("message is not encrypted:\n%s", data)
+	}
+
+	mediaType, params, body := messageBody(t, data)
+	for _, identity := range []smimeIdentity{rcpt, sender} {
+		content := decryptEnveloped(t, mediaType, params, body, identity)
+		innerType, innerParams, innerBody := messageBody(t, content)
+		verifySigned(t, innerType, innerParams, innerBody, sender.cert)
+	}
+	if pkcs7.ContentEncryptionAlgorithm != pkcs7.EncryptionAlgorithmDESCBC {
+		t.Errorf("pkcs7.ContentEncryptionAlgorithm was left changed to %d", pkcs7.ContentEncryptionAlgorithm)
+	}
+}
+
+func TestSMIMEEncryptMissingCertificate(t *testing.T) {
+	dir := t.TempDir()
+
+	smime, err := email.NewSMIME(email.SMIMEOptions{CertsDir: dir})
+	if err != nil {
+		t.Fatalf("NewSMIME: %v", err)
+	}
+	if _, err := smime.Protection(true, true); err == nil {
+		t.Error("signing without a certificate must be rejected up front")
+	}
+	protection, err := smime.Protection(false, true)
+	if err != nil {
+		t.Fatalf("Protection: %v", err)
+	}
+
+	sender := email.NewMemorySender("sender@example.com")
+	msg := &email.Message{
+		To:         []email.Address{{Address: "unknown@example.com"}},
+		Parts:      []email.Part{email.TextPart("секрет")},
+		Protection: protection,
+	}
+	_, err = sender.SendMessage(context.Background(), msg)
+	if !errors.Is(err, email.ErrRecipientKeyNotFound) {
+		t.Fatalf("err = %v, want ErrRecipientKeyNotFound", err)
+	}
+	if sender.Len() != 0 {
+		t.Error("message without a recipient certificate must not be sent")
+	}
+}
+
+func TestProtectedSender(t *testing.T) {
+	dir := t.TempDir()
+	sender := newSMIMEIdentity(t, dir, "sender@example.com")
+
+	smime, err := email.NewSMIME(email.SMIMEOptions{
+		CertFile: filepath.Join(dir, "sender@example.com.pem"),
+		KeyFile:  filepath.Join(dir, "sender@example.com.key"),
+	})
+	if err != nil {
+		t.Fatalf("NewSMIME: %v", err)
+	}
+	protection, err := smime.Protection(true, false)
+	if err != nil {
+		t.Fatalf("Protection: %v", err)
+	}
+
+	memory := email.NewMemorySender("sender@example.com")
+	if err := email.NewProtectedSender(memory, protection).Send("rcpt@example.com", "subject", "body", nil); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	sent, _ := memory.Last()
+	mediaType, params, body := messageBody(t, sent.Data)
+	verifySigned(t, mediaType, params, body, sender.cert)
+}
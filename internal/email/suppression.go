@@ -0,0 +1,112 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// ErrSuppressed — получатель в списке исключений: отписался, пожаловался на письмо или его адрес недоставим
+var ErrSuppressed = errors.New("recipient is on the suppression list")
+
+// SuppressedError сообщает, что письмо не отправлено получателю из списка исключений.
+// errors.Is(err, ErrSuppressed) для нее возвращает true.
+type SuppressedError struct {
+	Address string
+	// Reason — причина исключения из списка, например bounce или unsubscribe
+	Reason string
+}
+
+func (e *SuppressedError) Error() string {
+	return fmt.Sprintf("recipient %s is on the suppression list (%s)", e.Address, e.Reason)
+}
+
+func (e *SuppressedError) Is(target error) bool {
+	return target == ErrSuppressed
+}
+
+// SuppressionList — список адресов, на которые нельзя отправлять письма
+type SuppressionList interface {
+	// Suppressed сообщает, исключен ли адрес, и причину исключения
+	Suppressed(ctx context.Context, address string) (reason string, ok bool, err error)
+}
+
+// SuppressingSender не отправляет письма получателям из списка исключений и передает остальное дальше
+type SuppressingSender struct {
+	next Sender
+	list SuppressionList
+}
+
+// NewSuppressingSender оборачивает next проверкой получателей по списку list
+func NewSuppressingSender(next Sender, list SuppressionList) *SuppressingSender {
+	return &SuppressingSender{next: next, list: list}
+}
+
+// Send отправляет электронное письмо одному получателю
+func (s *SuppressingSender) Send(to, subject, body string, attachmentFilePaths []string) error {
+	return s.SendContext(context.Background(), to, subject, body, attachmentFilePaths)
+}
+
+// SendContext отправляет электронное письмо одному получателю с учетом отмены и дедлайна ctx
+func (s *SuppressingSender) SendContext(ctx context.Context, to, subject, body string, attachmentFilePaths []string) error {
+	_, err := s.SendMessage(ctx, newSimpleMessage(to, subject, body, attachmentFilePaths))
+	return err
+}
+
+// SendMessage отправляет письмо получателям, которых нет в списке исключений. Исключенные получатели
+// убираются из To, Cc и Bcc и попадают в Result.Recipients с ошибкой *SuppressedError; если исключены
+// все, письмо не отправляется. Как и при отказе сервера, частичное исключение оборачивает ErrRecipientsRejected.
+func (s *SuppressingSender) SendMessage(ctx context.Context, msg *Message) (*Result, error) {
+	recipients := msg.Recipients()
+	var suppressed []RecipientStatus
+	skip := make(map[string]bool)
+	for _, rcpt := range recipients {
+		reason, ok, err := s.list.Suppressed(ctx, rcpt)
+		if err != nil {
+			return nil, fmt.Errorf("error checking suppression list: %w", err)
+		}
+		if !ok {
+			continue
+		}
+		log.Printf("Получатель %s в списке исключений (%s), письмо ему не отправляется", rcpt, reason)
+		suppressed = append(suppressed, RecipientStatus{Address: rcpt, Err: &SuppressedError{Address: rcpt, Reason: reason}})
+		skip[strings.ToLower(rcpt)] = true
+	}
+
+	if len(suppressed) == 0 {
+		return s.next.SendMessage(ctx, msg)
+	}
+	if len(suppressed) == len(recipients) {
+		return &Result{Recipients: suppressed}, rejectedError(suppressed)
+	}
+
+	filtered := *msg
+	filtered.To = withoutAddresses(msg.To, skip)
+	filtered.Cc = withoutAddresses(msg.Cc, skip)
+	filtered.Bcc = withoutAddresses(msg.Bcc, skip)
+
+	result, err := s.next.SendMessage(ctx, &filtered)
+	if result != nil {
+		result.Recipients = append(result.Recipients, suppressed...)
+	}
+	switch {
+	case err == nil:
+		err = fmt.Errorf("%w: %w", ErrRecipientsRejected, rejectedError(suppressed))
+	case errors.Is(err, ErrRecipientsRejected):
+		err = errors.Join(err, rejectedError(suppressed))
+	}
+	return result, err
+}
+
+// withoutAddresses возвращает список адресов без исключенных
+func withoutAddresses(list []Address, skip map[string]bool) []Address {
+	var kept []Address
+	for _, addr := range list {
+		if !skip[strings.ToLower(addr.Address)] {
+			kept = append(kept, addr)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,83 @@
+package email_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mclyashko/IPORPIS/internal/email"
+	"github.com/mclyashko/IPORPIS/internal/email/emailtest"
+)
+
+// suppressionList — список исключений в памяти: адрес → причина
+type suppressionList map[string]string
+
+func (l suppressionList) Suppressed(_ context.Context, address string) (string, bool, error) {
+	reason, ok := l[strings.ToLower(address)]
+	return reason, ok, nil
+}
+
+func TestSuppressingSender(t *testing.T) {
+	server := emailtest.NewServer(t)
+	list := suppressionList{"gone@example.com": "bounce"}
+	sender := email.NewSuppressingSender(server.Sender(), list)
+
+	// Единственный получатель исключен: письмо не отправляется вовсе
+	_, err := sender.SendMessage(context.Background(), &email.Message{
+		To:    []email.Address{{Address: "Gone@example.com"}},
+		Parts: []email.Part{email.TextPart("body")},
+	})
+	var suppressed *email.SuppressedError
+	if !errors.Is(err, email.ErrSuppressed) || !errors.As(err, &suppressed) || suppressed.Reason != "bounce" {
+		t.Fatalf("err = %v, want SuppressedError with reason bounce", err)
+	}
+	if email.IsTemporary(err) {
+		t.Error("suppression is reported as a temporary error")
+	}
+	if n := server.Connections(); n != 0 {
+		t.Errorf("server saw %d connections for a suppressed recipient", n)
+	}
+
+	// Исключен один из получателей: письмо уходит остальным
+	result, err := sender.SendMessage(context.Background(), &email.Message{
+		To:    []email.Address{{Address: "rcpt@example.com"}},
+		Cc:    []email.Address{{Address: "gone@example.com"}},
+		Parts: []email.Part{email.TextPart("body")},
+	})
+	if !errors.Is(err, email.ErrRecipientsRejected) || !errors.Is(err, email.ErrSuppressed) {
+		t.Fatalf("err = %v, want ErrRecipientsRejected wrapping ErrSuppressed", err)
+	}
+	if got := result.Accepted(); len(got) != 1 || got[0] != "rcpt@example.com" {
+		t.Errorf("Accepted() = %v", got)
+	}
+	if rejected := result.Rejected(); len(rejected) != 1 || rejected[0].Address != "gone@example.com" {
+		t.Errorf("Rejected() = %v", rejected)
+	}
+	got := server.Messages()[0]
+	if len(got.Recipients) != 1 || got.Recipients[0] != "rcpt@example.com" || got.Header.Get("Cc") != "" {
+		t.Errorf("envelope %v, Cc %q: suppressed recipient was not removed", got.Recipients, got.Header.Get("Cc"))
+	}
+}
+
+type failingList struct{}
+
+func (failingList) Suppressed(context.Context, string) (string, bool, error) {
+	return "", false, errors.New("database is down")
+}
+
+func TestSuppressingSenderListError(t *testing.T) {
+	server := emailtest.NewServer(t)
+
+	// Если список недоступен, безопаснее не отправлять, чем написать отписавшемуся
+	_, err := email.NewSuppressingSender(server.Sender(), failingList{}).SendMessage(context.Background(), &email.Message{
+		To:    []email.Address{{Address: "rcpt@example.com"}},
+		Parts: []email.Part{email.TextPart("body")},
+	})
+	if err == nil || errors.Is(err, email.ErrSuppressed) {
+		t.Errorf("err = %v, want the list error", err)
+	}
+	if n := server.Connections(); n != 0 {
+		t.Errorf("server saw %d connections", n)
+	}
+}
@@ -0,0 +1,110 @@
+package email_test
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mclyashko/IPORPIS/internal/email"
+	"github.com/mclyashko/IPORPIS/internal/email/emailtest"
+)
+
+func TestNewTLSConfig(t *testing.T) {
+	server := emailtest.NewServer(t, emailtest.WithMode(emailtest.ModeTLS))
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Посторонний самоподписанный сертификат в роли удостоверяющего центра
+	otherDir := t.TempDir()
+	newSMIMEIdentity(t, otherDir, "ca@example.com")
+	otherCAFile := filepath.Join(otherDir, "ca@example.com.pem")
+
+	sum := sha256.Sum256(server.Certificate().Raw)
+	pin := hex.EncodeToString(sum[:])
+	wrongPin := strings.Repeat("00", sha256.Size)
+
+	tests := []struct {
+		name    string
+		opts    email.TLSOptions
+		wantErr error
+	}{
+		{name: "system roots reject self-signed", opts: email.TLSOptions{}, wantErr: &tls.CertificateVerificationError{}},
+		{name: "CA bundle", opts: email.TLSOptions{CAFile: caFile}},
+		{name: "CA bundle with server name", opts: email.TLSOptions{CAFile: caFile, ServerName: "localhost", MinVersion: "1.3"}},
+		{name: "wrong server name", opts: email.TLSOptions{CAFile: caFile, ServerName: "smtp.example.com"}, wantErr: &tls.CertificateVerificationError{}},
+		{name: "pinned certificate", opts: email.TLSOptions{PinSHA256: strings.ToUpper(pin)}},
+		{name: "wrong pin", opts: email.TLSOptions{PinSHA256: wrongPin}, wantErr: email.ErrCertificatePinMismatch},
+		{name: "pin and CA bundle", opts: email.TLSOptions{PinSHA256: pin, CAFile: caFile, ServerName: "localhost"}},
+		{name: "pin with untrusted CA", opts: email.TLSOptions{PinSHA256: pin, CAFile: otherCAFile}, wantErr: &tls.CertificateVerificationError{}},
+		{name: "pin with wrong server name", opts: email.TLSOptions{PinSHA256: pin, CAFile: caFile, ServerName: "smtp.example.com"}, wantErr: &tls.CertificateVerificationError{}},
+		{name: "insecure", opts: email.TLSOptions{Insecure: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := email.NewTLSConfig(tt.opts)
+			if err != nil {
+				t.Fatalf("NewTLSConfig: %v", err)
+			}
+
+			err = server.Sender(email.WithTLSConfig(cfg)).Send("rcpt@example.com", "subject", "body", nil)
+			switch want := tt.wantErr.(type) {
+			case nil:
+				if err != nil {
+					t.Fatalf("Send: %v", err)
+				}
+			case *tls.CertificateVerificationError:
+				if !errors.As(err, &want) {
+					t.Fatalf("err = %v, want certificate verification error", err)
+				}
+			default:
+				if !errors.Is(err, want) {
+					t.Fatalf("err = %v, want %v", err, want)
+				}
+			}
+
+			var smtpErr *email.SMTPError
+			if err != nil && (!errors.As(err, &smtpErr) || smtpErr.Phase != email.PhaseTLS) {
+				t.Errorf("err = %v, want an error in the tls phase", err)
+			}
+		})
+	}
+}
+
+func TestNewTLSConfigInvalid(t *testing.T) {
+	tests := map[string]email.TLSOptions{
+		"missing CA file":  {CAFile: filepath.Join(t.TempDir(), "missing.pem")},
+		"bad pin":          {PinSHA256: "abc"},
+		"bad version":      {MinVersion: "2.0"},
+		"missing key pair": {CertFile: "client.pem"},
+	}
+	for name, opts := range tests {
+		if _, err := email.NewTLSConfig(opts); err == nil {
+			t.Errorf("%s: NewTLSConfig succeeded", name)
+		}
+	}
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	tests := map[string]uint16{
+		"1.2":     tls.VersionTLS12,
+		"TLS1.3":  tls.VersionTLS13,
+		" tls1.0": tls.VersionTLS10,
+	}
+	for s, want := range tests {
+		got, err := email.ParseTLSVersion(s)
+		if err != nil || got != want {
+			t.Errorf("ParseTLSVersion(%q) = %x, %v; want %x", s, got, err, want)
+		}
+	}
+}
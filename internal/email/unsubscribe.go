@@ -0,0 +1,102 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// ListUnsubscribe — способы отписаться от рассылки для заголовков List-Unsubscribe (RFC 2369)
+// и List-Unsubscribe-Post (RFC 8058). Почтовые службы показывают по ним кнопку «Отписаться».
+type ListUnsubscribe struct {
+	// URL — ссылка отписки, обычно персональная для получателя
+	URL string
+	// Mailto — адрес, письмо на который отписывает получателя, например unsubscribe@example.com
+	Mailto string
+	// OneClick добавляет List-Unsubscribe-Post: почтовая служба отписывает получателя POST-запросом
+	// на URL без перехода на сайт. URL должен быть https, а заголовки — подписаны DKIM.
+	OneClick bool
+}
+
+// UnsubscribeLinks выдает способы отписки для получателя
+type UnsubscribeLinks interface {
+	// ListUnsubscribe возвращает способы отписки для адреса recipient или nil, если их нет
+	ListUnsubscribe(recipient string) *ListUnsubscribe
+}
+
+// validate проверяет, что способы отписки можно записать в заголовки
+func (l *ListUnsubscribe) validate() error {
+	if l.URL == "" && l.Mailto == "" {
+		return fmt.Errorf("%w: List-Unsubscribe needs a URL or a mailto address", ErrInvalidHeader)
+	}
+	// Значения пишутся в угловых скобках через запятую, поэтому эти символы в них недопустимы
+	if strings.ContainsAny(l.URL+l.Mailto, "\r\n<>, ") {
+		return fmt.Errorf("%w: List-Unsubscribe contains forbidden characters", ErrInvalidHeader)
+	}
+	if l.URL != "" {
+		u, err := url.Parse(l.URL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("%w: List-Unsubscribe URL %q must be an absolute http(s) URL", ErrInvalidHeader, l.URL)
+		}
+		if l.OneClick && u.Scheme != "https" {
+			return fmt.Errorf("%w: one-click unsubscribe requires an https URL", ErrInvalidHeader)
+		}
+	} else if l.OneClick {
+		return fmt.Errorf("%w: one-click unsubscribe requires a URL", ErrInvalidHeader)
+	}
+	if l.Mailto != "" && !strings.Contains(l.Mailto, "@") {
+		return fmt.Errorf("%w: bad List-Unsubscribe mailto address %q", ErrInvalidHeader, l.Mailto)
+	}
+	return nil
+}
+
+// headerValue возвращает значение List-Unsubscribe: сначала ссылка, затем mailto
+func (l *ListUnsubscribe) headerValue() string {
+	var values []string
+	if l.URL != "" {
+		values = append(values, "<"+l.URL+">")
+	}
+	if l.Mailto != "" {
+		values = append(values, "<mailto:"+strings.TrimPrefix(l.Mailto, "mailto:")+">")
+	}
+	return strings.Join(values, ", ")
+}
+
+// UnsubscribeSender добавляет в письма способы отписки для получателя, если Message.Unsubscribe не задан.
+// Ссылка персональная, поэтому добавляется только в письма с одним получателем.
+type UnsubscribeSender struct {
+	next  Sender
+	links UnsubscribeLinks
+}
+
+// NewUnsubscribeSender оборачивает next добавлением заголовков отписки из links
+func NewUnsubscribeSender(next Sender, links UnsubscribeLinks) *UnsubscribeSender {
+	return &UnsubscribeSender{next: next, links: links}
+}
+
+// Send отправляет электронное письмо одному получателю
+func (u *UnsubscribeSender) Send(to, subject, body string, attachmentFilePaths []string) error {
+	return u.SendContext(context.Background(), to, subject, body, attachmentFilePaths)
+}
+
+// SendContext отправляет электронное письмо одному получателю с учетом отмены и дедлайна ctx
+func (u *UnsubscribeSender) SendContext(ctx context.Context, to, subject, body string, attachmentFilePaths []string) error {
+	_, err := u.SendMessage(ctx, newSimpleMessage(to, subject, body, attachmentFilePaths))
+	return err
+}
+
+// SendMessage отправляет письмо со ссылками отписки; способы отписки, заданные в самом письме, не переопределяет
+func (u *UnsubscribeSender) SendMessage(ctx context.Context, msg *Message) (*Result, error) {
+	if msg.Unsubscribe == nil {
+		if recipients := msg.Recipients(); len(recipients) == 1 {
+			withLinks := *msg
+			withLinks.Unsubscribe = u.links.ListUnsubscribe(recipients[0])
+			msg = &withLinks
+		} else if len(recipients) > 1 {
+			log.Printf("Письмо для %d получателей отправляется без персональной ссылки отписки", len(recipients))
+		}
+	}
+	return u.next.SendMessage(ctx, msg)
+}
@@ -0,0 +1,97 @@
+package email_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mclyashko/IPORPIS/internal/email"
+	"github.com/mclyashko/IPORPIS/internal/email/emailtest"
+)
+
+func TestListUnsubscribeHeaders(t *testing.T) {
+	server := emailtest.NewServer(t)
+
+	_, err := server.Sender().SendMessage(context.Background(), &email.Message{
+		To:    []email.Address{{Address: "rcpt@example.com"}},
+		Parts: []email.Part{email.TextPart("Новости")},
+		Unsubscribe: &email.ListUnsubscribe{
+			URL:      "https://mail.example.com/unsubscribe?token=abc",
+			Mailto:   "unsubscribe@example.com",
+			OneClick: true,
+		},
+	})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	header := server.Messages()[0].Header
+	want := "<https://mail.example.com/unsubscribe?token=abc>, <mailto:unsubscribe@example.com>"
+	if got := header.Get("List-Unsubscribe"); got != want {
+		t.Errorf("List-Unsubscribe = %q, want %q", got, want)
+	}
+	if got := header.Get("List-Unsubscribe-Post"); got != "List-Unsubscribe=One-Click" {
+		t.Errorf("List-Unsubscribe-Post = %q", got)
+	}
+}
+
+func TestListUnsubscribeInvalid(t *testing.T) {
+	server := emailtest.NewServer(t)
+
+	for name, msg := range map[string]*email.Message{
+		"empty":               {Unsubscribe: &email.ListUnsubscribe{OneClick: true}},
+		"one-click over http": {Unsubscribe: &email.ListUnsubscribe{URL: "http://mail.example.com/unsubscribe", OneClick: true}},
+		"relative url":        {Unsubscribe: &email.ListUnsubscribe{URL: "/unsubscribe"}},
+		"line break":          {Unsubscribe: &email.ListUnsubscribe{URL: "https://mail.example.com/u\r\nBcc: victim@example.com"}},
+		"header conflict": {
+			Unsubscribe: &email.ListUnsubscribe{Mailto: "unsubscribe@example.com"},
+			Headers:     map[string]string{"List-Unsubscribe": "<mailto:other@example.com>"},
+		},
+	} {
+		msg.To = []email.Address{{Address: "rcpt@example.com"}}
+		msg.Parts = []email.Part{email.TextPart("body")}
+		if _, err := server.Sender().SendMessage(context.Background(), msg); !errors.Is(err, email.ErrInvalidHeader) {
+			t.Errorf("%s: err = %v, want ErrInvalidHeader", name, err)
+		}
+	}
+	if n := server.Connections(); n != 0 {
+		t.Errorf("invalid messages opened %d connections", n)
+	}
+}
+
+// recipientLinks выдает ссылку отписки с адресом получателя в пути
+type recipientLinks struct{}
+
+func (recipientLinks) ListUnsubscribe(recipient string) *email.ListUnsubscribe {
+	return &email.ListUnsubscribe{URL: "https://mail.example.com/unsubscribe/" + recipient, OneClick: true}
+}
+
+func TestUnsubscribeSender(t *testing.T) {
+	server := emailtest.NewServer(t)
+	sender := email.NewUnsubscribeSender(server.Sender(), recipientLinks{})
+
+	send := func(msg *email.Message) {
+		t.Helper()
+		msg.Parts = []email.Part{email.TextPart("body")}
+		if _, err := sender.SendMessage(context.Background(), msg); err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+	}
+	send(&email.Message{To: []email.Address{{Address: "ivan@example.org"}}})
+	send(&email.Message{
+		To:          []email.Address{{Address: "petr@example.org"}},
+		Unsubscribe: &email.ListUnsubscribe{Mailto: "unsubscribe@example.com"},
+	})
+	send(&email.Message{To: []email.Address{{Address: "ivan@example.org"}, {Address: "petr@example.org"}}})
+
+	messages := server.Messages()
+	if got := messages[0].Header.Get("List-Unsubscribe"); got != "<https://mail.example.com/unsubscribe/ivan@example.org>" {
+		t.Errorf("personal List-Unsubscribe = %q", got)
+	}
+	if got := messages[1].Header.Get("List-Unsubscribe"); got != "<mailto:unsubscribe@example.com>" {
+		t.Errorf("List-Unsubscribe set in the message was replaced: %q", got)
+	}
+	if got := messages[2].Header.Get("List-Unsubscribe"); got != "" {
+		t.Errorf("message with two recipients got a personal link: %q", got)
+	}
+}
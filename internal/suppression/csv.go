@@ -0,0 +1,71 @@
+package suppression
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// csvHeader — первая строка CSV со списком исключений
+var csvHeader = []string{"address", "reason", "detail", "created_at"}
+
+// WriteCSV записывает записи в CSV: адрес, причина, пояснение и время в RFC 3339
+func WriteCSV(w io.Writer, entries []Entry) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return fmt.Errorf("error writing suppression list: %w", err)
+	}
+	for _, entry := range entries {
+		record := []string{entry.Address, string(entry.Reason), entry.Detail, entry.CreatedAt.UTC().Format(time.RFC3339)}
+		if err := writer.Write(record); err != nil {
+			return fmt.Errorf("error writing suppression list: %w", err)
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("error writing suppression list: %w", err)
+	}
+	return nil
+}
+
+// ReadCSV читает записи в формате WriteCSV. Строка заголовка необязательна, а из полей обязателен
+// только адрес: так можно загрузить и обычный список адресов, по одному в строке.
+func ReadCSV(r io.Reader) ([]Entry, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var entries []Entry
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading suppression list: %w", err)
+		}
+		if line == 1 && strings.EqualFold(record[0], csvHeader[0]) {
+			continue
+		}
+		if strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+
+		entry := Entry{Address: record[0]}
+		if len(record) > 1 {
+			entry.Reason = Reason(strings.TrimSpace(record[1]))
+		}
+		if len(record) > 2 {
+			entry.Detail = record[2]
+		}
+		if len(record) > 3 && record[3] != "" {
+			if entry.CreatedAt, err = time.Parse(time.RFC3339, strings.TrimSpace(record[3])); err != nil {
+				return nil, fmt.Errorf("error reading suppression list: line %d: invalid time %q", line, record[3])
+			}
+		}
+		entries = append(entries, entry)
+	}
+}
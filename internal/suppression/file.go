@@ -0,0 +1,211 @@
+package suppression
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FileStore хранит список исключений в CSV-файле. Перед каждой операцией файл перечитывается,
+// если его изменил другой процесс, поэтому рассылка видит адреса, добавленные, например, обработчиком
+// отписки. Запись атомарна: файл заменяется целиком через переименование. Изменения списка выполняются
+// под блокировкой flock файла path.lock, поэтому несколько процессов могут добавлять адреса одновременно,
+// не теряя чужих изменений; на системах без flock изменять список может только один процесс.
+type FileStore struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]Entry
+	// modTime и size — состояние файла при последнем чтении или записи
+	modTime time.Time
+	size    int64
+
+	now func() time.Time
+}
+
+// OpenFileStore открывает список исключений в файле path; отсутствующий файл считается пустым списком
+func OpenFileStore(path string) (*FileStore, error) {
+	f := &FileStore{path: path, entries: make(map[string]Entry), size: -1, now: time.Now}
+	if err := f.refresh(false); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Suppressed сообщает, исключен ли адрес, и причину исключения
+func (f *FileStore) Suppressed(_ context.Context, address string) (string, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.refresh(false); err != nil {
+		return "", false, err
+	}
+	entry, ok := f.entries[Normalize(address)]
+	return string(entry.Reason), ok, nil
+}
+
+// Add добавляет адреса в список
+func (f *FileStore) Add(_ context.Context, entries ...Entry) error {
+	entries, err := normalizeEntries(entries, f.now())
+	if err != nil {
+		return err
+	}
+
+	return f.update(func() {
+		for _, entry := range entries {
+			f.entries[entry.Address] = entry
+		}
+	})
+}
+
+// Remove убирает адреса из списка
+func (f *FileStore) Remove(_ context.Context, addresses ...string) error {
+	return f.update(func() {
+		for _, address := range addresses {
+			delete(f.entries, Normalize(address))
+		}
+	})
+}
+
+// Clear очищает список
+func (f *FileStore) Clear(_ context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Прежнее содержимое не нужно, поэтому файл не перечитывается: так можно очистить и поврежденный список
+	unlock, err := lockFile(f.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f.entries = make(map[string]Entry)
+	return f.save()
+}
+
+// Entries возвращает все исключенные адреса, отсортированные по адресу
+func (f *FileStore) Entries(_ context.Context) ([]Entry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.refresh(false); err != nil {
+		return nil, err
+	}
+	return f.sorted(), nil
+}
+
+// Close ничего не делает: все изменения записываются сразу
+func (f *FileStore) Close() error {
+	return nil
+}
+
+func (f *FileStore) sorted() []Entry {
+	entries := make([]Entry, 0, len(f.entries))
+	for _, entry := range f.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Address < entries[j].Address })
+	return entries
+}
+
+// update перечитывает файл, применяет к списку change и записывает результат. Все это происходит
+// под блокировкой, чтобы не затереть изменения, которые другой процесс записал между чтением и записью.
+func (f *FileStore) update(change func()) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	unlock, err := lockFile(f.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Под блокировкой файл перечитывается всегда: время изменения может не отличаться у двух записей подряд
+	if err := f.refresh(true); err != nil {
+		return err
+	}
+	change()
+	return f.save()
+}
+
+// refresh перечитывает файл, если он изменился с последнего чтения или записи, а с force — в любом случае
+func (f *FileStore) refresh(force bool) error {
+	info, err := os.Stat(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		f.entries = make(map[string]Entry)
+		f.modTime, f.size = time.Time{}, -1
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading suppression list: %w", err)
+	}
+	if !force && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return nil
+	}
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return fmt.Errorf("error reading suppression list: %w", err)
+	}
+	loaded, err := ReadCSV(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%s: %w", f.path, err)
+	}
+	loaded, err = normalizeEntries(loaded, info.ModTime())
+	if err != nil {
+		return fmt.Errorf("%s: %w", f.path, err)
+	}
+
+	f.entries = make(map[string]Entry, len(loaded))
+	for _, entry := range loaded {
+		f.entries[entry.Address] = entry
+	}
+	f.modTime, f.size = info.ModTime(), info.Size()
+	return nil
+}
+
+// save записывает список во временный файл рядом с основным и заменяет основной файл
+func (f *FileStore) save() error {
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, f.sorted()); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), "."+filepath.Base(f.path)+".*")
+	if err != nil {
+		return fmt.Errorf("error writing suppression list: %w", err)
+	}
+	// CreateTemp создает файл с правами 0600, а список должны читать и другие программы
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error writing suppression list: %w", err)
+	}
+	if _, err := buf.WriteTo(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error writing suppression list: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error writing suppression list: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error writing suppression list: %w", err)
+	}
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return fmt.Errorf("error writing suppression list: %w", err)
+	}
+	f.modTime, f.size = info.ModTime(), info.Size()
+	return nil
+}
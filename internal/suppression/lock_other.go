@@ -0,0 +1,9 @@
+//go:build !unix
+
+package suppression
+
+// lockFile на системах без flock не блокирует файл: список в одном файле может изменять только
+// один процесс, а остальные должны его только читать
+func lockFile(string) (func(), error) {
+	return func() {}, nil
+}
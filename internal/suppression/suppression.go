@@ -0,0 +1,116 @@
+// Package suppression хранит список исключений — адреса, на которые больше нельзя отправлять письма:
+// недоставимые по возвратам, пожаловавшиеся на письма и отписавшиеся. Хранилище подключается
+// к отправителю через email.NewSuppressingSender.
+package suppression
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/mclyashko/IPORPIS/internal/bounce"
+	"github.com/mclyashko/IPORPIS/internal/email"
+)
+
+// Reason — причина исключения адреса
+type Reason string
+
+const (
+	// ReasonBounce — адрес недоставим окончательно
+	ReasonBounce Reason = "bounce"
+	// ReasonComplaint — получатель пожаловался на письмо
+	ReasonComplaint Reason = "complaint"
+	// ReasonUnsubscribe — получатель отписался от рассылки
+	ReasonUnsubscribe Reason = "unsubscribe"
+	// ReasonManual — адрес исключен вручную
+	ReasonManual Reason = "manual"
+)
+
+// Entry — исключенный адрес
+type Entry struct {
+	Address string
+	Reason  Reason
+	// Detail — пояснение: код и ответ сервера для возврата, тип жалобы, источник отписки
+	Detail string
+	// CreatedAt — когда адрес исключен
+	CreatedAt time.Time
+}
+
+// Store — хранилище списка исключений. Адреса сравниваются без учета регистра;
+// повторное добавление адреса заменяет причину, пояснение и время.
+type Store interface {
+	email.SuppressionList
+	// Add добавляет адреса; пустая причина считается ReasonManual, нулевое время — текущим
+	Add(ctx context.Context, entries ...Entry) error
+	// Remove убирает адреса из списка; отсутствующие адреса пропускаются
+	Remove(ctx context.Context, addresses ...string) error
+	// Clear очищает список целиком
+	Clear(ctx context.Context) error
+	// Entries возвращает все исключенные адреса в порядке сортировки
+	Entries(ctx context.Context) ([]Entry, error)
+	Close() error
+}
+
+// Open открывает хранилище по строке location: postgres:// или postgresql:// — таблица в PostgreSQL,
+// иначе путь к CSV-файлу
+func Open(ctx context.Context, location string) (Store, error) {
+	if location == "" {
+		return nil, errors.New("suppression store location is empty")
+	}
+	if strings.HasPrefix(location, "postgres://") || strings.HasPrefix(location, "postgresql://") {
+		return OpenPostgresStore(ctx, location)
+	}
+	return OpenFileStore(location)
+}
+
+// Normalize приводит адрес к виду, в котором он хранится в списке
+func Normalize(address string) string {
+	return strings.ToLower(strings.TrimSpace(address))
+}
+
+// FromReports превращает окончательные возвраты и жалобы в записи списка;
+// задержки и временные ошибки пропускаются
+func FromReports(reports []bounce.Report) []Entry {
+	var entries []Entry
+	for _, report := range reports {
+		if !report.Permanent || report.Recipient == "" {
+			continue
+		}
+
+		entry := Entry{Address: report.Recipient, Reason: ReasonBounce, CreatedAt: report.Date}
+		if report.Type == bounce.TypeComplaint {
+			entry.Reason = ReasonComplaint
+		}
+		// Пояснение вида "5.1.1 550 5.1.1 User unknown" избыточно: код уже есть в тексте ответа
+		entry.Detail = report.Diagnostic
+		if report.Status != "" && !strings.Contains(report.Diagnostic, report.Status) {
+			entry.Detail = strings.TrimSpace(report.Status + " " + report.Diagnostic)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// normalizeEntries проверяет записи перед добавлением и заполняет значения по умолчанию
+func normalizeEntries(entries []Entry, now time.Time) ([]Entry, error) {
+	normalized := make([]Entry, 0, len(entries))
+	for _, entry := range entries {
+		entry.Address = Normalize(entry.Address)
+		if entry.Address == "" || !strings.Contains(entry.Address, "@") {
+			return nil, errors.New("invalid suppression entry: address must contain @")
+		}
+		if strings.ContainsAny(entry.Address, "\r\n,") {
+			return nil, errors.New("invalid suppression entry: address contains forbidden characters")
+		}
+		if entry.Reason == "" {
+			entry.Reason = ReasonManual
+		}
+		if entry.CreatedAt.IsZero() {
+			entry.CreatedAt = now
+		}
+		entry.CreatedAt = entry.CreatedAt.UTC().Truncate(time.Second)
+		normalized = append(normalized, entry)
+	}
+	return normalized, nil
+}
@@ -0,0 +1,90 @@
+package suppression
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/mclyashko/IPORPIS/internal/email"
+)
+
+// ErrInvalidToken возвращается для токена отписки, который поврежден или подписан другим ключом
+var ErrInvalidToken = errors.New("invalid unsubscribe token")
+
+// minSecretLength — минимальная длина ключа подписи токенов в байтах
+const minSecretLength = 16
+
+// Unsubscriber выдает персональные ссылки отписки и проверяет их. Токен в ссылке содержит адрес
+// получателя и его HMAC-SHA256, поэтому ссылку нельзя подделать для чужого адреса, а выданные
+// токены не нужно нигде хранить.
+type Unsubscriber struct {
+	baseURL *url.URL
+	mailto  string
+	secret  []byte
+}
+
+// NewUnsubscriber создает выдачу ссылок вида baseURL?token=...; secret — ключ подписи токенов
+// не короче 16 байт, mailto — необязательный адрес для отписки письмом
+func NewUnsubscriber(baseURL string, secret []byte, mailto string) (*Unsubscriber, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("invalid unsubscribe URL %q: must be an absolute http(s) URL", baseURL)
+	}
+	if len(secret) < minSecretLength {
+		return nil, fmt.Errorf("unsubscribe secret must be at least %d bytes", minSecretLength)
+	}
+	return &Unsubscriber{baseURL: u, mailto: mailto, secret: secret}, nil
+}
+
+// Token возвращает токен отписки для адреса
+func (u *Unsubscriber) Token(address string) string {
+	address = Normalize(address)
+	return base64.RawURLEncoding.EncodeToString([]byte(address)) + "." +
+		base64.RawURLEncoding.EncodeToString(u.sign(address))
+}
+
+// Verify проверяет токен и возвращает адрес, для которого он выдан
+func (u *Unsubscriber) Verify(token string) (string, error) {
+	encodedAddress, encodedMAC, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", ErrInvalidToken
+	}
+	address, err := base64.RawURLEncoding.DecodeString(encodedAddress)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil || !hmac.Equal(mac, u.sign(string(address))) {
+		return "", ErrInvalidToken
+	}
+	return string(address), nil
+}
+
+// URL возвращает персональную ссылку отписки для адреса
+func (u *Unsubscriber) URL(address string) string {
+	link := *u.baseURL
+	query := link.Query()
+	query.Set("token", u.Token(address))
+	link.RawQuery = query.Encode()
+	return link.String()
+}
+
+// ListUnsubscribe возвращает способы отписки для заголовков письма; отписка в один клик
+// включается, только если ссылка https, как того требует RFC 8058
+func (u *Unsubscriber) ListUnsubscribe(recipient string) *email.ListUnsubscribe {
+	return &email.ListUnsubscribe{
+		URL:      u.URL(recipient),
+		Mailto:   u.mailto,
+		OneClick: u.baseURL.Scheme == "https",
+	}
+}
+
+func (u *Unsubscriber) sign(address string) []byte {
+	mac := hmac.New(sha256.New, u.secret)
+	mac.Write([]byte(address))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,84 @@
+package suppression_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/mclyashko/IPORPIS/internal/suppression"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func TestUnsubscriberToken(t *testing.T) {
+	unsubscriber, err := suppression.NewUnsubscriber("https://mail.example.com/unsubscribe", []byte(testSecret), "")
+	if err != nil {
+		t.Fatalf("NewUnsubscriber: %v", err)
+	}
+
+	token := unsubscriber.Token("Ivan@Example.org")
+	address, err := unsubscriber.Verify(token)
+	if err != nil || address != "ivan@example.org" {
+		t.Errorf("Verify = %q, %v", address, err)
+	}
+
+	// Подменить адрес в токене нельзя: подпись выдана для другого
+	_, mac, _ := strings.Cut(token, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte("petr@example.org")) + "." + mac
+	other, err := suppression.NewUnsubscriber("https://mail.example.com/unsubscribe", []byte(strings.ToUpper(testSecret)), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, check := range map[string]func() (string, error){
+		"forged address": func() (string, error) { return unsubscriber.Verify(forged) },
+		"other secret":   func() (string, error) { return other.Verify(token) },
+		"garbage":        func() (string, error) { return unsubscriber.Verify("not a token") },
+		"empty":          func() (string, error) { return unsubscriber.Verify("") },
+	} {
+		if address, err := check(); !errors.Is(err, suppression.ErrInvalidToken) {
+			t.Errorf("%s: Verify = %q, %v, want ErrInvalidToken", name, address, err)
+		}
+	}
+}
+
+func TestUnsubscriberListUnsubscribe(t *testing.T) {
+	unsubscriber, err := suppression.NewUnsubscriber("https://mail.example.com/unsubscribe?list=news", []byte(testSecret), "unsubscribe@example.com")
+	if err != nil {
+		t.Fatalf("NewUnsubscriber: %v", err)
+	}
+
+	links := unsubscriber.ListUnsubscribe("ivan@example.org")
+	if !links.OneClick || links.Mailto != "unsubscribe@example.com" {
+		t.Errorf("links = %+v", links)
+	}
+	u, err := url.Parse(links.URL)
+	if err != nil {
+		t.Fatalf("URL %q: %v", links.URL, err)
+	}
+	if u.Host != "mail.example.com" || u.Path != "/unsubscribe" || u.Query().Get("list") != "news" {
+		t.Errorf("URL = %q, want the base URL with its query kept", links.URL)
+	}
+	if address, err := unsubscriber.Verify(u.Query().Get("token")); err != nil || address != "ivan@example.org" {
+		t.Errorf("token from URL: %q, %v", address, err)
+	}
+
+	// RFC 8058 разрешает отписку в один клик только по https
+	plain, err := suppression.NewUnsubscriber("http://localhost:8080/unsubscribe", []byte(testSecret), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if links := plain.ListUnsubscribe("ivan@example.org"); links.OneClick {
+		t.Error("one-click unsubscribe enabled for an http URL")
+	}
+}
+
+func TestNewUnsubscriberInvalid(t *testing.T) {
+	if _, err := suppression.NewUnsubscriber("https://mail.example.com/unsubscribe", []byte("short"), ""); err == nil {
+		t.Error("NewUnsubscriber accepted a short secret")
+	}
+	if _, err := suppression.NewUnsubscriber("/unsubscribe", []byte(testSecret), ""); err == nil {
+		t.Error("NewUnsubscriber accepted a relative URL")
+	}
+}